@@ -0,0 +1,245 @@
+// Package balancer chooses among the instances of a lookup on behalf of
+// clients, so they don't each have to implement load balancing
+package balancer
+
+import (
+	"errors"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+// ErrNoInstances is returned by Pick when there is nothing to choose from
+var ErrNoInstances = errors.New("no instance available")
+
+// ErrNoKey is returned by Pick for the Hash strategy without a key
+var ErrNoKey = errors.New("the hash strategy requires a key")
+
+// Strategy selects instances among the candidates of a request
+type Strategy string
+
+const (
+	RoundRobin  Strategy = "roundRobin"
+	Random      Strategy = "random"
+	Weighted    Strategy = "weighted" // random, in proportion to instance weights
+	LeastRecent Strategy = "leastRecent"
+	Hash        Strategy = "hash" // consistent hashing of the request key
+)
+
+// Valid reports whether s is a known strategy
+func (s Strategy) Valid() bool {
+	switch s {
+	case RoundRobin, Random, Weighted, LeastRecent, Hash:
+		return true
+	}
+	return false
+}
+
+// sweepInterval is how often state no longer in use is dropped
+const sweepInterval = time.Minute
+
+// Request describes a choice among candidates
+type Request struct {
+	// Pool identifies the candidate set, e.g. the lookup filter; round
+	// robin and sessions are tracked per pool
+	Pool     string
+	Strategy Strategy // empty for RoundRobin
+	Key      string   // hashed by the Hash strategy
+	// Session pins the first instance chosen for it until it is no longer
+	// a candidate or the session is idle for the balancer's session TTL
+	Session string
+	Count   int // instances to return, 0 for 1
+}
+
+type instanceKey struct {
+	serviceName string
+	id          string
+}
+
+func keyOf(inst models.Instance) instanceKey {
+	return instanceKey{serviceName: inst.ServiceName, id: inst.ID}
+}
+
+type counter struct {
+	next     uint64
+	lastUsed time.Time
+}
+
+type session struct {
+	instance instanceKey
+	expires  time.Time
+}
+
+// Balancer picks instances and keeps the state strategies and sessions
+// need. The state is local to the server.
+type Balancer struct {
+	sessionTTL time.Duration
+	now        func() time.Time
+
+	mu        sync.Mutex
+	counters  map[string]*counter
+	returned  map[instanceKey]time.Time // when each instance was last returned
+	sessions  map[string]session        // by pool and session id
+	lastSweep time.Time
+}
+
+// New creates a balancer forgetting sessions idle for sessionTTL
+func New(sessionTTL time.Duration) *Balancer {
+	return &Balancer{
+		sessionTTL: sessionTTL,
+		now:        time.Now,
+		counters:   make(map[string]*counter),
+		returned:   make(map[instanceKey]time.Time),
+		sessions:   make(map[string]session),
+	}
+}
+
+// Pick returns up to req.Count distinct candidates in order of preference
+func (b *Balancer) Pick(candidates []models.Instance, req Request) ([]models.Instance, error) {
+	if len(candidates) == 0 {
+		return nil, ErrNoInstances
+	}
+	if req.Strategy == Hash && req.Key == "" {
+		return nil, ErrNoKey
+	}
+	count := min(max(req.Count, 1), len(candidates))
+	now := b.now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweep(now)
+
+	var picked []models.Instance
+	rest := candidates
+	sessionKey := req.Pool + "\x00" + req.Session
+	if req.Session != "" {
+		if s, ok := b.sessions[sessionKey]; ok && now.Before(s.expires) {
+			if i := slices.IndexFunc(candidates, func(inst models.Instance) bool { return keyOf(inst) == s.instance }); i >= 0 {
+				picked = append(picked, candidates[i])
+				rest = slices.Delete(slices.Clone(candidates), i, i+1)
+			}
+		}
+	}
+	picked = append(picked, b.choose(rest, req, count-len(picked), now)...)
+
+	if req.Session != "" {
+		b.sessions[sessionKey] = session{instance: keyOf(picked[0]), expires: now.Add(b.sessionTTL)}
+	}
+	for _, inst := range picked {
+		b.returned[keyOf(inst)] = now
+	}
+	return picked, nil
+}
+
+// choose applies the request's strategy to take n of candidates. Callers
+// hold b.mu.
+func (b *Balancer) choose(candidates []models.Instance, req Request, n int, now time.Time) []models.Instance {
+	if n <= 0 {
+		return nil
+	}
+	switch req.Strategy {
+	case Random:
+		return rank(candidates, n, func(models.Instance) float64 { return rand.Float64() })
+	case Weighted:
+		// Efraimidis-Spirakis: weighted sampling without replacement
+		return rank(candidates, n, func(inst models.Instance) float64 {
+			return math.Pow(rand.Float64(), 1/weight(inst))
+		})
+	case LeastRecent:
+		// Never returned instances first, ties in candidate order
+		ordered := slices.Clone(candidates)
+		slices.SortStableFunc(ordered, func(x, y models.Instance) int {
+			return b.returned[keyOf(x)].Compare(b.returned[keyOf(y)])
+		})
+		return ordered[:n]
+	case Hash:
+		// Weighted rendezvous hashing: each instance scores the key and
+		// the best scores win, so only keys of a removed instance move
+		return rank(candidates, n, func(inst models.Instance) float64 {
+			h := fnv.New64a()
+			h.Write([]byte(req.Key))
+			h.Write([]byte{0})
+			h.Write([]byte(inst.ServiceName + "\x00" + inst.ID))
+			u := (float64(h.Sum64()>>11) + 0.5) / (1 << 53) // uniform in (0, 1)
+			return -weight(inst) / math.Log(u)
+		})
+	}
+
+	c, ok := b.counters[req.Pool]
+	if !ok {
+		c = &counter{}
+		b.counters[req.Pool] = c
+	}
+	c.lastUsed = now
+	start := c.next % uint64(len(candidates))
+	c.next++
+	picked := make([]models.Instance, 0, n)
+	for i := range n {
+		picked = append(picked, candidates[(start+uint64(i))%uint64(len(candidates))])
+	}
+	return picked
+}
+
+// rank returns the n candidates with the highest scores
+func rank(candidates []models.Instance, n int, score func(models.Instance) float64) []models.Instance {
+	type scored struct {
+		inst  models.Instance
+		score float64
+	}
+	all := make([]scored, len(candidates))
+	for i, inst := range candidates {
+		all[i] = scored{inst, score(inst)}
+	}
+	slices.SortStableFunc(all, func(x, y scored) int {
+		if x.score > y.score {
+			return -1
+		}
+		if x.score < y.score {
+			return 1
+		}
+		return 0
+	})
+	picked := make([]models.Instance, n)
+	for i := range picked {
+		picked[i] = all[i].inst
+	}
+	return picked
+}
+
+// weight returns the weight of an instance, 1 when it has none
+func weight(inst models.Instance) float64 {
+	if inst.Weight <= 0 {
+		return 1
+	}
+	return float64(inst.Weight)
+}
+
+// sweep drops expired sessions and state unused for a while. Callers hold
+// b.mu.
+func (b *Balancer) sweep(now time.Time) {
+	if now.Sub(b.lastSweep) < sweepInterval {
+		return
+	}
+	b.lastSweep = now
+	idle := max(b.sessionTTL, 10*time.Minute)
+	for k, s := range b.sessions {
+		if !now.Before(s.expires) {
+			delete(b.sessions, k)
+		}
+	}
+	for k, c := range b.counters {
+		if now.Sub(c.lastUsed) > idle {
+			delete(b.counters, k)
+		}
+	}
+	for k, t := range b.returned {
+		if now.Sub(t) > idle {
+			delete(b.returned, k)
+		}
+	}
+}
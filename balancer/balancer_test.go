@@ -0,0 +1,136 @@
+package balancer
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+func pool(ids ...string) []models.Instance {
+	out := make([]models.Instance, len(ids))
+	for i, id := range ids {
+		out[i] = models.Instance{ServiceName: "svc", ID: id}
+	}
+	return out
+}
+
+func pick(t *testing.T, b *Balancer, candidates []models.Instance, req Request) string {
+	t.Helper()
+	got, err := b.Pick(candidates, req)
+	if err != nil {
+		t.Fatalf("Pick() error = %v", err)
+	}
+	return got[0].ID
+}
+
+func TestRoundRobin(t *testing.T) {
+	b := New(time.Minute)
+	candidates := pool("a", "b", "c")
+	var got []string
+	for range 4 {
+		got = append(got, pick(t, b, candidates, Request{Pool: "p", Strategy: RoundRobin}))
+	}
+	if want := []string{"a", "b", "c", "a"}; !slices.Equal(got, want) {
+		t.Errorf("round robin = %v, want %v", got, want)
+	}
+	if id := pick(t, b, candidates, Request{Pool: "other", Strategy: RoundRobin}); id != "a" {
+		t.Errorf("other pool started at %q, want a", id)
+	}
+}
+
+func TestLeastRecent(t *testing.T) {
+	b := New(time.Minute)
+	candidates := pool("a", "b", "c")
+	pick(t, b, candidates, Request{Pool: "p", Strategy: LeastRecent})
+	pick(t, b, candidates, Request{Pool: "p", Strategy: LeastRecent})
+	if id := pick(t, b, candidates, Request{Pool: "p", Strategy: LeastRecent}); id != "c" {
+		t.Errorf("least recent = %q, want c", id)
+	}
+}
+
+func TestHash(t *testing.T) {
+	b := New(time.Minute)
+	candidates := pool("a", "b", "c", "d")
+	first := pick(t, b, candidates, Request{Strategy: Hash, Key: "user-42"})
+	for range 10 {
+		if id := pick(t, b, candidates, Request{Strategy: Hash, Key: "user-42"}); id != first {
+			t.Fatalf("hash picked %q, then %q", first, id)
+		}
+	}
+
+	// Removing another instance must not move the key
+	var rest []models.Instance
+	for _, inst := range candidates {
+		if inst.ID == first || len(rest) < 2 {
+			rest = append(rest, inst)
+		}
+	}
+	if id := pick(t, b, rest, Request{Strategy: Hash, Key: "user-42"}); id != first {
+		t.Errorf("hash moved from %q to %q", first, id)
+	}
+
+	if _, err := b.Pick(candidates, Request{Strategy: Hash}); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Pick() without key error = %v, want ErrNoKey", err)
+	}
+}
+
+func TestWeighted(t *testing.T) {
+	b := New(time.Minute)
+	candidates := pool("a", "b")
+	candidates[1].Weight = 1000
+	counts := map[string]int{}
+	for range 200 {
+		counts[pick(t, b, candidates, Request{Strategy: Weighted})]++
+	}
+	// An unset weight counts as 1, so a is rare but possible
+	if counts["b"] < 190 {
+		t.Errorf("weighted counts = %v, want b to dominate", counts)
+	}
+}
+
+func TestSession(t *testing.T) {
+	b := New(time.Minute)
+	candidates := pool("a", "b", "c")
+	first := pick(t, b, candidates, Request{Pool: "p", Strategy: Random, Session: "s1"})
+	for range 10 {
+		if id := pick(t, b, candidates, Request{Pool: "p", Strategy: Random, Session: "s1"}); id != first {
+			t.Fatalf("session moved from %q to %q", first, id)
+		}
+	}
+
+	var rest []models.Instance
+	for _, inst := range candidates {
+		if inst.ID != first {
+			rest = append(rest, inst)
+		}
+	}
+	moved := pick(t, b, rest, Request{Pool: "p", Strategy: Random, Session: "s1"})
+	if moved == first {
+		t.Fatalf("session stuck to removed instance %q", first)
+	}
+	if id := pick(t, b, candidates, Request{Pool: "p", Strategy: Random, Session: "s1"}); id != moved {
+		t.Errorf("session = %q, want it to stay on %q", id, moved)
+	}
+}
+
+func TestPickCount(t *testing.T) {
+	b := New(time.Minute)
+	got, err := b.Pick(pool("a", "b", "c"), Request{Strategy: Random, Count: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for _, inst := range got {
+		seen[inst.ID] = true
+	}
+	if len(got) != 3 || len(seen) != 3 {
+		t.Errorf("Pick() = %v, want each instance once", got)
+	}
+
+	if _, err := b.Pick(nil, Request{Strategy: Random}); !errors.Is(err, ErrNoInstances) {
+		t.Errorf("Pick() error = %v, want ErrNoInstances", err)
+	}
+}
@@ -0,0 +1,1548 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        (unknown)
+// source: discoverypb/discovery.proto
+
+package discoverypb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type Metadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Environment   string                 `protobuf:"bytes,1,opt,name=environment,proto3" json:"environment,omitempty"` // dev, staging or prod
+	Region        string                 `protobuf:"bytes,2,opt,name=region,proto3" json:"region,omitempty"`
+	Zone          string                 `protobuf:"bytes,3,opt,name=zone,proto3" json:"zone,omitempty"` // optional availability zone within the region
+	Version       int32                  `protobuf:"varint,4,opt,name=version,proto3" json:"version,omitempty"`
+	Developer     string                 `protobuf:"bytes,5,opt,name=developer,proto3" json:"developer,omitempty"`
+	Experimental  bool                   `protobuf:"varint,6,opt,name=experimental,proto3" json:"experimental,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Metadata) Reset() {
+	*x = Metadata{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Metadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metadata) ProtoMessage() {}
+
+func (x *Metadata) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metadata.ProtoReflect.Descriptor instead.
+func (*Metadata) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Metadata) GetEnvironment() string {
+	if x != nil {
+		return x.Environment
+	}
+	return ""
+}
+
+func (x *Metadata) GetRegion() string {
+	if x != nil {
+		return x.Region
+	}
+	return ""
+}
+
+func (x *Metadata) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *Metadata) GetVersion() int32 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *Metadata) GetDeveloper() string {
+	if x != nil {
+		return x.Developer
+	}
+	return ""
+}
+
+func (x *Metadata) GetExperimental() bool {
+	if x != nil {
+		return x.Experimental
+	}
+	return false
+}
+
+// Endpoint is a named port of an instance besides its primary one.
+type Endpoint struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	Name     string                 `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Port     int32                  `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	Protocol string                 `protobuf:"bytes,3,opt,name=protocol,proto3" json:"protocol,omitempty"` // http, https, grpc or tcp
+	Path     string                 `protobuf:"bytes,4,opt,name=path,proto3" json:"path,omitempty"`         // http and https
+	// Address is where clients reach the endpoint, filled in by lookups.
+	Address       string `protobuf:"bytes,5,opt,name=address,proto3" json:"address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Endpoint) Reset() {
+	*x = Endpoint{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Endpoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Endpoint) ProtoMessage() {}
+
+func (x *Endpoint) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Endpoint.ProtoReflect.Descriptor instead.
+func (*Endpoint) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Endpoint) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Endpoint) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *Endpoint) GetProtocol() string {
+	if x != nil {
+		return x.Protocol
+	}
+	return ""
+}
+
+func (x *Endpoint) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Endpoint) GetAddress() string {
+	if x != nil {
+		return x.Address
+	}
+	return ""
+}
+
+// Check is a health check the server runs against an instance.
+type Check struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`          // http, tcp or grpc
+	Port          int32                  `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`         // 0 for the instance's port
+	Path          string                 `protobuf:"bytes,3,opt,name=path,proto3" json:"path,omitempty"`          // http: request path
+	Status        int32                  `protobuf:"varint,4,opt,name=status,proto3" json:"status,omitempty"`     // http: expected status, 0 for any 2xx
+	Service       string                 `protobuf:"bytes,5,opt,name=service,proto3" json:"service,omitempty"`    // grpc: service to check, empty for the server
+	Interval      int32                  `protobuf:"varint,6,opt,name=interval,proto3" json:"interval,omitempty"` // seconds between runs, 0 for 10
+	Timeout       int32                  `protobuf:"varint,7,opt,name=timeout,proto3" json:"timeout,omitempty"`   // seconds, 0 for 2
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Check) Reset() {
+	*x = Check{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Check) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Check) ProtoMessage() {}
+
+func (x *Check) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Check.ProtoReflect.Descriptor instead.
+func (*Check) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Check) GetType() string {
+	if x != nil {
+		return x.Type
+	}
+	return ""
+}
+
+func (x *Check) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *Check) GetPath() string {
+	if x != nil {
+		return x.Path
+	}
+	return ""
+}
+
+func (x *Check) GetStatus() int32 {
+	if x != nil {
+		return x.Status
+	}
+	return 0
+}
+
+func (x *Check) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *Check) GetInterval() int32 {
+	if x != nil {
+		return x.Interval
+	}
+	return 0
+}
+
+func (x *Check) GetTimeout() int32 {
+	if x != nil {
+		return x.Timeout
+	}
+	return 0
+}
+
+// Maintenance takes an instance out of default lookups while it is active.
+type Maintenance struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	State         string                 `protobuf:"bytes,1,opt,name=state,proto3" json:"state,omitempty"` // maintenance or draining
+	Reason        string                 `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	Since         *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=since,proto3" json:"since,omitempty"`
+	Until         *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=until,proto3" json:"until,omitempty"` // unset for never
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Maintenance) Reset() {
+	*x = Maintenance{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Maintenance) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Maintenance) ProtoMessage() {}
+
+func (x *Maintenance) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Maintenance.ProtoReflect.Descriptor instead.
+func (*Maintenance) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Maintenance) GetState() string {
+	if x != nil {
+		return x.State
+	}
+	return ""
+}
+
+func (x *Maintenance) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Maintenance) GetSince() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Since
+	}
+	return nil
+}
+
+func (x *Maintenance) GetUntil() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Until
+	}
+	return nil
+}
+
+type Instance struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	ServiceName string                 `protobuf:"bytes,1,opt,name=service_name,json=serviceName,proto3" json:"service_name,omitempty"`
+	Id          string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Host        string                 `protobuf:"bytes,3,opt,name=host,proto3" json:"host,omitempty"`
+	Port        int32                  `protobuf:"varint,4,opt,name=port,proto3" json:"port,omitempty"`
+	Mode        string                 `protobuf:"bytes,5,opt,name=mode,proto3" json:"mode,omitempty"` // dev, staging or prod
+	Metadata    *Metadata              `protobuf:"bytes,6,opt,name=metadata,proto3" json:"metadata,omitempty"`
+	Labels      map[string]string      `protobuf:"bytes,7,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Tags        []string               `protobuf:"bytes,8,rep,name=tags,proto3" json:"tags,omitempty"`
+	Weight      int32                  `protobuf:"varint,9,opt,name=weight,proto3" json:"weight,omitempty"` // share of weighted load balancing, 0 for 1
+	Endpoints   []*Endpoint            `protobuf:"bytes,10,rep,name=endpoints,proto3" json:"endpoints,omitempty"`
+	Ttl         int32                  `protobuf:"varint,11,opt,name=ttl,proto3" json:"ttl,omitempty"` // lease in seconds, 0 for the server default
+	Checks      []*Check               `protobuf:"bytes,12,rep,name=checks,proto3" json:"checks,omitempty"`
+	// The fields below are maintained by the registry and ignored by Register.
+	Maintenance    *Maintenance           `protobuf:"bytes,13,opt,name=maintenance,proto3" json:"maintenance,omitempty"`
+	Health         string                 `protobuf:"bytes,14,opt,name=health,proto3" json:"health,omitempty"`                                                                         // UP, WARNING or CRITICAL, the worse of reported_health and check_health
+	ReportedHealth string                 `protobuf:"bytes,19,opt,name=reported_health,json=reportedHealth,proto3" json:"reported_health,omitempty"`                                   // health of the last heartbeat, empty before the first
+	Output         string                 `protobuf:"bytes,15,opt,name=output,proto3" json:"output,omitempty"`                                                                         // message of the last heartbeat
+	Load           map[string]float64     `protobuf:"bytes,16,rep,name=load,proto3" json:"load,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"` // load indicators of the last heartbeat
+	CheckHealth    string                 `protobuf:"bytes,20,opt,name=check_health,json=checkHealth,proto3" json:"check_health,omitempty"`                                            // health of the checks' latest round
+	CheckOutput    string                 `protobuf:"bytes,21,opt,name=check_output,json=checkOutput,proto3" json:"check_output,omitempty"`                                            // messages of the checks' latest round
+	LastHeartbeat  *timestamppb.Timestamp `protobuf:"bytes,17,opt,name=last_heartbeat,json=lastHeartbeat,proto3" json:"last_heartbeat,omitempty"`
+	ExpiresAt      *timestamppb.Timestamp `protobuf:"bytes,18,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"` // end of the current lease
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *Instance) Reset() {
+	*x = Instance{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Instance) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Instance) ProtoMessage() {}
+
+func (x *Instance) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Instance.ProtoReflect.Descriptor instead.
+func (*Instance) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Instance) GetServiceName() string {
+	if x != nil {
+		return x.ServiceName
+	}
+	return ""
+}
+
+func (x *Instance) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Instance) GetHost() string {
+	if x != nil {
+		return x.Host
+	}
+	return ""
+}
+
+func (x *Instance) GetPort() int32 {
+	if x != nil {
+		return x.Port
+	}
+	return 0
+}
+
+func (x *Instance) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *Instance) GetMetadata() *Metadata {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+func (x *Instance) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Instance) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Instance) GetWeight() int32 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+func (x *Instance) GetEndpoints() []*Endpoint {
+	if x != nil {
+		return x.Endpoints
+	}
+	return nil
+}
+
+func (x *Instance) GetTtl() int32 {
+	if x != nil {
+		return x.Ttl
+	}
+	return 0
+}
+
+func (x *Instance) GetChecks() []*Check {
+	if x != nil {
+		return x.Checks
+	}
+	return nil
+}
+
+func (x *Instance) GetMaintenance() *Maintenance {
+	if x != nil {
+		return x.Maintenance
+	}
+	return nil
+}
+
+func (x *Instance) GetHealth() string {
+	if x != nil {
+		return x.Health
+	}
+	return ""
+}
+
+func (x *Instance) GetReportedHealth() string {
+	if x != nil {
+		return x.ReportedHealth
+	}
+	return ""
+}
+
+func (x *Instance) GetOutput() string {
+	if x != nil {
+		return x.Output
+	}
+	return ""
+}
+
+func (x *Instance) GetLoad() map[string]float64 {
+	if x != nil {
+		return x.Load
+	}
+	return nil
+}
+
+func (x *Instance) GetCheckHealth() string {
+	if x != nil {
+		return x.CheckHealth
+	}
+	return ""
+}
+
+func (x *Instance) GetCheckOutput() string {
+	if x != nil {
+		return x.CheckOutput
+	}
+	return ""
+}
+
+func (x *Instance) GetLastHeartbeat() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LastHeartbeat
+	}
+	return nil
+}
+
+func (x *Instance) GetExpiresAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return nil
+}
+
+type RegisterRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Instance      *Instance              `protobuf:"bytes,1,opt,name=instance,proto3" json:"instance,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterRequest) Reset() {
+	*x = RegisterRequest{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterRequest) ProtoMessage() {}
+
+func (x *RegisterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterRequest.ProtoReflect.Descriptor instead.
+func (*RegisterRequest) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *RegisterRequest) GetInstance() *Instance {
+	if x != nil {
+		return x.Instance
+	}
+	return nil
+}
+
+type RegisterResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Instance      *Instance              `protobuf:"bytes,1,opt,name=instance,proto3" json:"instance,omitempty"`
+	Created       bool                   `protobuf:"varint,2,opt,name=created,proto3" json:"created,omitempty"` // false when an existing registration was replaced
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RegisterResponse) Reset() {
+	*x = RegisterResponse{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RegisterResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RegisterResponse) ProtoMessage() {}
+
+func (x *RegisterResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RegisterResponse.ProtoReflect.Descriptor instead.
+func (*RegisterResponse) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RegisterResponse) GetInstance() *Instance {
+	if x != nil {
+		return x.Instance
+	}
+	return nil
+}
+
+func (x *RegisterResponse) GetCreated() bool {
+	if x != nil {
+		return x.Created
+	}
+	return false
+}
+
+type DeregisterRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServiceName   string                 `protobuf:"bytes,1,opt,name=service_name,json=serviceName,proto3" json:"service_name,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeregisterRequest) Reset() {
+	*x = DeregisterRequest{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeregisterRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeregisterRequest) ProtoMessage() {}
+
+func (x *DeregisterRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeregisterRequest.ProtoReflect.Descriptor instead.
+func (*DeregisterRequest) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *DeregisterRequest) GetServiceName() string {
+	if x != nil {
+		return x.ServiceName
+	}
+	return ""
+}
+
+func (x *DeregisterRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+type DeregisterResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Instance      *Instance              `protobuf:"bytes,1,opt,name=instance,proto3" json:"instance,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeregisterResponse) Reset() {
+	*x = DeregisterResponse{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeregisterResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeregisterResponse) ProtoMessage() {}
+
+func (x *DeregisterResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeregisterResponse.ProtoReflect.Descriptor instead.
+func (*DeregisterResponse) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *DeregisterResponse) GetInstance() *Instance {
+	if x != nil {
+		return x.Instance
+	}
+	return nil
+}
+
+type HeartbeatRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ServiceName   string                 `protobuf:"bytes,1,opt,name=service_name,json=serviceName,proto3" json:"service_name,omitempty"`
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Status        string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"` // passing, warning or critical; empty for passing
+	Output        string                 `protobuf:"bytes,4,opt,name=output,proto3" json:"output,omitempty"`
+	Load          map[string]float64     `protobuf:"bytes,5,rep,name=load,proto3" json:"load,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatRequest) Reset() {
+	*x = HeartbeatRequest{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatRequest) ProtoMessage() {}
+
+func (x *HeartbeatRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatRequest.ProtoReflect.Descriptor instead.
+func (*HeartbeatRequest) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *HeartbeatRequest) GetServiceName() string {
+	if x != nil {
+		return x.ServiceName
+	}
+	return ""
+}
+
+func (x *HeartbeatRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *HeartbeatRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *HeartbeatRequest) GetOutput() string {
+	if x != nil {
+		return x.Output
+	}
+	return ""
+}
+
+func (x *HeartbeatRequest) GetLoad() map[string]float64 {
+	if x != nil {
+		return x.Load
+	}
+	return nil
+}
+
+type HeartbeatResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Heartbeats    uint64                 `protobuf:"varint,1,opt,name=heartbeats,proto3" json:"heartbeats,omitempty"` // heartbeats received on the stream
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *HeartbeatResponse) Reset() {
+	*x = HeartbeatResponse{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *HeartbeatResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*HeartbeatResponse) ProtoMessage() {}
+
+func (x *HeartbeatResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use HeartbeatResponse.ProtoReflect.Descriptor instead.
+func (*HeartbeatResponse) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *HeartbeatResponse) GetHeartbeats() uint64 {
+	if x != nil {
+		return x.Heartbeats
+	}
+	return 0
+}
+
+// Filter selects instances like the filter parameters of GET /lookup.
+type Filter struct {
+	state              protoimpl.MessageState `protogen:"open.v1"`
+	Service            string                 `protobuf:"bytes,1,opt,name=service,proto3" json:"service,omitempty"`
+	Mode               string                 `protobuf:"bytes,2,opt,name=mode,proto3" json:"mode,omitempty"`
+	Health             []string               `protobuf:"bytes,3,rep,name=health,proto3" json:"health,omitempty"`                                                                                              // health states to select
+	MaxLoad            map[string]float64     `protobuf:"bytes,4,rep,name=max_load,json=maxLoad,proto3" json:"max_load,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"fixed64,2,opt,name=value"` // upper bounds of load indicators
+	IncludeUnhealthy   bool                   `protobuf:"varint,5,opt,name=include_unhealthy,json=includeUnhealthy,proto3" json:"include_unhealthy,omitempty"`                                                 // include CRITICAL instances
+	IncludeMaintenance bool                   `protobuf:"varint,6,opt,name=include_maintenance,json=includeMaintenance,proto3" json:"include_maintenance,omitempty"`                                           // include instances in maintenance
+	Labels             map[string]string      `protobuf:"bytes,7,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`                    // label values to select
+	Tags               []string               `protobuf:"bytes,8,rep,name=tags,proto3" json:"tags,omitempty"`                                                                                                  // tags an instance must all carry
+	Endpoint           string                 `protobuf:"bytes,9,opt,name=endpoint,proto3" json:"endpoint,omitempty"`                                                                                          // name of an endpoint to expose
+	// Expression is a filter expression, e.g. "version >= 2 and region in
+	// (us-east, eu-west)".
+	Expression string `protobuf:"bytes,10,opt,name=expression,proto3" json:"expression,omitempty"`
+	// Metadata compares metadata fields by equality. Values are typed like
+	// expression values, so "2" matches a numeric version. Keys naming a
+	// lookup parameter, such as limit or label.<key>, are rejected.
+	Metadata      map[string]string `protobuf:"bytes,11,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Filter) Reset() {
+	*x = Filter{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Filter) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Filter) ProtoMessage() {}
+
+func (x *Filter) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Filter.ProtoReflect.Descriptor instead.
+func (*Filter) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *Filter) GetService() string {
+	if x != nil {
+		return x.Service
+	}
+	return ""
+}
+
+func (x *Filter) GetMode() string {
+	if x != nil {
+		return x.Mode
+	}
+	return ""
+}
+
+func (x *Filter) GetHealth() []string {
+	if x != nil {
+		return x.Health
+	}
+	return nil
+}
+
+func (x *Filter) GetMaxLoad() map[string]float64 {
+	if x != nil {
+		return x.MaxLoad
+	}
+	return nil
+}
+
+func (x *Filter) GetIncludeUnhealthy() bool {
+	if x != nil {
+		return x.IncludeUnhealthy
+	}
+	return false
+}
+
+func (x *Filter) GetIncludeMaintenance() bool {
+	if x != nil {
+		return x.IncludeMaintenance
+	}
+	return false
+}
+
+func (x *Filter) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Filter) GetTags() []string {
+	if x != nil {
+		return x.Tags
+	}
+	return nil
+}
+
+func (x *Filter) GetEndpoint() string {
+	if x != nil {
+		return x.Endpoint
+	}
+	return ""
+}
+
+func (x *Filter) GetExpression() string {
+	if x != nil {
+		return x.Expression
+	}
+	return ""
+}
+
+func (x *Filter) GetMetadata() map[string]string {
+	if x != nil {
+		return x.Metadata
+	}
+	return nil
+}
+
+type LookupRequest struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	Filter            *Filter                `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	Limit             int32                  `protobuf:"varint,2,opt,name=limit,proto3" json:"limit,omitempty"`                                                    // page size, 0 for every instance
+	Cursor            string                 `protobuf:"bytes,3,opt,name=cursor,proto3" json:"cursor,omitempty"`                                                   // next_cursor of the previous page
+	Sort              string                 `protobuf:"bytes,4,opt,name=sort,proto3" json:"sort,omitempty"`                                                       // serviceName, lastHeartbeat or version, - prefix for descending
+	PreferRegion      string                 `protobuf:"bytes,5,opt,name=prefer_region,json=preferRegion,proto3" json:"prefer_region,omitempty"`                   // prefer instances in this region
+	PreferZone        string                 `protobuf:"bytes,6,opt,name=prefer_zone,json=preferZone,proto3" json:"prefer_zone,omitempty"`                         // prefer instances in this zone, then prefer_region
+	MinHealthy        int32                  `protobuf:"varint,7,opt,name=min_healthy,json=minHealthy,proto3" json:"min_healthy,omitempty"`                        // healthy instances a preferred tier needs, 0 for 1
+	MinHealthyPercent int32                  `protobuf:"varint,8,opt,name=min_healthy_percent,json=minHealthyPercent,proto3" json:"min_healthy_percent,omitempty"` // share of all healthy instances a preferred tier needs
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
+}
+
+func (x *LookupRequest) Reset() {
+	*x = LookupRequest{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupRequest) ProtoMessage() {}
+
+func (x *LookupRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupRequest.ProtoReflect.Descriptor instead.
+func (*LookupRequest) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *LookupRequest) GetFilter() *Filter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *LookupRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *LookupRequest) GetCursor() string {
+	if x != nil {
+		return x.Cursor
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetSort() string {
+	if x != nil {
+		return x.Sort
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetPreferRegion() string {
+	if x != nil {
+		return x.PreferRegion
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetPreferZone() string {
+	if x != nil {
+		return x.PreferZone
+	}
+	return ""
+}
+
+func (x *LookupRequest) GetMinHealthy() int32 {
+	if x != nil {
+		return x.MinHealthy
+	}
+	return 0
+}
+
+func (x *LookupRequest) GetMinHealthyPercent() int32 {
+	if x != nil {
+		return x.MinHealthyPercent
+	}
+	return 0
+}
+
+type LookupResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Instances     []*Instance            `protobuf:"bytes,1,rep,name=instances,proto3" json:"instances,omitempty"`
+	NextCursor    string                 `protobuf:"bytes,2,opt,name=next_cursor,json=nextCursor,proto3" json:"next_cursor,omitempty"` // empty after the last page
+	Index         uint64                 `protobuf:"varint,3,opt,name=index,proto3" json:"index,omitempty"`                            // revision of the registry the result reflects
+	Locality      string                 `protobuf:"bytes,4,opt,name=locality,proto3" json:"locality,omitempty"`                       // zone, region or any when a preference was given
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LookupResponse) Reset() {
+	*x = LookupResponse{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LookupResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LookupResponse) ProtoMessage() {}
+
+func (x *LookupResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LookupResponse.ProtoReflect.Descriptor instead.
+func (*LookupResponse) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *LookupResponse) GetInstances() []*Instance {
+	if x != nil {
+		return x.Instances
+	}
+	return nil
+}
+
+func (x *LookupResponse) GetNextCursor() string {
+	if x != nil {
+		return x.NextCursor
+	}
+	return ""
+}
+
+func (x *LookupResponse) GetIndex() uint64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
+func (x *LookupResponse) GetLocality() string {
+	if x != nil {
+		return x.Locality
+	}
+	return ""
+}
+
+type WatchRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Filter *Filter                `protobuf:"bytes,1,opt,name=filter,proto3" json:"filter,omitempty"`
+	// Since is the last revision seen by a reconnecting client, to receive
+	// only the events it missed instead of a new snapshot.
+	Since         uint64 `protobuf:"varint,2,opt,name=since,proto3" json:"since,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *WatchRequest) Reset() {
+	*x = WatchRequest{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchRequest) ProtoMessage() {}
+
+func (x *WatchRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchRequest.ProtoReflect.Descriptor instead.
+func (*WatchRequest) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *WatchRequest) GetFilter() *Filter {
+	if x != nil {
+		return x.Filter
+	}
+	return nil
+}
+
+func (x *WatchRequest) GetSince() uint64 {
+	if x != nil {
+		return x.Since
+	}
+	return 0
+}
+
+// WatchEvent is a snapshot of the selected instances or a change to one of
+// them, with the same actions as the events of /ws.
+type WatchEvent struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Action         string                 `protobuf:"bytes,1,opt,name=action,proto3" json:"action,omitempty"` // snapshot, register, update, heartbeat, health, expire, maintenance or deregister
+	Revision       uint64                 `protobuf:"varint,2,opt,name=revision,proto3" json:"revision,omitempty"`
+	Instance       *Instance              `protobuf:"bytes,3,opt,name=instance,proto3" json:"instance,omitempty"`                                   // the changed instance
+	Instances      []*Instance            `protobuf:"bytes,4,rep,name=instances,proto3" json:"instances,omitempty"`                                 // the snapshot
+	PreviousHealth string                 `protobuf:"bytes,5,opt,name=previous_health,json=previousHealth,proto3" json:"previous_health,omitempty"` // health before a health event
+	Left           bool                   `protobuf:"varint,6,opt,name=left,proto3" json:"left,omitempty"`                                          // the instance no longer matches the filter; drop it
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *WatchEvent) Reset() {
+	*x = WatchEvent{}
+	mi := &file_discoverypb_discovery_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *WatchEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchEvent) ProtoMessage() {}
+
+func (x *WatchEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_discoverypb_discovery_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchEvent.ProtoReflect.Descriptor instead.
+func (*WatchEvent) Descriptor() ([]byte, []int) {
+	return file_discoverypb_discovery_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *WatchEvent) GetAction() string {
+	if x != nil {
+		return x.Action
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetRevision() uint64 {
+	if x != nil {
+		return x.Revision
+	}
+	return 0
+}
+
+func (x *WatchEvent) GetInstance() *Instance {
+	if x != nil {
+		return x.Instance
+	}
+	return nil
+}
+
+func (x *WatchEvent) GetInstances() []*Instance {
+	if x != nil {
+		return x.Instances
+	}
+	return nil
+}
+
+func (x *WatchEvent) GetPreviousHealth() string {
+	if x != nil {
+		return x.PreviousHealth
+	}
+	return ""
+}
+
+func (x *WatchEvent) GetLeft() bool {
+	if x != nil {
+		return x.Left
+	}
+	return false
+}
+
+var File_discoverypb_discovery_proto protoreflect.FileDescriptor
+
+const file_discoverypb_discovery_proto_rawDesc = "" +
+	"\n" +
+	"\x1bdiscoverypb/discovery.proto\x12\fdiscovery.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xb4\x01\n" +
+	"\bMetadata\x12 \n" +
+	"\venvironment\x18\x01 \x01(\tR\venvironment\x12\x16\n" +
+	"\x06region\x18\x02 \x01(\tR\x06region\x12\x12\n" +
+	"\x04zone\x18\x03 \x01(\tR\x04zone\x12\x18\n" +
+	"\aversion\x18\x04 \x01(\x05R\aversion\x12\x1c\n" +
+	"\tdeveloper\x18\x05 \x01(\tR\tdeveloper\x12\"\n" +
+	"\fexperimental\x18\x06 \x01(\bR\fexperimental\"|\n" +
+	"\bEndpoint\x12\x12\n" +
+	"\x04name\x18\x01 \x01(\tR\x04name\x12\x12\n" +
+	"\x04port\x18\x02 \x01(\x05R\x04port\x12\x1a\n" +
+	"\bprotocol\x18\x03 \x01(\tR\bprotocol\x12\x12\n" +
+	"\x04path\x18\x04 \x01(\tR\x04path\x12\x18\n" +
+	"\aaddress\x18\x05 \x01(\tR\aaddress\"\xab\x01\n" +
+	"\x05Check\x12\x12\n" +
+	"\x04type\x18\x01 \x01(\tR\x04type\x12\x12\n" +
+	"\x04port\x18\x02 \x01(\x05R\x04port\x12\x12\n" +
+	"\x04path\x18\x03 \x01(\tR\x04path\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\x05R\x06status\x12\x18\n" +
+	"\aservice\x18\x05 \x01(\tR\aservice\x12\x1a\n" +
+	"\binterval\x18\x06 \x01(\x05R\binterval\x12\x18\n" +
+	"\atimeout\x18\a \x01(\x05R\atimeout\"\x9f\x01\n" +
+	"\vMaintenance\x12\x14\n" +
+	"\x05state\x18\x01 \x01(\tR\x05state\x12\x16\n" +
+	"\x06reason\x18\x02 \x01(\tR\x06reason\x120\n" +
+	"\x05since\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\x05since\x120\n" +
+	"\x05until\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\x05until\"\x8e\a\n" +
+	"\bInstance\x12!\n" +
+	"\fservice_name\x18\x01 \x01(\tR\vserviceName\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12\x12\n" +
+	"\x04host\x18\x03 \x01(\tR\x04host\x12\x12\n" +
+	"\x04port\x18\x04 \x01(\x05R\x04port\x12\x12\n" +
+	"\x04mode\x18\x05 \x01(\tR\x04mode\x122\n" +
+	"\bmetadata\x18\x06 \x01(\v2\x16.discovery.v1.MetadataR\bmetadata\x12:\n" +
+	"\x06labels\x18\a \x03(\v2\".discovery.v1.Instance.LabelsEntryR\x06labels\x12\x12\n" +
+	"\x04tags\x18\b \x03(\tR\x04tags\x12\x16\n" +
+	"\x06weight\x18\t \x01(\x05R\x06weight\x124\n" +
+	"\tendpoints\x18\n" +
+	" \x03(\v2\x16.discovery.v1.EndpointR\tendpoints\x12\x10\n" +
+	"\x03ttl\x18\v \x01(\x05R\x03ttl\x12+\n" +
+	"\x06checks\x18\f \x03(\v2\x13.discovery.v1.CheckR\x06checks\x12;\n" +
+	"\vmaintenance\x18\r \x01(\v2\x19.discovery.v1.MaintenanceR\vmaintenance\x12\x16\n" +
+	"\x06health\x18\x0e \x01(\tR\x06health\x12'\n" +
+	"\x0freported_health\x18\x13 \x01(\tR\x0ereportedHealth\x12\x16\n" +
+	"\x06output\x18\x0f \x01(\tR\x06output\x124\n" +
+	"\x04load\x18\x10 \x03(\v2 .discovery.v1.Instance.LoadEntryR\x04load\x12!\n" +
+	"\fcheck_health\x18\x14 \x01(\tR\vcheckHealth\x12!\n" +
+	"\fcheck_output\x18\x15 \x01(\tR\vcheckOutput\x12A\n" +
+	"\x0elast_heartbeat\x18\x11 \x01(\v2\x1a.google.protobuf.TimestampR\rlastHeartbeat\x129\n" +
+	"\n" +
+	"expires_at\x18\x12 \x01(\v2\x1a.google.protobuf.TimestampR\texpiresAt\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a7\n" +
+	"\tLoadEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x01\"E\n" +
+	"\x0fRegisterRequest\x122\n" +
+	"\binstance\x18\x01 \x01(\v2\x16.discovery.v1.InstanceR\binstance\"`\n" +
+	"\x10RegisterResponse\x122\n" +
+	"\binstance\x18\x01 \x01(\v2\x16.discovery.v1.InstanceR\binstance\x12\x18\n" +
+	"\acreated\x18\x02 \x01(\bR\acreated\"F\n" +
+	"\x11DeregisterRequest\x12!\n" +
+	"\fservice_name\x18\x01 \x01(\tR\vserviceName\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\"H\n" +
+	"\x12DeregisterResponse\x122\n" +
+	"\binstance\x18\x01 \x01(\v2\x16.discovery.v1.InstanceR\binstance\"\xec\x01\n" +
+	"\x10HeartbeatRequest\x12!\n" +
+	"\fservice_name\x18\x01 \x01(\tR\vserviceName\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x16\n" +
+	"\x06output\x18\x04 \x01(\tR\x06output\x12<\n" +
+	"\x04load\x18\x05 \x03(\v2(.discovery.v1.HeartbeatRequest.LoadEntryR\x04load\x1a7\n" +
+	"\tLoadEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x01\"3\n" +
+	"\x11HeartbeatResponse\x12\x1e\n" +
+	"\n" +
+	"heartbeats\x18\x01 \x01(\x04R\n" +
+	"heartbeats\"\xe8\x04\n" +
+	"\x06Filter\x12\x18\n" +
+	"\aservice\x18\x01 \x01(\tR\aservice\x12\x12\n" +
+	"\x04mode\x18\x02 \x01(\tR\x04mode\x12\x16\n" +
+	"\x06health\x18\x03 \x03(\tR\x06health\x12<\n" +
+	"\bmax_load\x18\x04 \x03(\v2!.discovery.v1.Filter.MaxLoadEntryR\amaxLoad\x12+\n" +
+	"\x11include_unhealthy\x18\x05 \x01(\bR\x10includeUnhealthy\x12/\n" +
+	"\x13include_maintenance\x18\x06 \x01(\bR\x12includeMaintenance\x128\n" +
+	"\x06labels\x18\a \x03(\v2 .discovery.v1.Filter.LabelsEntryR\x06labels\x12\x12\n" +
+	"\x04tags\x18\b \x03(\tR\x04tags\x12\x1a\n" +
+	"\bendpoint\x18\t \x01(\tR\bendpoint\x12\x1e\n" +
+	"\n" +
+	"expression\x18\n" +
+	" \x01(\tR\n" +
+	"expression\x12>\n" +
+	"\bmetadata\x18\v \x03(\v2\".discovery.v1.Filter.MetadataEntryR\bmetadata\x1a:\n" +
+	"\fMaxLoadEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x01R\x05value:\x028\x01\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\x1a;\n" +
+	"\rMetadataEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"\x96\x02\n" +
+	"\rLookupRequest\x12,\n" +
+	"\x06filter\x18\x01 \x01(\v2\x14.discovery.v1.FilterR\x06filter\x12\x14\n" +
+	"\x05limit\x18\x02 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06cursor\x18\x03 \x01(\tR\x06cursor\x12\x12\n" +
+	"\x04sort\x18\x04 \x01(\tR\x04sort\x12#\n" +
+	"\rprefer_region\x18\x05 \x01(\tR\fpreferRegion\x12\x1f\n" +
+	"\vprefer_zone\x18\x06 \x01(\tR\n" +
+	"preferZone\x12\x1f\n" +
+	"\vmin_healthy\x18\a \x01(\x05R\n" +
+	"minHealthy\x12.\n" +
+	"\x13min_healthy_percent\x18\b \x01(\x05R\x11minHealthyPercent\"\x99\x01\n" +
+	"\x0eLookupResponse\x124\n" +
+	"\tinstances\x18\x01 \x03(\v2\x16.discovery.v1.InstanceR\tinstances\x12\x1f\n" +
+	"\vnext_cursor\x18\x02 \x01(\tR\n" +
+	"nextCursor\x12\x14\n" +
+	"\x05index\x18\x03 \x01(\x04R\x05index\x12\x1a\n" +
+	"\blocality\x18\x04 \x01(\tR\blocality\"R\n" +
+	"\fWatchRequest\x12,\n" +
+	"\x06filter\x18\x01 \x01(\v2\x14.discovery.v1.FilterR\x06filter\x12\x14\n" +
+	"\x05since\x18\x02 \x01(\x04R\x05since\"\xe7\x01\n" +
+	"\n" +
+	"WatchEvent\x12\x16\n" +
+	"\x06action\x18\x01 \x01(\tR\x06action\x12\x1a\n" +
+	"\brevision\x18\x02 \x01(\x04R\brevision\x122\n" +
+	"\binstance\x18\x03 \x01(\v2\x16.discovery.v1.InstanceR\binstance\x124\n" +
+	"\tinstances\x18\x04 \x03(\v2\x16.discovery.v1.InstanceR\tinstances\x12'\n" +
+	"\x0fprevious_health\x18\x05 \x01(\tR\x0epreviousHealth\x12\x12\n" +
+	"\x04left\x18\x06 \x01(\bR\x04left2\xfd\x02\n" +
+	"\tDiscovery\x12I\n" +
+	"\bRegister\x12\x1d.discovery.v1.RegisterRequest\x1a\x1e.discovery.v1.RegisterResponse\x12O\n" +
+	"\n" +
+	"Deregister\x12\x1f.discovery.v1.DeregisterRequest\x1a .discovery.v1.DeregisterResponse\x12N\n" +
+	"\tHeartbeat\x12\x1e.discovery.v1.HeartbeatRequest\x1a\x1f.discovery.v1.HeartbeatResponse(\x01\x12C\n" +
+	"\x06Lookup\x12\x1b.discovery.v1.LookupRequest\x1a\x1c.discovery.v1.LookupResponse\x12?\n" +
+	"\x05Watch\x12\x1a.discovery.v1.WatchRequest\x1a\x18.discovery.v1.WatchEvent0\x01B3Z1github.com/spidey52/service-discovery/discoverypbb\x06proto3"
+
+var (
+	file_discoverypb_discovery_proto_rawDescOnce sync.Once
+	file_discoverypb_discovery_proto_rawDescData []byte
+)
+
+func file_discoverypb_discovery_proto_rawDescGZIP() []byte {
+	file_discoverypb_discovery_proto_rawDescOnce.Do(func() {
+		file_discoverypb_discovery_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_discoverypb_discovery_proto_rawDesc), len(file_discoverypb_discovery_proto_rawDesc)))
+	})
+	return file_discoverypb_discovery_proto_rawDescData
+}
+
+var file_discoverypb_discovery_proto_msgTypes = make([]protoimpl.MessageInfo, 22)
+var file_discoverypb_discovery_proto_goTypes = []any{
+	(*Metadata)(nil),              // 0: discovery.v1.Metadata
+	(*Endpoint)(nil),              // 1: discovery.v1.Endpoint
+	(*Check)(nil),                 // 2: discovery.v1.Check
+	(*Maintenance)(nil),           // 3: discovery.v1.Maintenance
+	(*Instance)(nil),              // 4: discovery.v1.Instance
+	(*RegisterRequest)(nil),       // 5: discovery.v1.RegisterRequest
+	(*RegisterResponse)(nil),      // 6: discovery.v1.RegisterResponse
+	(*DeregisterRequest)(nil),     // 7: discovery.v1.DeregisterRequest
+	(*DeregisterResponse)(nil),    // 8: discovery.v1.DeregisterResponse
+	(*HeartbeatRequest)(nil),      // 9: discovery.v1.HeartbeatRequest
+	(*HeartbeatResponse)(nil),     // 10: discovery.v1.HeartbeatResponse
+	(*Filter)(nil),                // 11: discovery.v1.Filter
+	(*LookupRequest)(nil),         // 12: discovery.v1.LookupRequest
+	(*LookupResponse)(nil),        // 13: discovery.v1.LookupResponse
+	(*WatchRequest)(nil),          // 14: discovery.v1.WatchRequest
+	(*WatchEvent)(nil),            // 15: discovery.v1.WatchEvent
+	nil,                           // 16: discovery.v1.Instance.LabelsEntry
+	nil,                           // 17: discovery.v1.Instance.LoadEntry
+	nil,                           // 18: discovery.v1.HeartbeatRequest.LoadEntry
+	nil,                           // 19: discovery.v1.Filter.MaxLoadEntry
+	nil,                           // 20: discovery.v1.Filter.LabelsEntry
+	nil,                           // 21: discovery.v1.Filter.MetadataEntry
+	(*timestamppb.Timestamp)(nil), // 22: google.protobuf.Timestamp
+}
+var file_discoverypb_discovery_proto_depIdxs = []int32{
+	22, // 0: discovery.v1.Maintenance.since:type_name -> google.protobuf.Timestamp
+	22, // 1: discovery.v1.Maintenance.until:type_name -> google.protobuf.Timestamp
+	0,  // 2: discovery.v1.Instance.metadata:type_name -> discovery.v1.Metadata
+	16, // 3: discovery.v1.Instance.labels:type_name -> discovery.v1.Instance.LabelsEntry
+	1,  // 4: discovery.v1.Instance.endpoints:type_name -> discovery.v1.Endpoint
+	2,  // 5: discovery.v1.Instance.checks:type_name -> discovery.v1.Check
+	3,  // 6: discovery.v1.Instance.maintenance:type_name -> discovery.v1.Maintenance
+	17, // 7: discovery.v1.Instance.load:type_name -> discovery.v1.Instance.LoadEntry
+	22, // 8: discovery.v1.Instance.last_heartbeat:type_name -> google.protobuf.Timestamp
+	22, // 9: discovery.v1.Instance.expires_at:type_name -> google.protobuf.Timestamp
+	4,  // 10: discovery.v1.RegisterRequest.instance:type_name -> discovery.v1.Instance
+	4,  // 11: discovery.v1.RegisterResponse.instance:type_name -> discovery.v1.Instance
+	4,  // 12: discovery.v1.DeregisterResponse.instance:type_name -> discovery.v1.Instance
+	18, // 13: discovery.v1.HeartbeatRequest.load:type_name -> discovery.v1.HeartbeatRequest.LoadEntry
+	19, // 14: discovery.v1.Filter.max_load:type_name -> discovery.v1.Filter.MaxLoadEntry
+	20, // 15: discovery.v1.Filter.labels:type_name -> discovery.v1.Filter.LabelsEntry
+	21, // 16: discovery.v1.Filter.metadata:type_name -> discovery.v1.Filter.MetadataEntry
+	11, // 17: discovery.v1.LookupRequest.filter:type_name -> discovery.v1.Filter
+	4,  // 18: discovery.v1.LookupResponse.instances:type_name -> discovery.v1.Instance
+	11, // 19: discovery.v1.WatchRequest.filter:type_name -> discovery.v1.Filter
+	4,  // 20: discovery.v1.WatchEvent.instance:type_name -> discovery.v1.Instance
+	4,  // 21: discovery.v1.WatchEvent.instances:type_name -> discovery.v1.Instance
+	5,  // 22: discovery.v1.Discovery.Register:input_type -> discovery.v1.RegisterRequest
+	7,  // 23: discovery.v1.Discovery.Deregister:input_type -> discovery.v1.DeregisterRequest
+	9,  // 24: discovery.v1.Discovery.Heartbeat:input_type -> discovery.v1.HeartbeatRequest
+	12, // 25: discovery.v1.Discovery.Lookup:input_type -> discovery.v1.LookupRequest
+	14, // 26: discovery.v1.Discovery.Watch:input_type -> discovery.v1.WatchRequest
+	6,  // 27: discovery.v1.Discovery.Register:output_type -> discovery.v1.RegisterResponse
+	8,  // 28: discovery.v1.Discovery.Deregister:output_type -> discovery.v1.DeregisterResponse
+	10, // 29: discovery.v1.Discovery.Heartbeat:output_type -> discovery.v1.HeartbeatResponse
+	13, // 30: discovery.v1.Discovery.Lookup:output_type -> discovery.v1.LookupResponse
+	15, // 31: discovery.v1.Discovery.Watch:output_type -> discovery.v1.WatchEvent
+	27, // [27:32] is the sub-list for method output_type
+	22, // [22:27] is the sub-list for method input_type
+	22, // [22:22] is the sub-list for extension type_name
+	22, // [22:22] is the sub-list for extension extendee
+	0,  // [0:22] is the sub-list for field type_name
+}
+
+func init() { file_discoverypb_discovery_proto_init() }
+func file_discoverypb_discovery_proto_init() {
+	if File_discoverypb_discovery_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_discoverypb_discovery_proto_rawDesc), len(file_discoverypb_discovery_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   22,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_discoverypb_discovery_proto_goTypes,
+		DependencyIndexes: file_discoverypb_discovery_proto_depIdxs,
+		MessageInfos:      file_discoverypb_discovery_proto_msgTypes,
+	}.Build()
+	File_discoverypb_discovery_proto = out.File
+	file_discoverypb_discovery_proto_goTypes = nil
+	file_discoverypb_discovery_proto_depIdxs = nil
+}
@@ -0,0 +1,5 @@
+// Package discoverypb holds the gRPC API of the registry, generated from
+// discovery.proto
+package discoverypb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative discoverypb/discovery.proto
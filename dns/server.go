@@ -0,0 +1,463 @@
+// Package dns serves registered instances over DNS for clients that cannot
+// use the HTTP API. Names have the form
+//
+//	[_<endpoint>._tcp.][<value>.<key>.]...<service>.<mode>.service.<domain>
+//
+// where each <value>.<key> pair selects a field by its name in filter
+// expressions, usually a metadata field, e.g.
+// us-east.region.orders.prod.service.discovery. A and AAAA queries return
+// the addresses of the healthy instances, SRV queries their ports, or those
+// of the named endpoint. SRV targets of instances registered by IP address
+// are synthesized names of the form <hex address>.addr.<domain>.
+//
+// Like the rest of a name, <service> is matched ignoring case, since
+// resolvers may randomize the case of the names they query.
+package dns
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// maxUDPSize is the response size over UDP for clients that do not
+	// announce a larger one with EDNS(0)
+	maxUDPSize = 512
+	// maxEDNSSize caps the UDP size a client can announce
+	maxEDNSSize = 4096
+	// maxTCPSize is the largest message length TCP framing can carry
+	maxTCPSize = 65535
+	// tcpIdleTimeout closes TCP connections without queries
+	tcpIdleTimeout = 10 * time.Second
+	// lookupTimeout bounds the registry lookup answering a query, well
+	// below the time clients wait before retrying
+	lookupTimeout = 2 * time.Second
+	// maxUDPQueries bounds the UDP queries answered at once; further
+	// packets wait in the socket buffer, and are dropped when it is full
+	maxUDPQueries = 256
+)
+
+// Server answers DNS queries from the registry
+type Server struct {
+	repo     repository.Registry
+	domain   string // lower case, without dots around it
+	ttl      uint32 // of every record, in seconds
+	leaseTTL time.Duration
+}
+
+// New creates a server answering for names under domain with records that
+// live for ttl. leaseTTL is the lease of instances registered without one.
+func New(repo repository.Registry, domain string, ttl, leaseTTL time.Duration) *Server {
+	return &Server{
+		repo:     repo,
+		domain:   strings.ToLower(strings.Trim(domain, ".")),
+		ttl:      uint32(ttl / time.Second),
+		leaseTTL: leaseTTL,
+	}
+}
+
+// ListenAndServe answers queries over UDP and TCP on addr until ctx is
+// cancelled
+func (s *Server) ListenAndServe(ctx context.Context, addr string) error {
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+		ln.Close()
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	var udpErr, tcpErr error
+	go func() {
+		defer wg.Done()
+		udpErr = s.serveUDP(ctx, conn)
+	}()
+	go func() {
+		defer wg.Done()
+		tcpErr = s.serveTCP(ctx, ln)
+	}()
+	wg.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return errors.Join(udpErr, tcpErr)
+}
+
+func (s *Server) serveUDP(ctx context.Context, conn net.PacketConn) error {
+	sem := make(chan struct{}, maxUDPQueries)
+	for {
+		buf := make([]byte, maxEDNSSize)
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		sem <- struct{}{}
+		go func() {
+			defer func() { <-sem }()
+			if resp := s.Answer(ctx, buf[:n], false); resp != nil {
+				if _, err := conn.WriteTo(resp, addr); err != nil {
+					log.Printf("dns: reply to %s failed: %v", addr, err)
+				}
+			}
+		}()
+	}
+}
+
+func (s *Server) serveTCP(ctx context.Context, ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+				var length uint16
+				if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+					return
+				}
+				query := make([]byte, length)
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := s.Answer(ctx, query, true)
+				if resp == nil {
+					return
+				}
+				if _, err := conn.Write(binary.BigEndian.AppendUint16(nil, uint16(len(resp)))); err != nil {
+					return
+				}
+				if _, err := conn.Write(resp); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Answer returns the packed response to a packed query, or nil for
+// messages that do not deserve one. Responses over UDP (tcp false) are
+// truncated to the size the client accepts.
+func (s *Server) Answer(ctx context.Context, query []byte, tcp bool) []byte {
+	var req dnsmessage.Message
+	if err := req.Unpack(query); err != nil || req.Header.Response {
+		return nil
+	}
+
+	resp := dnsmessage.Message{Header: dnsmessage.Header{
+		ID:               req.Header.ID,
+		Response:         true,
+		OpCode:           req.Header.OpCode,
+		RecursionDesired: req.Header.RecursionDesired,
+	}}
+	maxSize := maxUDPSize
+	if tcp {
+		maxSize = maxTCPSize
+	}
+	for _, r := range req.Additionals {
+		if r.Header.Type == dnsmessage.TypeOPT {
+			if !tcp {
+				maxSize = min(max(int(r.Header.Class), maxUDPSize), maxEDNSSize)
+			}
+			var opt dnsmessage.Resource
+			opt.Header.SetEDNS0(maxEDNSSize, dnsmessage.RCodeSuccess, false)
+			opt.Body = &dnsmessage.OPTResource{}
+			resp.Additionals = append(resp.Additionals, opt)
+		}
+	}
+
+	switch {
+	case req.Header.OpCode != 0:
+		resp.Header.RCode = dnsmessage.RCodeNotImplemented
+	case len(req.Questions) != 1:
+		resp.Header.RCode = dnsmessage.RCodeFormatError
+	default:
+		resp.Questions = req.Questions
+		s.resolve(ctx, req.Questions[0], &resp)
+	}
+
+	packed, err := resp.Pack()
+	for err == nil && len(packed) > maxSize {
+		// Addresses of SRV targets are optional, answers are not
+		resp.Header.Truncated = true
+		if n := len(resp.Additionals); n > 0 && resp.Additionals[n-1].Header.Type != dnsmessage.TypeOPT {
+			resp.Additionals = resp.Additionals[:n-1]
+		} else if len(resp.Answers) > 0 {
+			resp.Answers = resp.Answers[:len(resp.Answers)-1]
+		} else {
+			break
+		}
+		packed, err = resp.Pack()
+	}
+	if err != nil {
+		log.Printf("dns: packing an answer failed: %v", err)
+		return nil
+	}
+	return packed
+}
+
+// resolve fills resp with the answer to q
+func (s *Server) resolve(ctx context.Context, q dnsmessage.Question, resp *dnsmessage.Message) {
+	name := strings.TrimSuffix(q.Name.String(), ".")
+	rest, ok := s.relative(name)
+	if !ok {
+		resp.Header.RCode = dnsmessage.RCodeRefused
+		return
+	}
+	resp.Header.Authoritative = true
+
+	var labels []string
+	if rest != "" {
+		labels = strings.Split(rest, ".")
+	}
+	switch {
+	case len(labels) == 0:
+		if q.Type == dnsmessage.TypeSOA {
+			resp.Answers = append(resp.Answers, s.soa())
+			return
+		}
+	case len(labels) == 2 && strings.EqualFold(labels[1], "addr"):
+		ip, ok := decodeAddr(labels[0])
+		if !ok {
+			s.negative(resp, dnsmessage.RCodeNameError)
+			return
+		}
+		if rr, ok := s.address(q.Name, ip, q.Type); ok {
+			resp.Answers = append(resp.Answers, rr)
+			return
+		}
+	default:
+		filter, endpoint, ok := parseName(labels)
+		if !ok {
+			s.negative(resp, dnsmessage.RCodeNameError)
+			return
+		}
+		ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+		instances, err := s.repo.Find(ctx, filter, s.leaseTTL)
+		cancel()
+		if err != nil {
+			log.Printf("dns: lookup of %s failed: %v", name, err)
+			resp.Header.RCode = dnsmessage.RCodeServerFailure
+			return
+		}
+		if len(instances) == 0 {
+			s.negative(resp, dnsmessage.RCodeNameError)
+			return
+		}
+		rand.Shuffle(len(instances), func(i, j int) {
+			instances[i], instances[j] = instances[j], instances[i]
+		})
+		s.records(q, instances, endpoint, resp)
+	}
+	if len(resp.Answers) == 0 {
+		s.negative(resp, dnsmessage.RCodeSuccess)
+	}
+}
+
+// relative returns name relative to the server's domain and whether name
+// is in the domain at all
+func (s *Server) relative(name string) (string, bool) {
+	lower := strings.ToLower(name)
+	if lower == s.domain {
+		return "", true
+	}
+	if !strings.HasSuffix(lower, "."+s.domain) {
+		return "", false
+	}
+	return name[:len(name)-len(s.domain)-1], true
+}
+
+// parseName builds the filter a name relative to the domain stands for,
+// and returns the endpoint it names, empty for the instances' primary port
+func parseName(labels []string) (repository.Filter, string, bool) {
+	var filter repository.Filter
+	n := len(labels)
+	if n < 3 || !strings.EqualFold(labels[n-1], "service") {
+		return filter, "", false
+	}
+	filter.ServiceName = labels[n-3]
+	filter.FoldServiceName = true
+	filter.Mode = strings.ToLower(labels[n-2])
+
+	var endpoint string
+	prefix := labels[:n-3]
+	if len(prefix) >= 2 && strings.HasPrefix(prefix[0], "_") {
+		if !strings.EqualFold(prefix[1], "_tcp") {
+			return filter, "", false
+		}
+		endpoint = strings.ToLower(prefix[0][1:])
+		prefix = prefix[2:]
+		filter.Conditions = append(filter.Conditions, repository.Condition{
+			Field: "endpoints.name", Op: repository.OpEq, Values: []any{endpoint}, Fold: true,
+		})
+	}
+	if len(prefix)%2 != 0 {
+		return filter, "", false
+	}
+	for i := 0; i < len(prefix); i += 2 {
+		value, key := strings.ToLower(prefix[i]), strings.ToLower(prefix[i+1])
+		field, err := repository.FieldPath(key)
+		if err != nil {
+			return filter, "", false
+		}
+		filter.Conditions = append(filter.Conditions, repository.Condition{
+			Field:  field,
+			Op:     repository.OpEq,
+			Values: []any{repository.Literal(value)},
+			Fold:   true,
+		})
+	}
+	return filter, endpoint, true
+}
+
+// records adds the records of type q.Type for instances to resp
+func (s *Server) records(q dnsmessage.Question, instances []models.Instance, endpoint string, resp *dnsmessage.Message) {
+	switch q.Type {
+	case dnsmessage.TypeA, dnsmessage.TypeAAAA:
+		seen := map[string]bool{}
+		for _, inst := range instances {
+			ip := net.ParseIP(inst.Host)
+			if ip == nil || seen[ip.String()] {
+				continue
+			}
+			seen[ip.String()] = true
+			if rr, ok := s.address(q.Name, ip, q.Type); ok {
+				resp.Answers = append(resp.Answers, rr)
+			}
+		}
+	case dnsmessage.TypeSRV:
+		targets := map[string]bool{}
+		for _, inst := range instances {
+			port, ok := endpointPort(inst, endpoint)
+			if !ok {
+				continue
+			}
+			target, ip := s.target(inst.Host)
+			name, err := dnsmessage.NewName(target)
+			if err != nil {
+				continue
+			}
+			resp.Answers = append(resp.Answers, dnsmessage.Resource{
+				Header: s.header(q.Name, dnsmessage.TypeSRV),
+				Body: &dnsmessage.SRVResource{
+					Priority: 1,
+					Weight:   uint16(max(inst.Weight, 1)),
+					Port:     uint16(port),
+					Target:   name,
+				},
+			})
+			if ip != nil && !targets[target] {
+				targets[target] = true
+				typ := dnsmessage.TypeAAAA
+				if ip.To4() != nil {
+					typ = dnsmessage.TypeA
+				}
+				if rr, ok := s.address(name, ip, typ); ok {
+					resp.Additionals = append(resp.Additionals, rr)
+				}
+			}
+		}
+	}
+}
+
+// endpointPort returns the port of the named endpoint of inst, or its
+// primary port for the empty name
+func endpointPort(inst models.Instance, endpoint string) (int, bool) {
+	if endpoint == "" {
+		return inst.Port, true
+	}
+	for _, e := range inst.Endpoints {
+		if strings.EqualFold(e.Name, endpoint) {
+			return e.Port, true
+		}
+	}
+	return 0, false
+}
+
+// target returns the SRV target of host: host itself when it is a name,
+// otherwise a synthesized addr name and the address it stands for
+func (s *Server) target(host string) (string, net.IP) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return strings.TrimSuffix(host, ".") + ".", nil
+	}
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	return hex.EncodeToString(ip) + ".addr." + s.domain + ".", ip
+}
+
+// decodeAddr reverses the address encoding of target
+func decodeAddr(label string) (net.IP, bool) {
+	b, err := hex.DecodeString(label)
+	if err != nil || (len(b) != net.IPv4len && len(b) != net.IPv6len) {
+		return nil, false
+	}
+	return net.IP(b), true
+}
+
+// address returns the A or AAAA record of ip, if it has the type asked for
+func (s *Server) address(name dnsmessage.Name, ip net.IP, typ dnsmessage.Type) (dnsmessage.Resource, bool) {
+	v4 := ip.To4()
+	switch {
+	case typ == dnsmessage.TypeA && v4 != nil:
+		return dnsmessage.Resource{
+			Header: s.header(name, dnsmessage.TypeA),
+			Body:   &dnsmessage.AResource{A: [4]byte(v4)},
+		}, true
+	case typ == dnsmessage.TypeAAAA && v4 == nil:
+		return dnsmessage.Resource{
+			Header: s.header(name, dnsmessage.TypeAAAA),
+			Body:   &dnsmessage.AAAAResource{AAAA: [16]byte(ip.To16())},
+		}, true
+	}
+	return dnsmessage.Resource{}, false
+}
+
+func (s *Server) header(name dnsmessage.Name, typ dnsmessage.Type) dnsmessage.ResourceHeader {
+	return dnsmessage.ResourceHeader{Name: name, Type: typ, Class: dnsmessage.ClassINET, TTL: s.ttl}
+}
+
+// negative sets rcode and the SOA clients cache the negative answer by
+func (s *Server) negative(resp *dnsmessage.Message, rcode dnsmessage.RCode) {
+	resp.Header.RCode = rcode
+	resp.Authorities = append(resp.Authorities, s.soa())
+}
+
+func (s *Server) soa() dnsmessage.Resource {
+	return dnsmessage.Resource{
+		Header: s.header(dnsmessage.MustNewName(s.domain+"."), dnsmessage.TypeSOA),
+		Body: &dnsmessage.SOAResource{
+			NS:      dnsmessage.MustNewName("ns." + s.domain + "."),
+			MBox:    dnsmessage.MustNewName("hostmaster." + s.domain + "."),
+			Serial:  uint32(time.Now().Unix()),
+			Refresh: 3600,
+			Retry:   600,
+			Expire:  86400,
+			MinTTL:  s.ttl,
+		},
+	}
+}
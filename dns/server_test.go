@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func testServer(t *testing.T) *Server {
+	t.Helper()
+	ctx := context.Background()
+	repo := repository.NewMemoryRepo()
+	register := func(id, host, region string, endpoints ...models.Endpoint) {
+		inst := models.Instance{
+			ServiceName: "orders",
+			ID:          id,
+			Host:        host,
+			Port:        8080,
+			Mode:        "prod",
+			Metadata:    models.Metadata{Environment: "prod", Region: region, Version: 1},
+			Endpoints:   endpoints,
+		}
+		inst.Renew(time.Now())
+		if _, _, err := repo.Register(ctx, inst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	register("orders-1", "10.0.0.1", "us-east", models.Endpoint{Name: "grpc", Port: 9090, Protocol: models.ProtocolGRPC})
+	register("orders-2", "10.0.0.2", "eu-west")
+	register("orders-3", "fd00::3", "eu-west")
+	return New(repo, "Discovery.", 5*time.Second, time.Minute)
+}
+
+func query(t *testing.T, s *Server, name string, typ dnsmessage.Type) dnsmessage.Message {
+	t.Helper()
+	req := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 42, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}},
+	}
+	packed, err := req.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp dnsmessage.Message
+	if err := resp.Unpack(s.Answer(context.Background(), packed, false)); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.ID != 42 || !resp.Header.Response {
+		t.Fatalf("response header = %+v", resp.Header)
+	}
+	return resp
+}
+
+// answers returns the answers of resp as strings, sorted
+func answers(resp dnsmessage.Message) []string {
+	var out []string
+	for _, rr := range resp.Answers {
+		switch body := rr.Body.(type) {
+		case *dnsmessage.AResource:
+			out = append(out, net.IP(body.A[:]).String())
+		case *dnsmessage.AAAAResource:
+			out = append(out, net.IP(body.AAAA[:]).String())
+		case *dnsmessage.SRVResource:
+			out = append(out, fmt.Sprintf("%s:%d", body.Target, body.Port))
+		}
+	}
+	slices.Sort(out)
+	return out
+}
+
+func TestAnswer(t *testing.T) {
+	s := testServer(t)
+
+	tests := []struct {
+		name  string
+		qname string
+		typ   dnsmessage.Type
+		rcode dnsmessage.RCode
+		want  []string
+	}{
+		{"A", "orders.prod.service.discovery.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"10.0.0.1", "10.0.0.2"}},
+		{"mixed case", "OrDeRs.PrOd.SeRvIcE.discovery.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"10.0.0.1", "10.0.0.2"}},
+		{"mixed case endpoint", "_GRPC._tcp.orders.prod.service.discovery.", dnsmessage.TypeSRV, dnsmessage.RCodeSuccess, []string{"0a000001.addr.discovery.:9090"}},
+		{"mixed case value", "EU-West.region.orders.prod.service.discovery.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"10.0.0.2"}},
+		{"mixed case key", "eu-west.REGION.orders.prod.service.discovery.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"10.0.0.2"}},
+		{"AAAA", "orders.prod.service.discovery.", dnsmessage.TypeAAAA, dnsmessage.RCodeSuccess, []string{"fd00::3"}},
+		{"metadata", "eu-west.region.orders.prod.service.discovery.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"10.0.0.2"}},
+		{"SRV", "us-east.region.orders.prod.service.discovery.", dnsmessage.TypeSRV, dnsmessage.RCodeSuccess, []string{"0a000001.addr.discovery.:8080"}},
+		{"SRV endpoint", "_grpc._tcp.orders.prod.service.DISCOVERY.", dnsmessage.TypeSRV, dnsmessage.RCodeSuccess, []string{"0a000001.addr.discovery.:9090"}},
+		{"addr", "0a000001.addr.discovery.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, []string{"10.0.0.1"}},
+		{"no data", "orders.prod.service.discovery.", dnsmessage.TypeMX, dnsmessage.RCodeSuccess, nil},
+		{"unknown service", "users.prod.service.discovery.", dnsmessage.TypeA, dnsmessage.RCodeNameError, nil},
+		{"unknown mode", "orders.dev.service.discovery.", dnsmessage.TypeA, dnsmessage.RCodeNameError, nil},
+		{"malformed", "region.orders.prod.service.discovery.", dnsmessage.TypeA, dnsmessage.RCodeNameError, nil},
+		{"other domain", "orders.prod.service.example.com.", dnsmessage.TypeA, dnsmessage.RCodeRefused, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := query(t, s, tt.qname, tt.typ)
+			if resp.Header.RCode != tt.rcode {
+				t.Fatalf("rcode = %v, want %v", resp.Header.RCode, tt.rcode)
+			}
+			if got := answers(resp); !slices.Equal(got, tt.want) {
+				t.Errorf("answers = %v, want %v", got, tt.want)
+			}
+			if len(tt.want) == 0 && tt.rcode != dnsmessage.RCodeRefused && len(resp.Authorities) != 1 {
+				t.Errorf("negative answer carries %d authorities, want the SOA", len(resp.Authorities))
+			}
+		})
+	}
+}
+
+func TestAnswerSRVAdditionals(t *testing.T) {
+	resp := query(t, testServer(t), "orders.prod.service.discovery.", dnsmessage.TypeSRV)
+	if len(resp.Answers) != 3 || len(resp.Additionals) != 3 {
+		t.Fatalf("got %d answers and %d additionals, want 3 and 3", len(resp.Answers), len(resp.Additionals))
+	}
+	for _, rr := range resp.Answers {
+		if rr.Header.TTL != 5 {
+			t.Errorf("TTL = %d, want 5", rr.Header.TTL)
+		}
+	}
+}
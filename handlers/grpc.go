@@ -0,0 +1,400 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/spidey52/service-discovery/discoverypb"
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer implements the gRPC API on the registry and hub that
+// SetupRoutes configures, so both APIs see and emit the same events
+type grpcServer struct {
+	discoverypb.UnimplementedDiscoveryServer
+	repo  repository.Registry
+	lease Lease
+}
+
+// RegisterGRPC registers the gRPC API on s. Registrations are granted a
+// lease within the bounds of lease.
+func RegisterGRPC(s *grpc.Server, repo repository.Registry, lease Lease) {
+	discoverypb.RegisterDiscoveryServer(s, &grpcServer{repo: repo, lease: lease})
+}
+
+func (s *grpcServer) Register(ctx context.Context, req *discoverypb.RegisterRequest) (*discoverypb.RegisterResponse, error) {
+	inst := instanceFromProto(req.GetInstance())
+	if err := binding.Validator.ValidateStruct(&inst); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	inst.TTL = s.lease.seconds(inst.TTL)
+	stored, previous, err := s.repo.Register(ctx, inst)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	action := ActionUpdate
+	if previous == nil {
+		action = ActionRegister
+	}
+	Publish(ServiceUpdate{
+		Action:   action,
+		Service:  stored,
+		Previous: previous,
+	})
+
+	return &discoverypb.RegisterResponse{Instance: instanceToProto(stored), Created: previous == nil}, nil
+}
+
+func (s *grpcServer) Deregister(ctx context.Context, req *discoverypb.DeregisterRequest) (*discoverypb.DeregisterResponse, error) {
+	if req.GetServiceName() == "" || req.GetId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "service_name and id are required")
+	}
+	inst, err := s.repo.Deregister(ctx, req.GetServiceName(), req.GetId())
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	Publish(ServiceUpdate{
+		Action:  ActionDeregister,
+		Service: inst,
+	})
+
+	return &discoverypb.DeregisterResponse{Instance: instanceToProto(inst)}, nil
+}
+
+func (s *grpcServer) Heartbeat(stream grpc.ClientStreamingServer[discoverypb.HeartbeatRequest, discoverypb.HeartbeatResponse]) error {
+	var count uint64
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&discoverypb.HeartbeatResponse{Heartbeats: count})
+		}
+		if err != nil {
+			return err
+		}
+
+		hb := models.Heartbeat{
+			ServiceName: req.GetServiceName(),
+			ID:          req.GetId(),
+			Status:      req.GetStatus(),
+			Output:      req.GetOutput(),
+			Load:        req.GetLoad(),
+		}
+		if err := binding.Validator.ValidateStruct(&hb); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		inst, previous, err := s.repo.UpdateHeartbeat(stream.Context(), hb)
+		if err != nil {
+			return grpcError(err)
+		}
+		count++
+
+		PublishReport(inst, previous, true)
+	}
+}
+
+func (s *grpcServer) Lookup(ctx context.Context, req *discoverypb.LookupRequest) (*discoverypb.LookupResponse, error) {
+	query, err := filterValues(req.GetFilter())
+	if err != nil {
+		return nil, err
+	}
+	if req.GetLimit() > 0 {
+		query.Set("limit", strconv.Itoa(int(req.GetLimit())))
+	}
+	if req.GetCursor() != "" {
+		query.Set("cursor", req.GetCursor())
+	}
+	if req.GetSort() != "" {
+		query.Set("sort", req.GetSort())
+	}
+	if req.GetPreferRegion() != "" {
+		query.Set("preferRegion", req.GetPreferRegion())
+	}
+	if req.GetPreferZone() != "" {
+		query.Set("preferZone", req.GetPreferZone())
+	}
+	if req.GetMinHealthy() > 0 {
+		query.Set("minHealthy", strconv.Itoa(int(req.GetMinHealthy())))
+	}
+	if req.GetMinHealthyPercent() > 0 {
+		query.Set("minHealthyPercent", strconv.Itoa(int(req.GetMinHealthyPercent())))
+	}
+
+	filter, err := parseFilter(query, slices.Concat(pageParams, localityParams)...)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if filter.Page, err = parsePage(query); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	loc, err := parseLocality(query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	current := hub.Revision()
+	instances, tier, next, err := lookup(ctx, s.repo, filter, loc, s.lease.Default)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp := &discoverypb.LookupResponse{
+		Instances:  make([]*discoverypb.Instance, len(instances)),
+		NextCursor: next,
+		Index:      current,
+		Locality:   string(tier),
+	}
+	for i, inst := range instances {
+		resp.Instances[i] = instanceToProto(inst)
+	}
+	return resp, nil
+}
+
+func (s *grpcServer) Watch(req *discoverypb.WatchRequest, stream grpc.ServerStreamingServer[discoverypb.WatchEvent]) error {
+	query, err := filterValues(req.GetFilter())
+	if err != nil {
+		return err
+	}
+	filter, err := parseFilter(query)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	sub := hub.subscribe(filter, req.GetSince())
+	defer hub.unsubscribe(sub)
+
+	for {
+		select {
+		case item := <-sub.send:
+			event, err := watchEvent(item)
+			if err != nil {
+				log.Printf("gRPC snapshot failed: %v", err)
+				return status.Error(codes.Unavailable, err.Error())
+			}
+			if err := stream.Send(event); err != nil {
+				return err
+			}
+		case <-sub.done:
+			if sub.evicted {
+				log.Printf("gRPC watcher too slow, disconnecting")
+				return status.Error(codes.ResourceExhausted, "slow consumer")
+			}
+			return nil
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// watchEvent converts a queued event, taking the snapshot it asks for
+func watchEvent(item queued) (*discoverypb.WatchEvent, error) {
+	if !item.snapshot {
+		return &discoverypb.WatchEvent{
+			Action:         string(item.update.Action),
+			Revision:       item.update.Revision,
+			Instance:       instanceToProto(item.update.Service),
+			PreviousHealth: item.update.PreviousHealth,
+			Left:           item.update.Left,
+		}, nil
+	}
+	snapshot, err := takeSnapshot(item)
+	if err != nil {
+		return nil, err
+	}
+	event := &discoverypb.WatchEvent{
+		Action:    string(snapshot.Action),
+		Revision:  snapshot.Revision,
+		Instances: make([]*discoverypb.Instance, len(snapshot.Services)),
+	}
+	for i, inst := range snapshot.Services {
+		event.Instances[i] = instanceToProto(inst)
+	}
+	return event, nil
+}
+
+// grpcError converts a registry error to a gRPC status, like errorStatus
+// does for HTTP
+func grpcError(err error) error {
+	code := codes.Internal
+	switch {
+	case errors.Is(err, repository.ErrNotFound), errors.Is(err, repository.ErrServiceNotFound):
+		code = codes.NotFound
+	case errors.Is(err, repository.ErrExpired):
+		code = codes.FailedPrecondition
+	case errors.Is(err, repository.ErrInvalidCursor):
+		code = codes.InvalidArgument
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	}
+	return status.Error(code, err.Error())
+}
+
+// filterValues renders a filter as the query parameters of /lookup, so
+// both APIs share parseFilter and select the same instances. Metadata keys
+// naming another parameter are rejected rather than read as that parameter.
+func filterValues(f *discoverypb.Filter) (url.Values, error) {
+	query := url.Values{}
+	for key, value := range f.GetMetadata() {
+		if reservedParam(key) {
+			return nil, status.Errorf(codes.InvalidArgument, "metadata key %q is reserved", key)
+		}
+		query.Set(key, value)
+	}
+	if f.GetService() != "" {
+		query.Set("service", f.GetService())
+	}
+	if f.GetMode() != "" {
+		query.Set("mode", f.GetMode())
+	}
+	if len(f.GetHealth()) > 0 {
+		query.Set("health", strings.Join(f.GetHealth(), ","))
+	}
+	for name, limit := range f.GetMaxLoad() {
+		query.Set("maxLoad."+name, strconv.FormatFloat(limit, 'f', -1, 64))
+	}
+	if f.GetIncludeUnhealthy() {
+		query.Set("includeUnhealthy", "true")
+	}
+	if f.GetIncludeMaintenance() {
+		query.Set("includeMaintenance", "true")
+	}
+	for key, value := range f.GetLabels() {
+		query.Set("label."+key, value)
+	}
+	if len(f.GetTags()) > 0 {
+		query.Set("tag", strings.Join(f.GetTags(), ","))
+	}
+	if f.GetEndpoint() != "" {
+		query.Set("endpoint", f.GetEndpoint())
+	}
+	if f.GetExpression() != "" {
+		query.Set("filter", f.GetExpression())
+	}
+	return query, nil
+}
+
+func instanceFromProto(p *discoverypb.Instance) models.Instance {
+	inst := models.Instance{
+		ServiceName: p.GetServiceName(),
+		ID:          p.GetId(),
+		Host:        p.GetHost(),
+		Port:        int(p.GetPort()),
+		Mode:        p.GetMode(),
+		Labels:      p.GetLabels(),
+		Tags:        p.GetTags(),
+		Weight:      int(p.GetWeight()),
+		TTL:         int(p.GetTtl()),
+	}
+	if md := p.GetMetadata(); md != nil {
+		inst.Metadata = models.Metadata{
+			Environment:  md.GetEnvironment(),
+			Region:       md.GetRegion(),
+			Zone:         md.GetZone(),
+			Version:      int(md.GetVersion()),
+			Developer:    md.GetDeveloper(),
+			Experimental: md.GetExperimental(),
+		}
+	}
+	for _, e := range p.GetEndpoints() {
+		inst.Endpoints = append(inst.Endpoints, models.Endpoint{
+			Name:     e.GetName(),
+			Port:     int(e.GetPort()),
+			Protocol: e.GetProtocol(),
+			Path:     e.GetPath(),
+		})
+	}
+	for _, c := range p.GetChecks() {
+		inst.Checks = append(inst.Checks, models.Check{
+			Type:     c.GetType(),
+			Port:     int(c.GetPort()),
+			Path:     c.GetPath(),
+			Status:   int(c.GetStatus()),
+			Service:  c.GetService(),
+			Interval: int(c.GetInterval()),
+			Timeout:  int(c.GetTimeout()),
+		})
+	}
+	return inst
+}
+
+func instanceToProto(inst models.Instance) *discoverypb.Instance {
+	p := &discoverypb.Instance{
+		ServiceName: inst.ServiceName,
+		Id:          inst.ID,
+		Host:        inst.Host,
+		Port:        int32(inst.Port),
+		Mode:        inst.Mode,
+		Metadata: &discoverypb.Metadata{
+			Environment:  inst.Metadata.Environment,
+			Region:       inst.Metadata.Region,
+			Zone:         inst.Metadata.Zone,
+			Version:      int32(inst.Metadata.Version),
+			Developer:    inst.Metadata.Developer,
+			Experimental: inst.Metadata.Experimental,
+		},
+		Labels:         inst.Labels,
+		Tags:           inst.Tags,
+		Weight:         int32(inst.Weight),
+		Ttl:            int32(inst.TTL),
+		Health:         inst.Health,
+		ReportedHealth: inst.ReportedHealth,
+		Output:         inst.Output,
+		Load:           inst.Load,
+		CheckHealth:    inst.CheckHealth,
+		CheckOutput:    inst.CheckOutput,
+		LastHeartbeat:  timestamp(inst.LastHeartbeat),
+		ExpiresAt:      timestamp(inst.ExpiresAt),
+	}
+	for _, e := range inst.Endpoints {
+		p.Endpoints = append(p.Endpoints, &discoverypb.Endpoint{
+			Name:     e.Name,
+			Port:     int32(e.Port),
+			Protocol: e.Protocol,
+			Path:     e.Path,
+			Address:  e.Address,
+		})
+	}
+	for _, c := range inst.Checks {
+		p.Checks = append(p.Checks, &discoverypb.Check{
+			Type:     c.Type,
+			Port:     int32(c.Port),
+			Path:     c.Path,
+			Status:   int32(c.Status),
+			Service:  c.Service,
+			Interval: int32(c.Interval),
+			Timeout:  int32(c.Timeout),
+		})
+	}
+	if m := inst.Maintenance; m != nil {
+		p.Maintenance = &discoverypb.Maintenance{
+			State:  m.State,
+			Reason: m.Reason,
+			Since:  timestamp(m.Since),
+			Until:  timestamp(m.Until),
+		}
+	}
+	return p
+}
+
+// timestamp converts t, leaving zero times unset
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
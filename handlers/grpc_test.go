@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spidey52/service-discovery/discoverypb"
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func testGRPCClient(t *testing.T) discoverypb.DiscoveryClient {
+	t.Helper()
+	repo := repository.NewMemoryRepo()
+	lease := Lease{Default: time.Minute, Min: time.Second, Max: time.Hour}
+	hub.SetSnapshotFunc(func(ctx context.Context, filter repository.Filter) ([]models.Instance, error) {
+		return repo.Find(ctx, filter, lease.Default)
+	})
+
+	ln := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	RegisterGRPC(s, repo, lease)
+	go s.Serve(ln)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return ln.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return discoverypb.NewDiscoveryClient(conn)
+}
+
+func testProtoInstance(id, region string) *discoverypb.Instance {
+	return &discoverypb.Instance{
+		ServiceName: "order-service",
+		Id:          id,
+		Host:        "10.0.0.1",
+		Port:        8080,
+		Mode:        "prod",
+		Metadata:    &discoverypb.Metadata{Environment: "prod", Region: region, Version: 2},
+		Endpoints:   []*discoverypb.Endpoint{{Name: "grpc", Port: 9090, Protocol: "grpc"}},
+	}
+}
+
+func TestGRPCRegisterLookup(t *testing.T) {
+	ctx := context.Background()
+	client := testGRPCClient(t)
+
+	for _, inst := range []*discoverypb.Instance{testProtoInstance("order-1", "us-east"), testProtoInstance("order-2", "eu-west")} {
+		resp, err := client.Register(ctx, &discoverypb.RegisterRequest{Instance: inst})
+		if err != nil {
+			t.Fatalf("Register() error = %v", err)
+		}
+		if !resp.GetCreated() || resp.GetInstance().GetTtl() != 60 {
+			t.Errorf("Register() = %v, want a new instance with the default lease", resp)
+		}
+	}
+	_, err := client.Register(ctx, &discoverypb.RegisterRequest{Instance: &discoverypb.Instance{ServiceName: "order-service"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Register() of an invalid instance error = %v, want InvalidArgument", err)
+	}
+
+	resp, err := client.Lookup(ctx, &discoverypb.LookupRequest{Filter: &discoverypb.Filter{
+		Service:    "order-service",
+		Expression: "version >= 2",
+		Metadata:   map[string]string{"region": "us-east"},
+	}})
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	if len(resp.GetInstances()) != 1 || resp.GetInstances()[0].GetId() != "order-1" {
+		t.Fatalf("Lookup() = %v, want order-1", resp.GetInstances())
+	}
+	if addr := resp.GetInstances()[0].GetEndpoints()[0].GetAddress(); addr != "10.0.0.1:9090" {
+		t.Errorf("endpoint address = %q, want 10.0.0.1:9090", addr)
+	}
+
+	_, err = client.Lookup(ctx, &discoverypb.LookupRequest{Filter: &discoverypb.Filter{Expression: "version >>"}})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Lookup() with an invalid expression error = %v, want InvalidArgument", err)
+	}
+	for _, key := range []string{"includeUnhealthy", "limit", "filter", "preferRegion", "label.team", "maxLoad.cpu"} {
+		_, err = client.Lookup(ctx, &discoverypb.LookupRequest{Filter: &discoverypb.Filter{
+			Service:  "order-service",
+			Metadata: map[string]string{key: "true"},
+		}})
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("Lookup() with metadata key %s error = %v, want InvalidArgument", key, err)
+		}
+	}
+}
+
+func TestGRPCHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	client := testGRPCClient(t)
+	if _, err := client.Register(ctx, &discoverypb.RegisterRequest{Instance: testProtoInstance("order-1", "us-east")}); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.Heartbeat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, st := range []string{"passing", "warning"} {
+		if err := stream.Send(&discoverypb.HeartbeatRequest{ServiceName: "order-service", Id: "order-1", Status: st}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("Heartbeat() error = %v", err)
+	}
+	if resp.GetHeartbeats() != 2 {
+		t.Errorf("heartbeats = %d, want 2", resp.GetHeartbeats())
+	}
+
+	stream, err = client.Heartbeat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&discoverypb.HeartbeatRequest{ServiceName: "order-service", Id: "unknown"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.NotFound {
+		t.Errorf("Heartbeat() of an unknown instance error = %v, want NotFound", err)
+	}
+}
+
+func TestGRPCWatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := testGRPCClient(t)
+	if _, err := client.Register(ctx, &discoverypb.RegisterRequest{Instance: testProtoInstance("order-1", "us-east")}); err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := client.Watch(ctx, &discoverypb.WatchRequest{Filter: &discoverypb.Filter{Service: "order-service", Metadata: map[string]string{"region": "us-east"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.GetAction() != string(ActionSnapshot) || len(event.GetInstances()) != 1 {
+		t.Fatalf("first event = %v, want a snapshot of order-1", event)
+	}
+
+	// Only the us-east registration is selected
+	for _, inst := range []*discoverypb.Instance{testProtoInstance("order-2", "eu-west"), testProtoInstance("order-3", "us-east")} {
+		if _, err := client.Register(ctx, &discoverypb.RegisterRequest{Instance: inst}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event, err = stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.GetAction() != string(ActionRegister) || event.GetInstance().GetId() != "order-3" || event.GetRevision() <= 0 {
+		t.Errorf("event = %v, want the registration of order-3", event)
+	}
+}
@@ -1,77 +1,348 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/spidey52/service-discovery/balancer"
 	"github.com/spidey52/service-discovery/models"
 	"github.com/spidey52/service-discovery/repository"
 )
 
-// SetupRoutes wires all endpoints
-func SetupRoutes(r *gin.Engine, repo *repository.MongoRepo, heartbeatTTL time.Duration) {
+// SetupRoutes wires all endpoints. Registrations are granted a lease within
+// the bounds of lease; lb picks the instances /resolve returns.
+func SetupRoutes(r *gin.Engine, repo repository.Registry, lease Lease, lb *balancer.Balancer) {
+	heartbeatTTL := lease.Default
+
+	hub.SetSnapshotFunc(func(ctx context.Context, filter repository.Filter) ([]models.Instance, error) {
+		return repo.Find(ctx, filter, heartbeatTTL)
+	})
+
+	// WebSocket endpoint for real-time updates
+	r.GET("/ws", HandleWebSocket)
+	// Server-Sent Events endpoint for clients that cannot use WebSocket
+	r.GET("/watch", HandleWatch)
+
+	setupServiceRoutes(r, repo, lease)
+	setupMaintenanceRoutes(r, repo)
+	setupResolveRoutes(r, repo, lease, lb)
+
 	r.POST("/register", func(c *gin.Context) {
 		var inst models.Instance
 		if err := c.ShouldBindJSON(&inst); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if err := repo.Register(c.Request.Context(), inst); err != nil {
+		inst.TTL = lease.seconds(inst.TTL)
+		stored, previous, err := repo.Register(c.Request.Context(), inst)
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, inst)
+
+		action := ActionUpdate
+		if previous == nil {
+			action = ActionRegister
+		}
+		Publish(ServiceUpdate{
+			Action:   action,
+			Service:  stored,
+			Previous: previous,
+		})
+
+		c.JSON(http.StatusOK, stored)
 	})
 
 	r.POST("/heartbeat", func(c *gin.Context) {
+		var hb models.Heartbeat
+		if err := c.ShouldBindJSON(&hb); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		inst, previous, err := repo.UpdateHeartbeat(c.Request.Context(), hb)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		PublishReport(inst, previous, true)
+
+		c.JSON(http.StatusOK, gin.H{"message": "heartbeat ok"})
+	})
+
+	r.POST("/deregister", func(c *gin.Context) {
 		var req struct {
-			ServiceName string `json:"serviceName"`
-			ID          string `json:"id"`
+			ServiceName string `json:"serviceName" binding:"required"`
+			ID          string `json:"id" binding:"required"`
 		}
 		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if err := repo.UpdateHeartbeat(c.Request.Context(), req.ServiceName, req.ID); err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		inst, err := repo.Deregister(c.Request.Context(), req.ServiceName, req.ID)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		go BroadcastMessage(ServiceUpdate{
-			Action:  ActionHeartbeat,
-			Service: models.Instance{ServiceName: req.ServiceName, ID: req.ID},
+		Publish(ServiceUpdate{
+			Action:  ActionDeregister,
+			Service: inst,
 		})
 
-		c.JSON(http.StatusOK, gin.H{"message": "heartbeat ok"})
+		c.JSON(http.StatusOK, gin.H{"message": "deregistered"})
 	})
 
 	r.GET("/lookup", func(c *gin.Context) {
-		service := c.Query("service")
-		mode := c.Query("mode")
-		metadata := map[string]any{}
-		for key, vals := range c.Request.URL.Query() {
-			if key == "service" || key == "mode" {
-				continue
-			}
-			metadata[key] = parseString(vals[0])
+		filter, err := parseFilter(c.Request.URL.Query(), slices.Concat(pageParams, localityParams, blockingParams)...)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
 		}
-		instances, err := repo.Find(c.Request.Context(), service, mode, metadata, true, heartbeatTTL)
+		loc, err := parseLocality(c.Request.URL.Query())
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if filter.Page, err = parsePage(c.Request.URL.Query()); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		index, err := parseRevision(c.Query("index"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		wait, err := parseWait(c.Query("wait"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if index > 0 {
+			waitForChange(c.Request.Context(), filter, index, wait)
+		}
+
+		// Read the index before querying, so a change racing with the
+		// query wakes the next blocking request instead of being missed
+		current := hub.Revision()
+		instances, tier, next, err := lookup(c.Request.Context(), repo, filter, loc, heartbeatTTL)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("X-Registry-Index", strconv.FormatUint(current, 10))
+		if tier != "" {
+			c.Header("X-Locality", string(tier))
+		}
+		if next != "" {
+			c.Header("X-Next-Cursor", next)
+		}
+		if len(filter.Page.Fields) > 0 {
+			projected, err := project(instances, filter.Page)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, projected)
 			return
 		}
 		c.JSON(http.StatusOK, instances)
 	})
 }
 
-// helper to parse strings to bool/int/float if possible
-func parseString(s string) any {
-	if s == "true" {
+// lookup finds the instances selected by filter, narrowed to the closest
+// tier of loc when it expresses a preference, and fills in the addresses of
+// their endpoints. It returns the tier used and the cursor of the next page,
+// empty after the last one.
+func lookup(ctx context.Context, repo repository.Registry, filter repository.Filter, loc repository.Locality, ttl time.Duration) ([]models.Instance, repository.Tier, string, error) {
+	var tier repository.Tier
+	if loc.Enabled() {
+		var err error
+		if filter, tier, err = localize(ctx, repo, filter, loc, ttl); err != nil {
+			return nil, "", "", err
+		}
+	}
+	instances, err := repo.Find(ctx, filter, ttl)
+	if err != nil {
+		return nil, "", "", err
+	}
+	for i := range instances {
+		instances[i] = instances[i].WithAddresses()
+	}
+	var next string
+	if limit := filter.Page.Limit; limit > 0 && len(instances) == limit {
+		next = repository.Cursor(instances[limit-1], filter.Page)
+	}
+	return instances, tier, next, nil
+}
+
+const (
+	// defaultWait is how long a blocking lookup waits when no wait is given
+	defaultWait = 5 * time.Minute
+	// maxWait caps the wait of a blocking lookup
+	maxWait = 10 * time.Minute
+)
+
+// blockingParams are the parameters of a blocking lookup
+var blockingParams = []string{"index", "wait"}
+
+// parseWait parses the wait of a blocking lookup, e.g. 30s or 5m
+func parseWait(s string) (time.Duration, error) {
+	if s == "" {
+		return defaultWait, nil
+	}
+	wait, err := time.ParseDuration(s)
+	if err != nil || wait < 0 {
+		return 0, fmt.Errorf("invalid wait %q", s)
+	}
+	return min(wait, maxWait), nil
+}
+
+// waitForChange blocks until the instances selected by filter change after
+// index, wait elapses or ctx is done. Heartbeats only move timestamps and
+// do not count as a change, unless their load moved the instance into or
+// out of the selection. Changes that can no longer be told apart, for
+// instance because index predates the event buffer, end the wait at once.
+func waitForChange(ctx context.Context, filter repository.Filter, index uint64, wait time.Duration) {
+	sub := hub.subscribe(filter, index)
+	defer hub.unsubscribe(sub)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case item := <-sub.send:
+			if item.snapshot || item.update.Action != ActionHeartbeat || item.update.Left {
+				return
+			}
+			if previous := item.update.Previous; previous != nil && !filter.Match(*previous) {
+				return
+			}
+		case <-sub.done:
+			return
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// filterParams are the parameters parseFilter reads besides metadata fields,
+// maxLoad.<indicator> and label.<key>
+var filterParams = []string{"service", "mode", "health", "includeUnhealthy", "includeMaintenance", "filter", "tag", "endpoint"}
+
+// reservedParam reports whether a /lookup parameter is read as something
+// other than a metadata field
+func reservedParam(key string) bool {
+	if strings.HasPrefix(key, "maxLoad.") || strings.HasPrefix(key, "label.") {
 		return true
 	}
-	if s == "false" {
-		return false
+	return slices.Contains(slices.Concat(filterParams, pageParams, localityParams, blockingParams), key)
+}
+
+// parseFilter builds an instance filter from query parameters: service and
+// mode select those fields, health a comma-separated list of health states,
+// maxLoad.<indicator> an upper bound of a load indicator,
+// includeUnhealthy=true adds CRITICAL instances and those whose lease ran
+// out, includeMaintenance=true those in maintenance, label.<key> selects a
+// label value, tag a comma-separated list of tags an instance must all
+// carry, endpoint the name of an endpoint an instance must expose, and
+// filter holds a filter expression (see repository.ParseExpr). Every other
+// parameter except the reserved ones is a metadata field compared by
+// equality; values are typed like expression values, so version=2 matches a
+// numeric version. Indicators, label keys and metadata fields must be valid
+// field names (see repository.FieldPath).
+func parseFilter(query url.Values, reserved ...string) (repository.Filter, error) {
+	filter := repository.Filter{
+		ServiceName:        query.Get("service"),
+		Mode:               query.Get("mode"),
+		IncludeUnhealthy:   query.Get("includeUnhealthy") == "true",
+		IncludeMaintenance: query.Get("includeMaintenance") == "true",
+	}
+	if health := query.Get("health"); health != "" {
+		filter.Health = strings.Split(strings.ToUpper(health), ",")
+	}
+	if tags := query.Get("tag"); tags != "" {
+		for _, tag := range strings.Split(tags, ",") {
+			filter.Conditions = append(filter.Conditions, repository.Condition{
+				Field: "tags", Op: repository.OpEq, Values: []any{tag},
+			})
+		}
+	}
+	if endpoint := query.Get("endpoint"); endpoint != "" {
+		filter.Conditions = append(filter.Conditions, repository.Condition{
+			Field: "endpoints.name", Op: repository.OpEq, Values: []any{endpoint},
+		})
+	}
+	if expr := query.Get("filter"); expr != "" {
+		conds, err := repository.ParseExpr(expr)
+		if err != nil {
+			return filter, fmt.Errorf("invalid filter: %w", err)
+		}
+		filter.Conditions = append(filter.Conditions, conds...)
+	}
+	for key, vals := range query {
+		if slices.Contains(filterParams, key) || slices.Contains(reserved, key) {
+			continue
+		}
+		if name, ok := strings.CutPrefix(key, "maxLoad."); ok {
+			if _, err := repository.FieldPath("load." + name); err != nil {
+				return filter, fmt.Errorf("invalid parameter %q", key)
+			}
+			limit, err := strconv.ParseFloat(vals[0], 64)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s %q", key, vals[0])
+			}
+			if filter.MaxLoad == nil {
+				filter.MaxLoad = map[string]float64{}
+			}
+			filter.MaxLoad[name] = limit
+			continue
+		}
+		if name, ok := strings.CutPrefix(key, "label."); ok {
+			// Label keys cannot hold dots
+			field, err := repository.FieldPath("labels." + name)
+			if err != nil || strings.Contains(name, ".") {
+				return filter, fmt.Errorf("invalid parameter %q", key)
+			}
+			// Labels are strings, compared as given
+			filter.Conditions = append(filter.Conditions, repository.Condition{
+				Field: field, Op: repository.OpEq, Values: []any{vals[0]},
+			})
+			continue
+		}
+		field, err := repository.FieldPath("metadata." + key)
+		if err != nil {
+			return filter, fmt.Errorf("invalid parameter %q", key)
+		}
+		filter.Conditions = append(filter.Conditions, repository.Condition{
+			Field:  field,
+			Op:     repository.OpEq,
+			Values: []any{repository.Literal(vals[0])},
+		})
+	}
+	return filter, nil
+}
+
+// errorStatus maps repository errors to HTTP status codes
+func errorStatus(err error) int {
+	if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrServiceNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, repository.ErrExpired) {
+		return http.StatusGone
+	}
+	if errors.Is(err, repository.ErrInvalidCursor) {
+		return http.StatusBadRequest
 	}
-	return s
+	return http.StatusInternalServerError
 }
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spidey52/service-discovery/balancer"
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+func testRouter(t *testing.T) (*gin.Engine, *repository.MemoryRepo) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	repo := repository.NewMemoryRepo()
+	SetupRoutes(r, repo, Lease{Default: time.Minute, Min: time.Second, Max: time.Hour}, balancer.New(time.Minute))
+	return r, repo
+}
+
+// serve sends a request with an optional JSON body to r
+func serve(r http.Handler, method, target string, body any) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		_ = json.NewEncoder(&buf).Encode(body)
+	}
+	req := httptest.NewRequest(method, target, &buf)
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func testInstance(serviceName, id, region string) models.Instance {
+	return models.Instance{
+		ServiceName: serviceName,
+		ID:          id,
+		Host:        "10.0.0.1",
+		Port:        8080,
+		Mode:        "prod",
+		Metadata:    models.Metadata{Environment: "prod", Region: region, Version: 1},
+	}
+}
+
+func TestResolveRejectsLookupParams(t *testing.T) {
+	r, _ := testRouter(t)
+	if w := serve(r, http.MethodPost, "/register", testInstance("resolve-service", "resolve-1", "us-east")); w.Code != http.StatusOK {
+		t.Fatalf("register answered %d: %s", w.Code, w.Body)
+	}
+
+	if w := serve(r, http.MethodGet, "/resolve?service=resolve-service", nil); w.Code != http.StatusOK {
+		t.Errorf("resolve answered %d: %s", w.Code, w.Body)
+	}
+	for _, query := range []string{"limit=2", "cursor=abc", "sort=version", "fields=host", "index=1", "wait=1s"} {
+		w := serve(r, http.MethodGet, "/resolve?service=resolve-service&"+query, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("resolve with %s answered %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestLookupRejectsInvalidFieldNames(t *testing.T) {
+	r, _ := testRouter(t)
+	for _, query := range []string{"label.=x", "label.a.b=x", "maxLoad.a..b=1", "maxLoad.=1", "$x=1", "a..b=1", "region.=x"} {
+		w := serve(r, http.MethodGet, "/lookup?"+query, nil)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("lookup with %s answered %d, want 400", query, w.Code)
+		}
+	}
+}
+
+// registryIndex returns the X-Registry-Index of a lookup response
+func registryIndex(t *testing.T, w *httptest.ResponseRecorder) uint64 {
+	t.Helper()
+	index, err := strconv.ParseUint(w.Header().Get("X-Registry-Index"), 10, 64)
+	if err != nil {
+		t.Fatalf("X-Registry-Index %q: %v", w.Header().Get("X-Registry-Index"), err)
+	}
+	return index
+}
+
+func TestBlockingLookup(t *testing.T) {
+	r, _ := testRouter(t)
+	serve(r, http.MethodPost, "/register", testInstance("block-service", "block-1", "us-east"))
+	w := serve(r, http.MethodGet, "/lookup?service=block-service", nil)
+	index := registryIndex(t, w)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve(r, http.MethodGet, "/lookup?service=block-service&index="+strconv.FormatUint(index, 10)+"&wait=10s", nil)
+	}()
+	quiet := func(what string) {
+		t.Helper()
+		select {
+		case <-done:
+			t.Fatalf("%s woke the blocking lookup", what)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	quiet("nothing")
+	serve(r, http.MethodPost, "/heartbeat", models.Heartbeat{ServiceName: "block-service", ID: "block-1"})
+	quiet("a heartbeat")
+	serve(r, http.MethodPost, "/register", testInstance("other-service", "other-1", "us-east"))
+	quiet("a change to another service")
+
+	serve(r, http.MethodPost, "/register", testInstance("block-service", "block-2", "us-east"))
+	select {
+	case w = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a matching change did not wake the blocking lookup")
+	}
+	var instances []models.Instance
+	if err := json.Unmarshal(w.Body.Bytes(), &instances); err != nil || len(instances) != 2 {
+		t.Errorf("woken lookup returned %s, want both instances", w.Body)
+	}
+	next := registryIndex(t, w)
+	if next <= index {
+		t.Errorf("X-Registry-Index = %d after a change, want more than %d", next, index)
+	}
+
+	start := time.Now()
+	w = serve(r, http.MethodGet, "/lookup?service=block-service&index="+strconv.FormatUint(next, 10)+"&wait=200ms", nil)
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > 5*time.Second {
+		t.Errorf("lookup waiting 200ms returned after %s", elapsed)
+	}
+	if w.Code != http.StatusOK || registryIndex(t, w) != next {
+		t.Errorf("timed out lookup answered %d with index %s, want 200 and %d", w.Code, w.Header().Get("X-Registry-Index"), next)
+	}
+
+	if w := serve(r, http.MethodGet, "/lookup?service=block-service&index=1&wait=forever", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("lookup with an invalid wait answered %d, want 400", w.Code)
+	}
+
+	// Instances leaving the selection wake it too
+	for _, tt := range []struct {
+		name   string
+		query  string
+		change func()
+	}{
+		{"moving to another region", "region=us-east", func() {
+			serve(r, http.MethodPut, "/services/block-service/instances/block-1", testInstance("block-service", "block-1", "eu-west"))
+		}},
+		{"registering again in another region", "region=us-east", func() {
+			serve(r, http.MethodPost, "/register", testInstance("block-service", "block-2", "eu-west"))
+		}},
+		{"turning CRITICAL", "health=UP", func() {
+			serve(r, http.MethodPost, "/heartbeat", models.Heartbeat{ServiceName: "block-service", ID: "block-2", Status: models.StatusCritical})
+		}},
+	} {
+		target := "/lookup?service=block-service&" + tt.query
+		w := serve(r, http.MethodGet, target, nil)
+		index := registryIndex(t, w)
+		before := len(lookupIDs(t, r, target))
+		go func() {
+			done <- serve(r, http.MethodGet, target+"&index="+strconv.FormatUint(index, 10)+"&wait=10s", nil)
+		}()
+		quiet("nothing")
+		tt.change()
+		select {
+		case w = <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s did not wake the blocking lookup", tt.name)
+		}
+		instances = nil
+		if err := json.Unmarshal(w.Body.Bytes(), &instances); err != nil || len(instances) != before-1 {
+			t.Errorf("lookup woken by %s returned %s, want one instance less than %d", tt.name, w.Body, before)
+		}
+	}
+}
+
+func TestBlockingLookupLoadLeavesFilter(t *testing.T) {
+	r, _ := testRouter(t)
+	serve(r, http.MethodPost, "/register", testInstance("load-service", "load-1", "us-east"))
+	beat := func(cpu float64) {
+		serve(r, http.MethodPost, "/heartbeat", models.Heartbeat{ServiceName: "load-service", ID: "load-1", Load: map[string]float64{"cpu": cpu}})
+	}
+	beat(0.2)
+	target := "/lookup?service=load-service&maxLoad.cpu=0.5"
+	index := registryIndex(t, serve(r, http.MethodGet, target, nil))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- serve(r, http.MethodGet, target+"&index="+strconv.FormatUint(index, 10)+"&wait=10s", nil)
+	}()
+	beat(0.3)
+	select {
+	case <-done:
+		t.Fatal("a heartbeat within maxLoad woke the blocking lookup")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	beat(0.9)
+	var w *httptest.ResponseRecorder
+	select {
+	case w = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("load rising above maxLoad did not wake the blocking lookup")
+	}
+	if w.Body.String() != "[]" {
+		t.Errorf("woken lookup returned %s, want none", w.Body)
+	}
+}
+
+func TestRegisterAgainLeavesFilter(t *testing.T) {
+	r, _ := testRouter(t)
+	serve(r, http.MethodPost, "/register", testInstance("again-service", "again-1", "us-east"))
+	filter, err := parseFilter(url.Values{"service": {"again-service"}, "region": {"us-east"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := hub.subscribe(filter, 0)
+	defer hub.unsubscribe(sub)
+	drain(sub)
+
+	serve(r, http.MethodPost, "/register", testInstance("again-service", "again-1", "eu-west"))
+	items := drain(sub)
+	if len(items) != 1 || items[0].update.Action != ActionUpdate || !items[0].update.Left {
+		t.Errorf("registering in another region published %+v, want an update leaving the filter", items)
+	}
+	serve(r, http.MethodPost, "/register", testInstance("again-service", "again-1", "eu-west"))
+	if items := drain(sub); len(items) != 0 {
+		t.Errorf("registering outside the filter published %+v", items)
+	}
+}
+
+func TestLookupLabelsAndTags(t *testing.T) {
+	r, _ := testRouter(t)
+	for _, spec := range []struct {
+		id     string
+		labels map[string]string
+		tags   []string
+	}{
+		{"tagged-1", map[string]string{"team": "payments", "tier": "1"}, []string{"canary", "arm64"}},
+		{"tagged-2", map[string]string{"team": "payments"}, []string{"canary"}},
+		{"tagged-3", map[string]string{"team": ""}, nil},
+		{"tagged-4", nil, []string{"arm64"}},
+	} {
+		inst := testInstance("tagged-service", spec.id, "us-east")
+		inst.Labels, inst.Tags = spec.labels, spec.tags
+		if w := serve(r, http.MethodPost, "/register", inst); w.Code != http.StatusOK {
+			t.Fatalf("register %s answered %d: %s", spec.id, w.Code, w.Body)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"label.team=payments", []string{"tagged-1", "tagged-2"}},
+		{"label.team=payments&label.tier=1", []string{"tagged-1"}},
+		{"label.team=", []string{"tagged-3"}},
+		{"tag=canary", []string{"tagged-1", "tagged-2"}},
+		{"tag=canary,arm64", []string{"tagged-1"}},
+		{"tag=arm64&label.team=payments", []string{"tagged-1"}},
+		{"tag=riscv", nil},
+	}
+	for _, tt := range tests {
+		if got := lookupIDs(t, r, "/lookup?service=tagged-service&"+tt.query); !slices.Equal(got, tt.want) {
+			t.Errorf("lookup with %s = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterLabelAndTagLimits(t *testing.T) {
+	r, _ := testRouter(t)
+	many := make(map[string]string, 33)
+	for i := range 33 {
+		many["key"+strconv.Itoa(i)] = "v"
+	}
+	manyTags := make([]string, 33)
+	for i := range manyTags {
+		manyTags[i] = "tag" + strconv.Itoa(i)
+	}
+	long := strings.Repeat("a", 64)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		tags   []string
+	}{
+		{"too many labels", many, nil},
+		{"empty label key", map[string]string{"": "v"}, nil},
+		{"label key too long", map[string]string{long: "v"}, nil},
+		{"label key with a dot", map[string]string{"a.b": "v"}, nil},
+		{"label key with a $", map[string]string{"$a": "v"}, nil},
+		{"label value too long", map[string]string{"team": strings.Repeat("a", 256)}, nil},
+		{"too many tags", nil, manyTags},
+		{"empty tag", nil, []string{""}},
+		{"tag too long", nil, []string{long}},
+	}
+	for _, tt := range tests {
+		inst := testInstance("limit-service", "limit-1", "us-east")
+		inst.Labels, inst.Tags = tt.labels, tt.tags
+		if w := serve(r, http.MethodPost, "/register", inst); w.Code != http.StatusBadRequest {
+			t.Errorf("register with %s answered %d, want 400", tt.name, w.Code)
+		}
+	}
+
+	inst := testInstance("limit-service", "limit-1", "us-east")
+	inst.Labels = map[string]string{strings.Repeat("a", 63): strings.Repeat("b", 255)}
+	inst.Tags = []string{strings.Repeat("c", 63)}
+	if w := serve(r, http.MethodPost, "/register", inst); w.Code != http.StatusOK {
+		t.Errorf("register at the limits answered %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+const (
+	// sendQueueSize bounds the events buffered for a single subscriber. A
+	// subscriber that falls this far behind is disconnected rather than
+	// being allowed to stall the broadcast or silently miss events.
+	sendQueueSize = 256
+	// eventBufferSize is the number of recent events kept so reconnecting
+	// subscribers can catch up without a new snapshot
+	eventBufferSize = 4096
+)
+
+// SnapshotFunc returns the instances currently selected by filter
+type SnapshotFunc func(ctx context.Context, filter repository.Filter) ([]models.Instance, error)
+
+// queued is an item in a subscriber's queue: a registry event, or a request
+// to send a snapshot of filter's selection as of revision
+type queued struct {
+	update   ServiceUpdate
+	snapshot bool
+	revision uint64
+	filter   repository.Filter
+}
+
+// subscriber is one consumer of the hub's events. The transport serving it
+// drains send until done is closed.
+type subscriber struct {
+	send      chan queued
+	done      chan struct{}
+	closeOnce sync.Once
+	evicted   bool // set before done is closed when the hub dropped the subscriber
+
+	filter repository.Filter // guarded by the hub's lock
+}
+
+// close tells the transport to stop serving the subscriber
+func (s *subscriber) close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
+
+// evict closes the subscriber because it could not keep up
+func (s *subscriber) evict() {
+	s.closeOnce.Do(func() {
+		s.evicted = true
+		close(s.done)
+	})
+}
+
+// Hub fans registry events out to its subscribers. Every event is given
+// the next revision and kept in a bounded buffer for reconnecting
+// subscribers. Broadcast never blocks on a subscriber: each one has a
+// bounded queue drained by its own writer.
+type Hub struct {
+	mu          sync.Mutex
+	subscribers map[*subscriber]struct{}
+	revision    uint64
+	events      []ServiceUpdate // ring buffer of the latest events
+	next        int             // position of the next event in events
+
+	snapshot SnapshotFunc
+}
+
+// NewHub creates a hub without subscribers. Revisions start from the
+// current time in microseconds so they keep increasing across restarts and
+// a revision handed out by a previous process is never mistaken for one of
+// this process.
+func NewHub() *Hub {
+	return &Hub{
+		subscribers: make(map[*subscriber]struct{}),
+		revision:    uint64(time.Now().UnixMicro()),
+		events:      make([]ServiceUpdate, 0, eventBufferSize),
+	}
+}
+
+// SetSnapshotFunc sets the source of the snapshots sent to subscribers
+func (h *Hub) SetSnapshotFunc(fn SnapshotFunc) {
+	h.mu.Lock()
+	h.snapshot = fn
+	h.mu.Unlock()
+}
+
+// Snapshot returns the instances selected by filter using the hub's
+// snapshot source
+func (h *Hub) Snapshot(ctx context.Context, filter repository.Filter) ([]models.Instance, error) {
+	h.mu.Lock()
+	fn := h.snapshot
+	h.mu.Unlock()
+	if fn == nil {
+		return []models.Instance{}, nil
+	}
+	return fn(ctx, filter)
+}
+
+// Revision returns the revision of the latest event
+func (h *Hub) Revision() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.revision
+}
+
+// subscribe registers a subscriber receiving the events selected by filter.
+// When the events after since are still buffered they are queued first,
+// otherwise (or when since is zero) the subscriber starts with a snapshot.
+func (h *Hub) subscribe(filter repository.Filter, since uint64) *subscriber {
+	s := &subscriber{
+		send:   make(chan queued, sendQueueSize),
+		done:   make(chan struct{}),
+		filter: filter,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subscribers[s] = struct{}{}
+
+	if since > 0 {
+		if missed, ok := h.since(since); ok {
+			var selected []ServiceUpdate
+			for _, msg := range missed {
+				if msg, ok := selectUpdate(filter, msg); ok {
+					selected = append(selected, msg)
+				}
+			}
+			// Catching up must leave room in the queue for new events
+			if len(selected) < sendQueueSize/2 {
+				for _, msg := range selected {
+					s.send <- queued{update: msg}
+				}
+				return s
+			}
+		}
+	}
+	s.send <- queued{snapshot: true, revision: h.revision, filter: filter}
+	return s
+}
+
+// resubscribe replaces a subscriber's filter and queues a snapshot of the
+// new selection, so events for newly selected instances have a base
+func (h *Hub) resubscribe(s *subscriber, filter repository.Filter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subscribers[s]; !ok {
+		return
+	}
+	s.filter = filter
+	h.enqueue(s, queued{snapshot: true, revision: h.revision, filter: filter})
+}
+
+// unsubscribe removes a subscriber and closes it
+func (h *Hub) unsubscribe(s *subscriber) {
+	h.mu.Lock()
+	delete(h.subscribers, s)
+	h.mu.Unlock()
+	s.close()
+}
+
+// Len returns the number of connected subscribers
+func (h *Hub) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+// Broadcast assigns msg the next revision, buffers it and queues it for
+// every subscriber whose filter selects it (see selectUpdate). Subscribers
+// whose queue is full are disconnected. Broadcasts are serialised so every
+// subscriber receives events in revision order.
+func (h *Hub) Broadcast(msg ServiceUpdate) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.revision++
+	msg.Revision = h.revision
+	if len(h.events) < eventBufferSize {
+		h.events = append(h.events, msg)
+	} else {
+		h.events[h.next] = msg
+	}
+	h.next = (h.next + 1) % eventBufferSize
+
+	for s := range h.subscribers {
+		if msg, ok := selectUpdate(s.filter, msg); ok {
+			h.enqueue(s, queued{update: msg})
+		}
+	}
+}
+
+// selectUpdate reports whether filter selects the instance of msg after the
+// change or before it. When it only selected the instance before, the
+// returned copy is marked Left so the subscriber drops the instance. An
+// expired instance is taken to have been selected whatever its health, as
+// its health before the expiry is not known.
+func selectUpdate(filter repository.Filter, msg ServiceUpdate) (ServiceUpdate, bool) {
+	if filter.Match(msg.Service) {
+		return msg, true
+	}
+	before := msg.Previous != nil && filter.Match(*msg.Previous)
+	if !before && msg.Action == ActionExpire {
+		filter.Health = nil
+		before = filter.Match(msg.Service)
+	}
+	msg.Left = before
+	return msg, before
+}
+
+// enqueue queues an item for s, evicting it when its queue is full. The
+// caller holds h.mu.
+func (h *Hub) enqueue(s *subscriber, item queued) {
+	select {
+	case s.send <- item:
+	default:
+		delete(h.subscribers, s)
+		s.evict()
+	}
+}
+
+// since returns the buffered events after revision in order, and false
+// when some of them have already left the buffer. The caller holds h.mu.
+func (h *Hub) since(revision uint64) ([]ServiceUpdate, bool) {
+	if revision >= h.revision {
+		return nil, revision == h.revision
+	}
+	count := h.revision - revision
+	if count > uint64(len(h.events)) {
+		return nil, false
+	}
+	start := h.next - int(count)
+	if start < 0 {
+		start += len(h.events)
+	}
+	missed := make([]ServiceUpdate, 0, count)
+	for i := 0; i < int(count); i++ {
+		missed = append(missed, h.events[(start+i)%len(h.events)])
+	}
+	return missed, true
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+// drain returns the items queued for s
+func drain(s *subscriber) []queued {
+	var items []queued
+	for {
+		select {
+		case item := <-s.send:
+			items = append(items, item)
+		default:
+			return items
+		}
+	}
+}
+
+func TestHubEvictsSlowSubscriber(t *testing.T) {
+	h := NewHub()
+	fast := h.subscribe(repository.Filter{}, 0)
+	slow := h.subscribe(repository.Filter{}, 0)
+
+	for i := 0; i < sendQueueSize+1; i++ {
+		h.Broadcast(ServiceUpdate{Action: ActionHeartbeat, Service: models.Instance{ID: "order-1"}})
+		drain(fast)
+	}
+
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("slow subscriber was not disconnected")
+	}
+	if !slow.evicted {
+		t.Error("slow subscriber not marked as evicted")
+	}
+	select {
+	case <-fast.done:
+		t.Fatal("fast subscriber was disconnected")
+	default:
+	}
+	if got := h.Len(); got != 1 {
+		t.Errorf("Len() = %d, want 1", got)
+	}
+}
+
+func TestHubFiltersEvents(t *testing.T) {
+	h := NewHub()
+	orders := h.subscribe(repository.Filter{ServiceName: "order-service"}, 0)
+	prod := h.subscribe(repository.Filter{Mode: "prod", Metadata: map[string]interface{}{"region": "us-east"}}, 0)
+	drain(orders)
+	drain(prod)
+
+	h.Broadcast(ServiceUpdate{Action: ActionRegister, Service: models.Instance{ServiceName: "order-service", ID: "order-1", Mode: "dev"}})
+	h.Broadcast(ServiceUpdate{Action: ActionRegister, Service: models.Instance{
+		ServiceName: "user-service",
+		ID:          "user-1",
+		Mode:        "prod",
+		Metadata:    models.Metadata{Region: "us-east"},
+	}})
+
+	if got := len(drain(orders)); got != 1 {
+		t.Errorf("order-service subscriber received %d events, want 1", got)
+	}
+	if got := len(drain(prod)); got != 1 {
+		t.Errorf("prod subscriber received %d events, want 1", got)
+	}
+
+	h.resubscribe(orders, repository.Filter{ServiceName: "user-service"})
+	h.Broadcast(ServiceUpdate{Action: ActionHeartbeat, Service: models.Instance{ServiceName: "user-service", ID: "user-1"}})
+	items := drain(orders)
+	if len(items) != 2 || !items[0].snapshot || items[1].update.Service.ID != "user-1" {
+		t.Errorf("resubscribed subscriber received %+v, want a snapshot then the user-1 event", items)
+	}
+}
+
+func TestHubEventsLeavingFilter(t *testing.T) {
+	h := NewHub()
+	east := h.subscribe(repository.Filter{Metadata: map[string]interface{}{"region": "us-east"}}, 0)
+	up := h.subscribe(repository.Filter{Health: []string{models.HealthUp}}, 0)
+	drain(east)
+	drain(up)
+
+	before := models.Instance{ServiceName: "order-service", ID: "order-1", Health: models.HealthUp, Metadata: models.Metadata{Region: "us-east"}}
+	moved := before
+	moved.Metadata.Region = "eu-west"
+	h.Broadcast(ServiceUpdate{Action: ActionUpdate, Service: moved, Previous: &before})
+	items := drain(east)
+	if len(items) != 1 || !items[0].update.Left {
+		t.Errorf("us-east subscriber received %+v, want the update marked left", items)
+	}
+	if items := drain(up); len(items) != 1 || items[0].update.Left {
+		t.Errorf("UP subscriber received %+v, want the update not marked left", items)
+	}
+
+	critical := moved
+	critical.Health = models.HealthCritical
+	h.Broadcast(ServiceUpdate{Action: ActionHealth, Service: critical, Previous: &moved})
+	if items := drain(up); len(items) != 1 || !items[0].update.Left {
+		t.Errorf("UP subscriber received %+v, want the health event marked left", items)
+	}
+	if items := drain(east); len(items) != 0 {
+		t.Errorf("us-east subscriber received %+v about an instance it no longer selected", items)
+	}
+
+	// A resuming subscriber is told the same
+	resumed := h.subscribe(repository.Filter{Metadata: map[string]interface{}{"region": "us-east"}}, items[0].update.Revision-1)
+	if items := drain(resumed); len(items) != 1 || !items[0].update.Left {
+		t.Errorf("resumed subscriber received %+v, want the update marked left", items)
+	}
+}
+
+func TestHubResume(t *testing.T) {
+	h := NewHub()
+
+	first := h.subscribe(repository.Filter{}, 0)
+	items := drain(first)
+	if len(items) != 1 || !items[0].snapshot {
+		t.Fatalf("new subscriber received %+v, want a snapshot", items)
+	}
+	start := items[0].revision
+
+	for _, id := range []string{"a", "b", "c"} {
+		h.Broadcast(ServiceUpdate{Action: ActionRegister, Service: models.Instance{ID: id}})
+	}
+	events := drain(first)
+	for i, item := range events {
+		if item.update.Revision != start+uint64(i)+1 {
+			t.Errorf("event %d revision = %d, want %d", i, item.update.Revision, start+uint64(i)+1)
+		}
+	}
+
+	resumed := h.subscribe(repository.Filter{}, events[0].update.Revision)
+	items = drain(resumed)
+	if len(items) != 2 || items[0].update.Service.ID != "b" || items[1].update.Service.ID != "c" {
+		t.Errorf("resumed subscriber received %+v, want events b and c", items)
+	}
+
+	stale := h.subscribe(repository.Filter{}, 1)
+	if items := drain(stale); len(items) != 1 || !items[0].snapshot {
+		t.Errorf("subscriber resuming from an unknown revision received %+v, want a snapshot", items)
+	}
+}
+
+func TestHubSinceWrapsAround(t *testing.T) {
+	h := NewHub()
+	for i := 0; i < eventBufferSize+10; i++ {
+		h.Broadcast(ServiceUpdate{Action: ActionHeartbeat})
+	}
+	latest := h.Revision()
+
+	h.mu.Lock()
+	missed, ok := h.since(latest - 5)
+	_, tooOld := h.since(latest - eventBufferSize - 1)
+	h.mu.Unlock()
+
+	if !ok || len(missed) != 5 {
+		t.Fatalf("since() = %d events, %v; want 5, true", len(missed), ok)
+	}
+	for i, msg := range missed {
+		if msg.Revision != latest-4+uint64(i) {
+			t.Errorf("missed[%d].Revision = %d, want %d", i, msg.Revision, latest-4+uint64(i))
+		}
+	}
+	if tooOld {
+		t.Error("since() reported evicted events as available")
+	}
+}
+
+// stepWatcher reports the stream as open or down as told on steps
+type stepWatcher struct {
+	steps chan bool
+	done  chan struct{}
+}
+
+func (w stepWatcher) Watch(ctx context.Context, fn func(repository.Change), live func(bool)) error {
+	for open := range w.steps {
+		live(open)
+		w.done <- struct{}{}
+	}
+	return errors.New("stream closed")
+}
+
+func TestPublishFallsBackWhileStreamIsDown(t *testing.T) {
+	sub := hub.subscribe(repository.Filter{ServiceName: "watch-service"}, 0)
+	defer hub.unsubscribe(sub)
+	drain(sub)
+	publish := func() int {
+		Publish(ServiceUpdate{Action: ActionHeartbeat, Service: models.Instance{ServiceName: "watch-service", ID: "watch-1"}})
+		return len(drain(sub))
+	}
+
+	w := stepWatcher{steps: make(chan bool), done: make(chan struct{})}
+	stopped := make(chan error)
+	go func() { stopped <- WatchRegistry(context.Background(), w) }()
+
+	if got := publish(); got != 1 {
+		t.Errorf("before the stream opened, Publish() delivered %d events, want 1", got)
+	}
+	w.steps <- true
+	<-w.done
+	if got := publish(); got != 0 {
+		t.Errorf("while the stream is open, Publish() delivered %d events, want 0", got)
+	}
+	w.steps <- false
+	<-w.done
+	if got := publish(); got != 1 {
+		t.Errorf("while the stream is down, Publish() delivered %d events, want 1", got)
+	}
+	w.steps <- true
+	<-w.done
+	close(w.steps)
+	<-stopped
+	if got := publish(); got != 1 {
+		t.Errorf("after WatchRegistry returned, Publish() delivered %d events, want 1", got)
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"math"
+	"time"
+)
+
+// Lease bounds the lease an instance may ask for when it registers
+type Lease struct {
+	Default time.Duration // lease of instances that do not ask for one
+	Min     time.Duration
+	Max     time.Duration
+}
+
+// seconds returns the lease granted for a requested ttl in seconds: the
+// default when none was requested, otherwise ttl clamped to the bounds
+func (l Lease) seconds(ttl int) int {
+	lease := l.Default
+	if ttl > 0 {
+		// Capped first so that a huge ttl cannot overflow the duration
+		lease = time.Duration(min(int64(ttl), math.MaxInt64/int64(time.Second))) * time.Second
+	}
+	if l.Min > 0 {
+		lease = max(lease, l.Min)
+	}
+	if l.Max > 0 {
+		lease = min(lease, l.Max)
+	}
+	seconds := lease / time.Second
+	if lease%time.Second != 0 {
+		seconds++
+	}
+	return int(seconds)
+}
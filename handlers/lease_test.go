@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLeaseSeconds(t *testing.T) {
+	bounded := Lease{Default: time.Minute, Min: 5 * time.Second, Max: 10 * time.Minute}
+	unbounded := Lease{Default: time.Minute}
+	tests := []struct {
+		name  string
+		lease Lease
+		ttl   int
+		want  int
+	}{
+		{"default", bounded, 0, 60},
+		{"within bounds", bounded, 30, 30},
+		{"below min", bounded, 1, 5},
+		{"above max", bounded, 3600, 600},
+		{"overflowing", bounded, math.MaxInt, 600},
+		{"overflowing without bounds", unbounded, math.MaxInt, int(math.MaxInt64 / int64(time.Second))},
+		{"partial second", Lease{Default: 1500 * time.Millisecond}, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lease.seconds(tt.ttl); got != tt.want {
+				t.Errorf("seconds(%d) = %d, want %d", tt.ttl, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/spidey52/service-discovery/repository"
+)
+
+// localityParams are the lookup parameters parsed by parseLocality
+var localityParams = []string{"preferRegion", "preferZone", "minHealthy", "minHealthyPercent"}
+
+// parseLocality builds a locality preference from query parameters:
+// preferRegion and preferZone name the caller's region and zone, minHealthy
+// is the number of healthy instances a tier needs before lookups fail over
+// to the next one, 1 by default, and minHealthyPercent the share of all
+// healthy instances it needs, 0 by default
+func parseLocality(query url.Values) (repository.Locality, error) {
+	loc := repository.Locality{
+		Region: query.Get("preferRegion"),
+		Zone:   query.Get("preferZone"),
+	}
+	if s := query.Get("minHealthy"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return loc, fmt.Errorf("invalid minHealthy %q", s)
+		}
+		loc.MinHealthy = n
+	}
+	if s := query.Get("minHealthyPercent"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 100 {
+			return loc, fmt.Errorf("minHealthyPercent must be between 0 and 100")
+		}
+		loc.MinPercent = n
+	}
+	return loc, nil
+}
+
+// localize narrows filter to the closest tier of loc among the instances
+// filter selects, ignoring its page
+func localize(ctx context.Context, repo repository.Registry, filter repository.Filter, loc repository.Locality, ttl time.Duration) (repository.Filter, repository.Tier, error) {
+	all := filter
+	all.Page = repository.Page{}
+	candidates, err := repo.Find(ctx, all, ttl)
+	if err != nil {
+		return filter, "", err
+	}
+	tier := loc.Tier(candidates, time.Now(), ttl)
+	filter.Conditions = append(slices.Clip(filter.Conditions), loc.Conditions(tier)...)
+	return filter, tier, nil
+}
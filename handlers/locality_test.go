@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+func TestLookupLocality(t *testing.T) {
+	r, _ := testRouter(t)
+	for _, spec := range []struct{ id, region, zone string }{
+		{"local-1", "us-east", "us-east-1a"},
+		{"local-2", "us-east", "us-east-1b"},
+		{"local-3", "us-east", "us-east-1b"},
+		{"local-4", "eu-west", "eu-west-1a"},
+	} {
+		inst := testInstance("local-service", spec.id, spec.region)
+		inst.Metadata.Zone = spec.zone
+		serve(r, http.MethodPost, "/register", inst)
+	}
+	everyone := []string{"local-1", "local-2", "local-3", "local-4"}
+
+	tests := []struct {
+		name  string
+		query string
+		tier  string
+		want  []string
+	}{
+		{"no preference", "", "", everyone},
+		{"caller's zone", "preferRegion=us-east&preferZone=us-east-1a", "zone", []string{"local-1"}},
+		{"zone below minHealthy", "preferRegion=us-east&preferZone=us-east-1a&minHealthy=2", "region", []string{"local-1", "local-2", "local-3"}},
+		{"zone meeting minHealthy", "preferRegion=us-east&preferZone=us-east-1b&minHealthy=2", "zone", []string{"local-2", "local-3"}},
+		{"zone below minHealthyPercent", "preferRegion=us-east&preferZone=us-east-1a&minHealthyPercent=50", "region", []string{"local-1", "local-2", "local-3"}},
+		{"region below minHealthy", "preferRegion=us-east&minHealthy=4", "any", everyone},
+		{"unknown region", "preferRegion=ap-south", "any", everyone},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "/lookup?service=local-service&" + tt.query
+			if got := lookupIDs(t, r, target); !slices.Equal(got, tt.want) {
+				t.Errorf("lookup = %v, want %v", got, tt.want)
+			}
+			if got := serve(r, http.MethodGet, target, nil).Header().Get("X-Locality"); got != tt.tier {
+				t.Errorf("lookup X-Locality = %q, want %q", got, tt.tier)
+			}
+
+			target = "/resolve?service=local-service&count=100&" + tt.query
+			if got := lookupIDs(t, r, target); !slices.Equal(got, tt.want) {
+				t.Errorf("resolve = %v, want %v", got, tt.want)
+			}
+			if got := serve(r, http.MethodGet, target, nil).Header().Get("X-Locality"); got != tt.tier {
+				t.Errorf("resolve X-Locality = %q, want %q", got, tt.tier)
+			}
+		})
+	}
+
+	// A CRITICAL instance doesn't count towards its zone
+	serve(r, http.MethodPost, "/heartbeat", models.Heartbeat{ServiceName: "local-service", ID: "local-1", Status: models.StatusCritical})
+	target := "/lookup?service=local-service&preferRegion=us-east&preferZone=us-east-1a"
+	if got := lookupIDs(t, r, target); !slices.Equal(got, []string{"local-2", "local-3"}) {
+		t.Errorf("lookup with a CRITICAL zone = %v, want the rest of the region", got)
+	}
+	if got := serve(r, http.MethodGet, "/resolve?service=local-service&preferRegion=us-east&preferZone=us-east-1a", nil).Header().Get("X-Locality"); got != "region" {
+		t.Errorf("resolve with a CRITICAL zone X-Locality = %q, want region", got)
+	}
+
+	for _, query := range []string{"minHealthy=0", "minHealthy=x", "minHealthyPercent=101"} {
+		if w := serve(r, http.MethodGet, "/lookup?service=local-service&preferRegion=us-east&"+query, nil); w.Code != http.StatusBadRequest {
+			t.Errorf("lookup with %s answered %d, want 400", query, w.Code)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+// maintenanceRequest puts instances into maintenance. It ends by itself at
+// until, or after duration, when either is given.
+type maintenanceRequest struct {
+	State    string    `json:"state" binding:"required,oneof=maintenance draining"`
+	Reason   string    `json:"reason" binding:"max=256"`
+	Until    time.Time `json:"until"`
+	Duration string    `json:"duration"` // e.g. 30m
+}
+
+// setupMaintenanceRoutes wires the endpoints taking a whole service or a
+// single instance in and out of maintenance
+func setupMaintenanceRoutes(r *gin.Engine, repo repository.Registry) {
+	set := func(c *gin.Context, id string) ([]models.Instance, bool) {
+		var req maintenanceRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+		now := time.Now().UTC()
+		m := &models.Maintenance{State: req.State, Reason: req.Reason, Since: now, Until: req.Until}
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil || d <= 0 || !req.Until.IsZero() {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "duration must be a positive duration and cannot be combined with until"})
+				return nil, false
+			}
+			m.Until = now.Add(d)
+		}
+		if !m.Until.IsZero() && !m.Until.After(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "until must be in the future"})
+			return nil, false
+		}
+		return apply(c, repo, c.Param("name"), id, m)
+	}
+
+	r.PUT("/services/:name/maintenance", func(c *gin.Context) {
+		if changed, ok := set(c, ""); ok {
+			c.JSON(http.StatusOK, changed)
+		}
+	})
+	r.DELETE("/services/:name/maintenance", func(c *gin.Context) {
+		if changed, ok := apply(c, repo, c.Param("name"), "", nil); ok {
+			c.JSON(http.StatusOK, changed)
+		}
+	})
+	r.PUT("/services/:name/instances/:id/maintenance", func(c *gin.Context) {
+		if changed, ok := set(c, c.Param("id")); ok {
+			c.JSON(http.StatusOK, changed[0])
+		}
+	})
+	r.DELETE("/services/:name/instances/:id/maintenance", func(c *gin.Context) {
+		if changed, ok := apply(c, repo, c.Param("name"), c.Param("id"), nil); ok {
+			c.JSON(http.StatusOK, changed[0])
+		}
+	})
+}
+
+// apply stores the maintenance and broadcasts every changed instance,
+// answering the request itself when it fails
+func apply(c *gin.Context, repo repository.Registry, serviceName, id string, m *models.Maintenance) ([]models.Instance, bool) {
+	changed, err := repo.SetMaintenance(c.Request.Context(), serviceName, id, m)
+	if err != nil {
+		c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+		return nil, false
+	}
+	for _, inst := range changed {
+		Publish(ServiceUpdate{Action: ActionMaintenance, Service: inst})
+	}
+	return changed, true
+}
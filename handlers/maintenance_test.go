@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+// lookupIDs returns the ids of the instances a lookup or resolve answered
+func lookupIDs(t *testing.T, r http.Handler, target string) []string {
+	t.Helper()
+	w := serve(r, http.MethodGet, target, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s answered %d: %s", target, w.Code, w.Body)
+	}
+	var instances []models.Instance
+	if err := json.Unmarshal(w.Body.Bytes(), &instances); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, inst := range instances {
+		ids = append(ids, inst.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// maintenanceEvents returns the ids of the maintenance events published to
+// sub, sorted
+func maintenanceEvents(t *testing.T, sub *subscriber) []string {
+	t.Helper()
+	var ids []string
+	for _, item := range drain(sub) {
+		if item.update.Action != ActionMaintenance {
+			t.Errorf("published a %s event, want only maintenance events", item.update.Action)
+			continue
+		}
+		ids = append(ids, item.update.Service.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestMaintenance(t *testing.T) {
+	r, _ := testRouter(t)
+	serve(r, http.MethodPost, "/register", testInstance("maint-service", "maint-1", "us-east"))
+	serve(r, http.MethodPost, "/register", testInstance("maint-service", "maint-2", "us-east"))
+	sub := hub.subscribe(repository.Filter{ServiceName: "maint-service"}, 0)
+	defer hub.unsubscribe(sub)
+	drain(sub)
+
+	// Draining one instance leaves it out of lookups and resolves
+	w := serve(r, http.MethodPut, "/services/maint-service/instances/maint-1/maintenance", map[string]any{
+		"state": models.StateDraining, "reason": "deploy", "duration": "10m",
+	})
+	var stored models.Instance
+	if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("PUT instance maintenance answered %d: %s", w.Code, w.Body)
+	}
+	if m := stored.Maintenance; m == nil || m.State != models.StateDraining || m.Reason != "deploy" || m.Until.Sub(m.Since) != 10*time.Minute {
+		t.Errorf("stored maintenance %+v, want draining for 10m", stored.Maintenance)
+	}
+	if got := maintenanceEvents(t, sub); !slices.Equal(got, []string{"maint-1"}) {
+		t.Errorf("published maintenance of %v, want [maint-1]", got)
+	}
+	if got := lookupIDs(t, r, "/lookup?service=maint-service"); !slices.Equal(got, []string{"maint-2"}) {
+		t.Errorf("lookup returned %v, want [maint-2]", got)
+	}
+	if got := lookupIDs(t, r, "/lookup?service=maint-service&includeMaintenance=true"); !slices.Equal(got, []string{"maint-1", "maint-2"}) {
+		t.Errorf("lookup including maintenance returned %v, want both", got)
+	}
+	if got := lookupIDs(t, r, "/resolve?service=maint-service&count=2"); !slices.Equal(got, []string{"maint-2"}) {
+		t.Errorf("resolve returned %v, want [maint-2]", got)
+	}
+
+	// The whole service in maintenance leaves nothing to resolve
+	w = serve(r, http.MethodPut, "/services/maint-service/maintenance", map[string]any{"state": models.StateMaintenance})
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT service maintenance answered %d: %s", w.Code, w.Body)
+	}
+	if got := maintenanceEvents(t, sub); !slices.Equal(got, []string{"maint-1", "maint-2"}) {
+		t.Errorf("published maintenance of %v, want both instances", got)
+	}
+	if got := lookupIDs(t, r, "/lookup?service=maint-service"); len(got) != 0 {
+		t.Errorf("lookup returned %v, want none", got)
+	}
+	if w := serve(r, http.MethodGet, "/resolve?service=maint-service", nil); w.Code != http.StatusNotFound {
+		t.Errorf("resolve answered %d, want 404", w.Code)
+	}
+
+	// Ending it brings the instances back
+	w = serve(r, http.MethodDelete, "/services/maint-service/instances/maint-1/maintenance", nil)
+	stored = models.Instance{}
+	if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("DELETE instance maintenance answered %d: %s", w.Code, w.Body)
+	}
+	if stored.Maintenance != nil {
+		t.Errorf("stored maintenance %+v after it ended", stored.Maintenance)
+	}
+	if got := maintenanceEvents(t, sub); !slices.Equal(got, []string{"maint-1"}) {
+		t.Errorf("published maintenance of %v, want [maint-1]", got)
+	}
+	if got := lookupIDs(t, r, "/resolve?service=maint-service&count=2"); !slices.Equal(got, []string{"maint-1"}) {
+		t.Errorf("resolve returned %v, want [maint-1]", got)
+	}
+	if w := serve(r, http.MethodDelete, "/services/maint-service/maintenance", nil); w.Code != http.StatusOK {
+		t.Fatalf("DELETE service maintenance answered %d: %s", w.Code, w.Body)
+	}
+	if got := maintenanceEvents(t, sub); !slices.Contains(got, "maint-2") {
+		t.Errorf("published maintenance of %v, want maint-2 among them", got)
+	}
+	if got := lookupIDs(t, r, "/lookup?service=maint-service"); !slices.Equal(got, []string{"maint-1", "maint-2"}) {
+		t.Errorf("lookup returned %v, want both", got)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   any
+		want   int
+	}{
+		{"unknown state", http.MethodPut, "/services/maint-service/maintenance", map[string]any{"state": "down"}, http.StatusBadRequest},
+		{"invalid duration", http.MethodPut, "/services/maint-service/maintenance", map[string]any{"state": "maintenance", "duration": "-1m"}, http.StatusBadRequest},
+		{"duration and until", http.MethodPut, "/services/maint-service/maintenance", map[string]any{"state": "maintenance", "duration": "1m", "until": time.Now().Add(time.Hour)}, http.StatusBadRequest},
+		{"until in the past", http.MethodPut, "/services/maint-service/maintenance", map[string]any{"state": "maintenance", "until": time.Now().Add(-time.Hour)}, http.StatusBadRequest},
+		{"unknown service", http.MethodPut, "/services/missing/maintenance", map[string]any{"state": "maintenance"}, http.StatusNotFound},
+		{"unknown instance", http.MethodPut, "/services/maint-service/instances/missing/maintenance", map[string]any{"state": "maintenance"}, http.StatusNotFound},
+		{"end on unknown instance", http.MethodDelete, "/services/maint-service/instances/missing/maintenance", nil, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(r, tt.method, tt.target, tt.body); w.Code != tt.want {
+				t.Errorf("answered %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+	if items := drain(sub); len(items) != 0 {
+		t.Errorf("failed requests published %+v", items)
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+// maxLimit caps the page size of a lookup
+const maxLimit = 1000
+
+// pageParams are the lookup parameters parsed by parsePage
+var pageParams = []string{"limit", "cursor", "sort", "fields"}
+
+// projectable are the instance fields a lookup can select. Fields of
+// metadata, labels, load and maintenance can be selected by their dotted
+// path.
+var projectable = map[string]bool{
+	"serviceName": true, "id": true, "host": true, "port": true, "mode": true,
+	"metadata": true, "labels": true, "tags": true, "endpoints": true, "weight": true, "ttl": true, "checks": true, "maintenance": true,
+	"health": true, "reportedHealth": true, "output": true, "load": true, "checkHealth": true, "checkOutput": true,
+	"lastHeartbeat": true, "expiresAt": true,
+}
+
+// parsePage builds a page from query parameters: limit the page size,
+// cursor the X-Next-Cursor of the previous page, sort one of serviceName,
+// lastHeartbeat and version, prefixed with - for descending order, and
+// fields a comma-separated list of fields to return
+func parsePage(query url.Values) (repository.Page, error) {
+	var page repository.Page
+	if s := query.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxLimit)
+		}
+		page.Limit = limit
+	}
+	if s := query.Get("sort"); s != "" {
+		key, desc := strings.CutPrefix(s, "-")
+		page.Sort = repository.SortKey(key)
+		page.Desc = desc
+		if !page.Sort.Valid() {
+			return page, fmt.Errorf("invalid sort %q", s)
+		}
+	}
+	page.After = query.Get("cursor")
+	if s := query.Get("fields"); s != "" {
+		for _, field := range strings.Split(s, ",") {
+			parts := strings.Split(field, ".")
+			// Empty segments and $ would reach the database projection
+			if !projectable[parts[0]] || slices.Contains(parts, "") || strings.Contains(field, "$") {
+				return page, fmt.Errorf("invalid field %q", field)
+			}
+			page.Fields = append(page.Fields, field)
+		}
+	}
+	return page, nil
+}
+
+// project renders instances with only the fields selected by page, plus
+// serviceName and id
+func project(instances []models.Instance, page repository.Page) ([]map[string]any, error) {
+	projected := make([]map[string]any, 0, len(instances))
+	for _, inst := range instances {
+		raw, err := json.Marshal(inst)
+		if err != nil {
+			return nil, err
+		}
+		var doc map[string]any
+		if err := json.Unmarshal(raw, &doc); err != nil {
+			return nil, err
+		}
+		out := map[string]any{"serviceName": inst.ServiceName, "id": inst.ID}
+		for _, field := range page.Fields {
+			if v, ok := lookupField(doc, field); ok {
+				setField(out, field, v)
+			}
+		}
+		projected = append(projected, out)
+	}
+	return projected, nil
+}
+
+// lookupField resolves a dotted path inside a JSON document
+func lookupField(doc map[string]any, path string) (any, bool) {
+	var cur any = doc
+	for _, part := range strings.Split(path, ".") {
+		m, ok := cur.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if cur, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// setField stores v at a dotted path, creating the objects on the way
+func setField(doc map[string]any, path string, v any) {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := doc[part].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			doc[part] = next
+		}
+		doc = next
+	}
+	doc[parts[len(parts)-1]] = v
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+)
+
+func TestParsePageFields(t *testing.T) {
+	page, err := parsePage(url.Values{"fields": {"host,reportedHealth,checkHealth,checkOutput,metadata.version"}})
+	if err != nil {
+		t.Fatalf("parsePage() error = %v", err)
+	}
+	want := []string{"host", "reportedHealth", "checkHealth", "checkOutput", "metadata.version"}
+	if !slices.Equal(page.Fields, want) {
+		t.Errorf("fields = %v, want %v", page.Fields, want)
+	}
+
+	for _, fields := range []string{"secret", "metadata.$where", "labels.$", "metadata..version", "metadata.", ".host"} {
+		if _, err := parsePage(url.Values{"fields": {fields}}); err == nil {
+			t.Errorf("parsePage(fields=%s) accepted an invalid field", fields)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/spidey52/service-discovery/balancer"
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+// resolveParams are the resolve parameters besides the lookup filters
+var resolveParams = []string{"strategy", "key", "session", "count"}
+
+// maxCount caps the instances a resolve returns
+const maxCount = 100
+
+// setupResolveRoutes wires /resolve, which picks instances among those a
+// lookup with the same filters and locality preference would return
+func setupResolveRoutes(r *gin.Engine, repo repository.Registry, lease Lease, lb *balancer.Balancer) {
+	r.GET("/resolve", func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		// Taken as metadata fields they would match nothing
+		for _, name := range slices.Concat(pageParams, blockingParams) {
+			if query.Has(name) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is not supported by /resolve", name)})
+				return
+			}
+		}
+		filter, err := parseFilter(query, slices.Concat(resolveParams, localityParams)...)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		loc, err := parseLocality(query)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		req, err := parseResolve(query)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		candidates, err := repo.Find(c.Request.Context(), filter, lease.Default)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if loc.Enabled() {
+			tier := loc.Tier(candidates, time.Now(), lease.Default)
+			local := repository.Filter{Conditions: loc.Conditions(tier)}
+			candidates = slices.DeleteFunc(candidates, func(inst models.Instance) bool { return !local.Match(inst) })
+			c.Header("X-Locality", string(tier))
+		}
+		picked, err := lb.Pick(candidates, req)
+		if errors.Is(err, balancer.ErrNoInstances) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		for i := range picked {
+			picked[i] = picked[i].WithAddresses()
+		}
+
+		if query.Has("count") {
+			c.JSON(http.StatusOK, picked)
+			return
+		}
+		c.JSON(http.StatusOK, picked[0])
+	})
+}
+
+// parseResolve builds a balancer request from query parameters: strategy,
+// key for the hash strategy, session to stick to an instance and count.
+// The pool is identified by the remaining parameters, the lookup filters.
+func parseResolve(query url.Values) (balancer.Request, error) {
+	req := balancer.Request{
+		Strategy: balancer.Strategy(query.Get("strategy")),
+		Key:      query.Get("key"),
+		Session:  query.Get("session"),
+	}
+	if req.Strategy == "" {
+		req.Strategy = balancer.RoundRobin
+	}
+	if !req.Strategy.Valid() {
+		return req, fmt.Errorf("invalid strategy %q", req.Strategy)
+	}
+	if s := query.Get("count"); s != "" {
+		count, err := strconv.Atoi(s)
+		if err != nil || count < 1 || count > maxCount {
+			return req, fmt.Errorf("count must be between 1 and %d", maxCount)
+		}
+		req.Count = count
+	}
+
+	pool := url.Values{}
+	for key, vals := range query {
+		if !slices.Contains(resolveParams, key) {
+			pool[key] = vals
+		}
+	}
+	req.Pool = pool.Encode()
+	return req, nil
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+// setupServiceRoutes wires the service catalog, which summarizes instances
+// on the backend instead of returning them, and the endpoints reading and
+// amending a single instance
+func setupServiceRoutes(r *gin.Engine, repo repository.Registry, lease Lease) {
+	r.GET("/services", func(c *gin.Context) {
+		services, err := repo.Services(c.Request.Context(), lease.Default)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, services)
+	})
+
+	r.GET("/services/:name", func(c *gin.Context) {
+		service, err := repo.Service(c.Request.Context(), c.Param("name"), lease.Default)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, service)
+	})
+
+	r.GET("/services/:name/instances/:id", func(c *gin.Context) {
+		inst, err := repo.Get(c.Request.Context(), c.Param("name"), c.Param("id"))
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, inst.WithAddresses())
+	})
+
+	// update stores a validated replacement of the instance's registration
+	update := func(c *gin.Context, spec models.Instance) {
+		if spec.ServiceName != c.Param("name") || spec.ID != c.Param("id") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "serviceName and id cannot be changed"})
+			return
+		}
+		if err := binding.Validator.ValidateStruct(&spec); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		spec.TTL = lease.seconds(spec.TTL)
+		stored, previous, err := repo.Update(c.Request.Context(), spec)
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		Publish(ServiceUpdate{Action: ActionUpdate, Service: stored, Previous: &previous})
+		c.JSON(http.StatusOK, stored)
+	}
+
+	// PUT replaces the registration; serviceName and id may be left out
+	r.PUT("/services/:name/instances/:id", func(c *gin.Context) {
+		var spec models.Instance
+		if err := json.NewDecoder(c.Request.Body).Decode(&spec); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if spec.ServiceName == "" {
+			spec.ServiceName = c.Param("name")
+		}
+		if spec.ID == "" {
+			spec.ID = c.Param("id")
+		}
+		update(c, spec)
+	})
+
+	// PATCH applies a JSON merge patch (RFC 7386) to the stored instance
+	r.PATCH("/services/:name/instances/:id", func(c *gin.Context) {
+		var patch map[string]any
+		if err := json.NewDecoder(c.Request.Body).Decode(&patch); err != nil || patch == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "body must be a JSON object"})
+			return
+		}
+		inst, err := repo.Get(c.Request.Context(), c.Param("name"), c.Param("id"))
+		if err != nil {
+			c.JSON(errorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		spec, err := mergeInstance(inst, patch)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		update(c, spec)
+	})
+}
+
+// mergeInstance applies a JSON merge patch to the JSON form of inst
+func mergeInstance(inst models.Instance, patch map[string]any) (models.Instance, error) {
+	raw, err := json.Marshal(inst)
+	if err != nil {
+		return inst, err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return inst, err
+	}
+	if raw, err = json.Marshal(mergePatch(doc, patch)); err != nil {
+		return inst, err
+	}
+	var merged models.Instance
+	if err := json.Unmarshal(raw, &merged); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return inst, errors.New("invalid value for " + typeErr.Field)
+		}
+		return inst, err
+	}
+	return merged, nil
+}
+
+// mergePatch merges patch into doc: null removes a member, objects are
+// merged recursively and any other value replaces the member
+func mergePatch(doc, patch map[string]any) map[string]any {
+	for k, v := range patch {
+		switch v := v.(type) {
+		case nil:
+			delete(doc, k)
+		case map[string]any:
+			target, _ := doc[k].(map[string]any)
+			if target == nil {
+				target = map[string]any{}
+			}
+			doc[k] = mergePatch(target, v)
+		default:
+			doc[k] = v
+		}
+	}
+	return doc
+}
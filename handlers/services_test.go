@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+func TestServiceCatalog(t *testing.T) {
+	r, _ := testRouter(t)
+	serve(r, http.MethodPost, "/register", testInstance("order-service", "order-1", "us-east"))
+	serve(r, http.MethodPost, "/register", testInstance("order-service", "order-2", "eu-west"))
+	serve(r, http.MethodPost, "/register", testInstance("user-service", "user-1", "us-east"))
+	serve(r, http.MethodPost, "/heartbeat", models.Heartbeat{ServiceName: "order-service", ID: "order-2", Status: models.StatusCritical})
+
+	w := serve(r, http.MethodGet, "/services", nil)
+	var services []models.ServiceSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &services); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /services answered %d: %s", w.Code, w.Body)
+	}
+	if len(services) != 2 || services[0].Name != "order-service" || services[1].Name != "user-service" {
+		t.Fatalf("GET /services = %+v, want order-service and user-service", services)
+	}
+	if got := services[0]; got.Instances != 2 || got.Healthy != 1 || got.Unhealthy != 1 {
+		t.Errorf("order-service summary = %+v, want 2 instances, 1 healthy", got)
+	}
+
+	w = serve(r, http.MethodGet, "/services/order-service", nil)
+	var detail models.ServiceDetail
+	if err := json.Unmarshal(w.Body.Bytes(), &detail); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("GET /services/order-service answered %d: %s", w.Code, w.Body)
+	}
+	if detail.Instances != 2 || detail.ByRegion["us-east"] != 1 || detail.ByRegion["eu-west"] != 1 ||
+		detail.Health[models.HealthUp] != 1 || detail.Health[models.HealthCritical] != 1 {
+		t.Errorf("GET /services/order-service = %+v, want one UP instance per region and one CRITICAL", detail)
+	}
+
+	if w := serve(r, http.MethodGet, "/services/missing-service", nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET /services/missing-service answered %d, want 404", w.Code)
+	}
+}
+
+func TestInstanceReplaceAndPatch(t *testing.T) {
+	r, _ := testRouter(t)
+	spec := testInstance("amend-service", "amend-1", "us-east")
+	spec.TTL = 30
+	w := serve(r, http.MethodPost, "/register", spec)
+	var registered models.Instance
+	if err := json.Unmarshal(w.Body.Bytes(), &registered); err != nil {
+		t.Fatal(err)
+	}
+	sub := hub.subscribe(repository.Filter{ServiceName: "amend-service"}, 0)
+	defer hub.unsubscribe(sub)
+	drain(sub)
+
+	// PUT replaces the registration, serviceName and id may be left out
+	spec.ServiceName, spec.ID = "", ""
+	spec.Port = 9090
+	w = serve(r, http.MethodPut, "/services/amend-service/instances/amend-1", spec)
+	var stored models.Instance
+	if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("PUT answered %d: %s", w.Code, w.Body)
+	}
+	if stored.Port != 9090 || !stored.LastHeartbeat.Equal(registered.LastHeartbeat) {
+		t.Errorf("PUT stored %+v, want the new port and the lease kept", stored)
+	}
+	if items := drain(sub); len(items) != 1 || items[0].update.Action != ActionUpdate {
+		t.Errorf("PUT published %+v, want an update event", items)
+	}
+
+	// PATCH changes only the members sent, a new ttl moves the end of the lease
+	w = serve(r, http.MethodPatch, "/services/amend-service/instances/amend-1", map[string]any{
+		"ttl":      600,
+		"metadata": map[string]any{"developer": "jane"},
+	})
+	if err := json.Unmarshal(w.Body.Bytes(), &stored); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("PATCH answered %d: %s", w.Code, w.Body)
+	}
+	if stored.Port != 9090 || stored.Metadata.Developer != "jane" || stored.Metadata.Region != "us-east" {
+		t.Errorf("PATCH stored %+v, want only the developer changed", stored)
+	}
+	if want := registered.LastHeartbeat.Add(10 * time.Minute); stored.TTL != 600 || !stored.ExpiresAt.Equal(want) {
+		t.Errorf("PATCH stored ttl %d expiring at %v, want 600 expiring at %v", stored.TTL, stored.ExpiresAt, want)
+	}
+	if items := drain(sub); len(items) != 1 || items[0].update.Action != ActionUpdate {
+		t.Errorf("PATCH published %+v, want an update event", items)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   any
+		want   int
+	}{
+		{"PUT unknown instance", http.MethodPut, "/services/amend-service/instances/missing", testInstance("", "", "us-east"), http.StatusNotFound},
+		{"PUT changing the id", http.MethodPut, "/services/amend-service/instances/amend-1", testInstance("amend-service", "amend-2", "us-east"), http.StatusBadRequest},
+		{"PUT invalid", http.MethodPut, "/services/amend-service/instances/amend-1", map[string]any{"port": 8080}, http.StatusBadRequest},
+		{"PATCH unknown instance", http.MethodPatch, "/services/amend-service/instances/missing", map[string]any{"port": 1}, http.StatusNotFound},
+		{"PATCH removing a required member", http.MethodPatch, "/services/amend-service/instances/amend-1", map[string]any{"host": nil}, http.StatusBadRequest},
+		{"PATCH with a wrong type", http.MethodPatch, "/services/amend-service/instances/amend-1", map[string]any{"port": "80"}, http.StatusBadRequest},
+		{"PATCH not an object", http.MethodPatch, "/services/amend-service/instances/amend-1", []int{1}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serve(r, tt.method, tt.target, tt.body); w.Code != tt.want {
+				t.Errorf("answered %d, want %d: %s", w.Code, tt.want, w.Body)
+			}
+		})
+	}
+	if items := drain(sub); len(items) != 0 {
+		t.Errorf("rejected requests published %+v", items)
+	}
+}
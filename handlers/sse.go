@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sseKeepAlive is the interval of the comments sent to keep idle streams
+// open through proxies
+const sseKeepAlive = 30 * time.Second
+
+// HandleWatch streams registry events as Server-Sent Events. It accepts the
+// same filters as /lookup and, like /ws, starts with a snapshot followed by
+// deltas. Every event's id is its revision, so a reconnecting EventSource
+// resumes through the Last-Event-ID header (or ?since=) from where it
+// stopped.
+func HandleWatch(c *gin.Context) {
+	lastID := c.GetHeader("Last-Event-ID")
+	if lastID == "" {
+		lastID = c.Query("since")
+	}
+	since, err := parseRevision(lastID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := parseFilter(c.Request.URL.Query(), "since")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sub := hub.subscribe(filter, since)
+	defer hub.unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering
+	c.Status(http.StatusOK)
+
+	rc := http.NewResponseController(c.Writer)
+	write := func(format string, args ...any) error {
+		_ = rc.SetWriteDeadline(time.Now().Add(writeWait))
+		if _, err := fmt.Fprintf(c.Writer, format, args...); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	if err := write("retry: %d\n\n", time.Second.Milliseconds()); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(sseKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case item := <-sub.send:
+			var msg any = item.update
+			event, revision := item.update.Action, item.update.Revision
+			if item.snapshot {
+				snapshot, err := takeSnapshot(item)
+				if err != nil {
+					log.Printf("SSE snapshot failed: %v", err)
+					return
+				}
+				msg, event, revision = snapshot, snapshot.Action, snapshot.Revision
+			}
+			data, err := json.Marshal(msg)
+			if err != nil {
+				log.Printf("SSE encode failed: %v", err)
+				continue
+			}
+			if err := write("id: %d\nevent: %s\ndata: %s\n\n", revision, event, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := write(": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-sub.done:
+			if sub.evicted {
+				log.Printf("SSE client too slow, disconnecting")
+			}
+			return
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sseEvent is an event read from a /watch stream
+type sseEvent struct {
+	id    string
+	event string
+	data  string
+}
+
+// openWatch connects to /watch and returns a function reading the next
+// event, skipping the retry hint and comments
+func openWatch(t *testing.T, srv *httptest.Server, query, lastID string) func() sseEvent {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/watch?"+query, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if ct := resp.Header.Get("Content-Type"); resp.StatusCode != http.StatusOK || ct != "text/event-stream" {
+		t.Fatalf("/watch answered %d with %q", resp.StatusCode, ct)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		sc := bufio.NewScanner(resp.Body)
+		for sc.Scan() {
+			select {
+			case lines <- sc.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return func() sseEvent {
+		t.Helper()
+		var ev sseEvent
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					t.Fatal("stream ended")
+				}
+				field, value, _ := strings.Cut(line, ": ")
+				switch field {
+				case "id":
+					ev.id = value
+				case "event":
+					ev.event = value
+				case "data":
+					ev.data = value
+				case "":
+					if ev.event != "" {
+						return ev
+					}
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("no event received")
+			}
+		}
+	}
+}
+
+func TestWatchSSE(t *testing.T) {
+	r, _ := testRouter(t)
+	srv := httptest.NewServer(r)
+	// Registered first, so it runs once the streams are closed
+	t.Cleanup(srv.Close)
+	serve(r, http.MethodPost, "/register", testInstance("sse-service", "sse-1", "us-east"))
+	serve(r, http.MethodPost, "/register", testInstance("sse-service", "sse-2", "eu-west"))
+
+	next := openWatch(t, srv, "service=sse-service&region=us-east", "")
+	ev := next()
+	var snapshot Snapshot
+	if err := json.Unmarshal([]byte(ev.data), &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if ev.event != string(ActionSnapshot) || len(snapshot.Services) != 1 || snapshot.Services[0].ID != "sse-1" {
+		t.Fatalf("first event = %+v, want a snapshot of sse-1", ev)
+	}
+	if ev.id != strconv.FormatUint(snapshot.Revision, 10) {
+		t.Errorf("snapshot id = %s, want its revision %d", ev.id, snapshot.Revision)
+	}
+
+	// Only the us-east registration is selected
+	serve(r, http.MethodPost, "/register", testInstance("sse-service", "sse-3", "eu-west"))
+	serve(r, http.MethodPost, "/register", testInstance("sse-service", "sse-4", "us-east"))
+	ev = next()
+	var update ServiceUpdate
+	if err := json.Unmarshal([]byte(ev.data), &update); err != nil {
+		t.Fatal(err)
+	}
+	if ev.event != string(ActionRegister) || update.Service.ID != "sse-4" {
+		t.Fatalf("event = %+v, want the registration of sse-4", ev)
+	}
+	if ev.id != strconv.FormatUint(update.Revision, 10) || update.Revision <= snapshot.Revision {
+		t.Errorf("delta id = %s for revision %d, want the revision, after %d", ev.id, update.Revision, snapshot.Revision)
+	}
+
+	// A reconnecting client only receives what it missed
+	serve(r, http.MethodPost, "/register", testInstance("sse-service", "sse-5", "us-east"))
+	for _, resume := range []struct{ query, lastID string }{
+		{"service=sse-service&region=us-east", ev.id},
+		{"service=sse-service&region=us-east&since=" + ev.id, ""},
+	} {
+		missed := openWatch(t, srv, resume.query, resume.lastID)()
+		if err := json.Unmarshal([]byte(missed.data), &update); err != nil {
+			t.Fatal(err)
+		}
+		if missed.event != string(ActionRegister) || update.Service.ID != "sse-5" {
+			t.Errorf("resumed stream (%+v) started with %+v, want the registration of sse-5", resume, missed)
+		}
+	}
+
+	if w := serve(r, http.MethodGet, "/watch?since=abc", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("/watch with an invalid since answered %d, want 400", w.Code)
+	}
+}
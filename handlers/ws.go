@@ -1,13 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
-	"sync"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
 )
 
 var upgrader = websocket.Upgrader{
@@ -16,66 +25,304 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-var (
-	clients   = make(map[*websocket.Conn]bool)
-	clientsMu sync.RWMutex
+const (
+	// writeWait is the time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// pongWait is the time allowed between pongs from the client
+	pongWait = 60 * time.Second
+	// pingPeriod sends pings often enough to receive a pong within pongWait
+	pingPeriod = pongWait * 9 / 10
+	// maxMessageSize bounds the messages accepted from the client
+	maxMessageSize = 4096
 )
 
+// hub is the broadcast hub shared by every WebSocket client
+var hub = NewHub()
+
+// HandleWebSocket streams registry events to a WebSocket client. The client
+// first receives a snapshot of the instances selected by its filter, then
+// every change as a delta tagged with its revision. A reconnecting client
+// passes the last revision it saw as ?since= to receive only the events it
+// missed, falling back to a new snapshot when they are no longer buffered.
 func HandleWebSocket(c *gin.Context) {
+	since, err := parseRevision(c.Query("since"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter, err := parseFilter(c.Request.URL.Query(), "since")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v", err)
 		return
 	}
-	defer conn.Close()
 
-	// Add client to the list
-	clientsMu.Lock()
-	clients[conn] = true
-	clientsMu.Unlock()
+	sub := hub.subscribe(filter, since)
+	log.Printf("WebSocket client connected. Total clients: %d", hub.Len())
+
+	go writePump(conn, sub)
+	readPump(conn, sub)
+
+	hub.unsubscribe(sub)
+	log.Printf("WebSocket client disconnected. Total clients: %d", hub.Len())
+}
+
+// parseRevision parses an optional revision parameter
+func parseRevision(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	rev, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid revision %q", s)
+	}
+	return rev, nil
+}
+
+// clientMessage is a message sent by a WebSocket client. A subscribe
+// message replaces the connection's filter, with the same semantics as the
+// service, mode, health, maxLoad, includeUnhealthy, includeMaintenance,
+// label, tag, endpoint, filter and metadata parameters of /lookup.
+type clientMessage struct {
+	Type               string             `json:"type"`
+	Service            string             `json:"service"`
+	Mode               string             `json:"mode"`
+	Health             []string           `json:"health"`
+	MaxLoad            map[string]float64 `json:"maxLoad"`
+	IncludeUnhealthy   bool               `json:"includeUnhealthy"`
+	IncludeMaintenance bool               `json:"includeMaintenance"`
+	Labels             map[string]string  `json:"labels"`
+	Tags               []string           `json:"tags"`
+	Endpoint           string             `json:"endpoint"`
+	Filter             string             `json:"filter"`
+	Metadata           map[string]any     `json:"metadata"`
+}
+
+// filter builds the filter of a subscribe message through parseFilter, so
+// it selects the instances /lookup would
+func (msg clientMessage) filter() (repository.Filter, error) {
+	query := url.Values{}
+	for key, value := range msg.Metadata {
+		if reservedParam(key) {
+			return repository.Filter{}, fmt.Errorf("metadata key %q is reserved", key)
+		}
+		switch value.(type) {
+		case string, float64, bool:
+			query.Set(key, fmt.Sprint(value))
+		default:
+			return repository.Filter{}, fmt.Errorf("metadata %s must be a string, number or boolean", key)
+		}
+	}
+	if msg.Service != "" {
+		query.Set("service", msg.Service)
+	}
+	if msg.Mode != "" {
+		query.Set("mode", msg.Mode)
+	}
+	if len(msg.Health) > 0 {
+		query.Set("health", strings.Join(msg.Health, ","))
+	}
+	for name, limit := range msg.MaxLoad {
+		query.Set("maxLoad."+name, strconv.FormatFloat(limit, 'f', -1, 64))
+	}
+	if msg.IncludeUnhealthy {
+		query.Set("includeUnhealthy", "true")
+	}
+	if msg.IncludeMaintenance {
+		query.Set("includeMaintenance", "true")
+	}
+	for key, value := range msg.Labels {
+		query.Set("label."+key, value)
+	}
+	if len(msg.Tags) > 0 {
+		query.Set("tag", strings.Join(msg.Tags, ","))
+	}
+	if msg.Endpoint != "" {
+		query.Set("endpoint", msg.Endpoint)
+	}
+	if msg.Filter != "" {
+		query.Set("filter", msg.Filter)
+	}
+	return parseFilter(query)
+}
 
-	log.Printf("WebSocket client connected. Total clients: %d", len(clients))
+// readPump consumes the client's messages and pongs until the connection
+// fails or stays silent for longer than pongWait
+func readPump(conn *websocket.Conn, sub *subscriber) {
+	conn.SetReadLimit(maxMessageSize)
+	_ = conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		var msg clientMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			var syntaxErr *json.SyntaxError
+			var typeErr *json.UnmarshalTypeError
+			if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+				log.Printf("WebSocket invalid client message: %v", err)
+				continue
+			}
+			return
+		}
+		switch msg.Type {
+		case "subscribe":
+			filter, err := msg.filter()
+			if err != nil {
+				log.Printf("WebSocket invalid subscription: %v", err)
+				continue
+			}
+			hub.resubscribe(sub, filter)
+		default:
+			log.Printf("WebSocket unknown client message type %q", msg.Type)
+		}
+	}
+}
 
-	// Remove client when function returns
+// writePump is the only goroutine writing to the connection. It sends the
+// subscriber's queued events and periodic pings, and closes the connection
+// when a write fails or the subscriber is closed.
+func writePump(conn *websocket.Conn, sub *subscriber) {
+	ticker := time.NewTicker(pingPeriod)
 	defer func() {
-		clientsMu.Lock()
-		delete(clients, conn)
-		clientsMu.Unlock()
-		log.Printf("WebSocket client disconnected. Total clients: %d", len(clients))
+		ticker.Stop()
+		conn.Close()
 	}()
 
-	// Keep connection alive
 	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			break
+		select {
+		case item := <-sub.send:
+			var msg any = item.update
+			if item.snapshot {
+				snapshot, err := takeSnapshot(item)
+				if err != nil {
+					log.Printf("WebSocket snapshot failed: %v", err)
+					return
+				}
+				msg = snapshot
+			}
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteJSON(msg); err != nil {
+				log.Printf("WebSocket send error: %v", err)
+				return
+			}
+		case <-ticker.C:
+			_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		case <-sub.done:
+			closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+			if sub.evicted {
+				log.Printf("WebSocket client too slow, disconnecting")
+				closeMsg = websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "slow consumer")
+			}
+			_ = conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait))
+			return
 		}
 	}
 }
 
+// takeSnapshot resolves a queued snapshot request
+func takeSnapshot(item queued) (Snapshot, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), writeWait)
+	defer cancel()
+	instances, err := hub.Snapshot(ctx, item.filter)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	return Snapshot{Action: ActionSnapshot, Revision: item.revision, Services: instances}, nil
+}
+
 type ServiceUpdateAction string
 
 const (
-	ActionRegister   ServiceUpdateAction = "register"
-	ActionDeregister ServiceUpdateAction = "deregister"
-	ActionHeartbeat  ServiceUpdateAction = "heartbeat"
+	ActionRegister    ServiceUpdateAction = "register"
+	ActionUpdate      ServiceUpdateAction = "update"
+	ActionDeregister  ServiceUpdateAction = "deregister"
+	ActionHeartbeat   ServiceUpdateAction = "heartbeat"
+	ActionHealth      ServiceUpdateAction = "health"      // a heartbeat changed the instance's health
+	ActionExpire      ServiceUpdateAction = "expire"      // the lease ran out, the instance is now CRITICAL
+	ActionMaintenance ServiceUpdateAction = "maintenance" // the instance entered or left maintenance
+	ActionSnapshot    ServiceUpdateAction = "snapshot"
 )
 
+// ServiceUpdate is a registry change. Revision increases with every change.
 type ServiceUpdate struct {
-	Action  ServiceUpdateAction `json:"action"`
-	Service models.Instance     `json:"service"`
+	Action   ServiceUpdateAction `json:"action"`
+	Revision uint64              `json:"revision"`
+	Service  models.Instance     `json:"service"`
+	// PreviousHealth is the health before a health event, when known
+	PreviousHealth string `json:"previousHealth,omitempty"`
+	// Left is set on the copy sent to a subscriber whose filter selected
+	// the instance before the change but no longer does, so it drops it
+	Left bool `json:"left,omitempty"`
+	// Previous is the instance before an update, heartbeat or health
+	// event, when known. The hub matches it against subscribers' filters
+	// along with Service.
+	Previous *models.Instance `json:"-"`
+}
+
+// Snapshot holds every instance selected by a subscriber's filter as of
+// Revision. Changes after Revision follow as ServiceUpdates; applying them
+// is idempotent, so those already reflected in the snapshot do no harm.
+type Snapshot struct {
+	Action   ServiceUpdateAction `json:"action"`
+	Revision uint64              `json:"revision"`
+	Services []models.Instance   `json:"services"`
 }
 
+// BroadcastMessage queues msg for every connected WebSocket client
 func BroadcastMessage(msg ServiceUpdate) {
-	clientsMu.RLock()
-	defer clientsMu.RUnlock()
-
-	for client := range clients {
-		err := client.WriteJSON(msg)
-		if err != nil {
-			log.Printf("WebSocket send error: %v", err)
-			client.Close()
-			delete(clients, client)
-		}
+	hub.Broadcast(msg)
+}
+
+// changeStreamActive is set while the change stream WatchRegistry follows
+// is open
+var changeStreamActive atomic.Bool
+
+// Publish broadcasts a change made by this server. While a change stream
+// feeds the hub it does nothing, since the stream reports the change to
+// every replica, this one included. While the stream is down, e.g. because
+// MongoDB is not a replica set, the server's own changes are still
+// broadcast.
+func Publish(msg ServiceUpdate) {
+	if changeStreamActive.Load() {
+		return
+	}
+	BroadcastMessage(msg)
+}
+
+// PublishReport broadcasts a heartbeat or health check report: a health
+// event when it changed the instance's health, otherwise a heartbeat event
+// when it renewed the lease
+func PublishReport(inst, previous models.Instance, renewed bool) {
+	switch {
+	case previous.Health != inst.Health:
+		Publish(ServiceUpdate{Action: ActionHealth, Service: inst, PreviousHealth: previous.Health, Previous: &previous})
+	case renewed:
+		Publish(ServiceUpdate{Action: ActionHeartbeat, Service: inst, Previous: &previous})
 	}
 }
+
+// WatchRegistry broadcasts every change reported by the backend's change
+// stream until ctx is cancelled. While the stream is open it replaces the
+// server's own events, so all replicas sharing the backend emit the same
+// stream.
+func WatchRegistry(ctx context.Context, w repository.Watcher) error {
+	defer changeStreamActive.Store(false)
+
+	return w.Watch(ctx, func(change repository.Change) {
+		BroadcastMessage(ServiceUpdate{
+			Action:         ServiceUpdateAction(change.Kind),
+			Service:        change.Instance,
+			PreviousHealth: change.PreviousHealth,
+			Previous:       change.Previous,
+		})
+	}, changeStreamActive.Store)
+}
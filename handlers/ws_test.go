@@ -0,0 +1,61 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+func TestSubscribeFilter(t *testing.T) {
+	inst := testInstance("order-service", "order-1", "us-east")
+	inst.Metadata.Version = 2
+	inst.Labels = map[string]string{"team": "payments"}
+	inst.Tags = []string{"canary", "blue"}
+	inst.Endpoints = []models.Endpoint{{Name: "grpc", Port: 9090}}
+
+	tests := []struct {
+		msg  string
+		want bool
+	}{
+		{`{"metadata":{"version":"2"}}`, true},
+		{`{"metadata":{"version":2,"region":"us-east"}}`, true},
+		{`{"metadata":{"version":3}}`, false},
+		{`{"labels":{"team":"payments"},"tags":["canary"],"endpoint":"grpc"}`, true},
+		{`{"labels":{"team":"search"}}`, false},
+		{`{"tags":["canary","green"]}`, false},
+		{`{"endpoint":"http"}`, false},
+		{`{"service":"order-service","filter":"version >= 2"}`, true},
+	}
+	for _, tt := range tests {
+		var msg clientMessage
+		if err := json.Unmarshal([]byte(tt.msg), &msg); err != nil {
+			t.Fatal(err)
+		}
+		filter, err := msg.filter()
+		if err != nil {
+			t.Errorf("filter(%s) error = %v", tt.msg, err)
+			continue
+		}
+		if got := filter.Match(inst); got != tt.want {
+			t.Errorf("filter(%s) matches = %v, want %v", tt.msg, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{
+		`{"metadata":{"limit":"1"}}`,
+		`{"metadata":{"$where":"1"}}`,
+		`{"metadata":{"region":["us-east"]}}`,
+		`{"labels":{"":"x"}}`,
+		`{"maxLoad":{"a..b":1}}`,
+		`{"filter":"version == 2"}`,
+	} {
+		var msg clientMessage
+		if err := json.Unmarshal([]byte(s), &msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := msg.filter(); err == nil {
+			t.Errorf("filter(%s) succeeded, want an error", s)
+		}
+	}
+}
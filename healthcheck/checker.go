@@ -0,0 +1,238 @@
+// Package healthcheck runs the health checks instances declare when they
+// register and reports the results to the registry besides heartbeats
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+const (
+	// scanInterval is how often the registry is scanned for due checks
+	scanInterval = time.Second
+	// maxOutput bounds the combined output of an instance's checks
+	maxOutput = 1024
+)
+
+// ReportFunc is called with every instance whose checks were reported, the
+// instance before the report and whether the report renewed its lease
+type ReportFunc func(inst, previous models.Instance, renewed bool)
+
+type instanceKey struct {
+	serviceName string
+	id          string
+}
+
+// state tracks the checks of one instance between scans
+type state struct {
+	checks  []models.Check
+	next    []time.Time // when each check is due
+	results []result    // latest result of each check
+	running bool        // a worker is running some of the checks
+}
+
+// job asks a worker to run the due checks of an instance
+type job struct {
+	inst  models.Instance
+	state *state
+	due   []int
+}
+
+// Checker runs instances' checks on a bounded pool of workers and records
+// each round through ReportHealth, apart from the health instances report
+// themselves. A passing round renews the lease only of instances that do
+// not send heartbeats, so an instance that is down still expires and is
+// removed, and one that stopped heartbeating is not kept alive by its
+// checks.
+type Checker struct {
+	repo    repository.Registry
+	workers int
+	report  ReportFunc
+	jobs    chan job
+
+	mu     sync.Mutex
+	states map[instanceKey]*state
+}
+
+// New creates a checker running at most workers checks at once
+func New(repo repository.Registry, workers int, report ReportFunc) *Checker {
+	return &Checker{
+		repo:    repo,
+		workers: workers,
+		report:  report,
+		jobs:    make(chan job, workers),
+		states:  make(map[instanceKey]*state),
+	}
+}
+
+// Run schedules checks until ctx is cancelled
+func (c *Checker) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case j := <-c.jobs:
+					c.run(ctx, j)
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(scanInterval)
+	defer ticker.Stop()
+	for {
+		if err := c.scan(ctx, time.Now()); err != nil && ctx.Err() == nil {
+			log.Printf("health check scan failed: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scan queues the instances that have checks due. When every worker is
+// busy and the queue is full, the instance stays due for the next scan.
+// Instances in maintenance keep being checked so their health is current
+// when the window ends.
+func (c *Checker) scan(ctx context.Context, now time.Time) error {
+	instances, err := c.repo.Find(ctx, repository.Filter{
+		HasChecks:          true,
+		IncludeUnhealthy:   true,
+		IncludeMaintenance: true,
+	}, 0)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	seen := make(map[instanceKey]bool, len(instances))
+	for _, inst := range instances {
+		key := instanceKey{serviceName: inst.ServiceName, id: inst.ID}
+		seen[key] = true
+		st := c.states[key]
+		// Registering again with other checks starts over
+		if st == nil || !slices.Equal(st.checks, inst.Checks) {
+			st = &state{
+				checks:  inst.Checks,
+				next:    make([]time.Time, len(inst.Checks)),
+				results: make([]result, len(inst.Checks)),
+			}
+			c.states[key] = st
+		}
+		if st.running {
+			continue
+		}
+		var due []int
+		for i := range st.checks {
+			if !now.Before(st.next[i]) {
+				due = append(due, i)
+			}
+		}
+		if len(due) == 0 {
+			continue
+		}
+		select {
+		case c.jobs <- job{inst: inst, state: st, due: due}:
+			st.running = true
+			for _, i := range due {
+				st.next[i] = now.Add(st.checks[i].Every())
+			}
+		default:
+		}
+	}
+	for key := range c.states {
+		if !seen[key] {
+			delete(c.states, key)
+		}
+	}
+	return nil
+}
+
+// run runs the due checks of an instance and reports the combined result
+func (c *Checker) run(ctx context.Context, j job) {
+	for _, i := range j.due {
+		res := probe(ctx, j.inst, j.state.checks[i])
+		c.mu.Lock()
+		j.state.results[i] = res
+		c.mu.Unlock()
+	}
+
+	c.mu.Lock()
+	hb := combine(j.state.results)
+	j.state.running = false
+	c.mu.Unlock()
+
+	hb.ServiceName = j.inst.ServiceName
+	hb.ID = j.inst.ID
+
+	inst, previous, err := c.repo.ReportHealth(ctx, hb)
+	if errors.Is(err, repository.ErrNotFound) || errors.Is(err, repository.ErrExpired) {
+		return
+	}
+	if err != nil {
+		log.Printf("health check report for %s/%s failed: %v", hb.ServiceName, hb.ID, err)
+		return
+	}
+	if c.report != nil {
+		renewed := hb.Status == models.StatusPassing && !inst.HeartbeatLease()
+		c.report(inst, previous, renewed)
+	}
+}
+
+// combine reports the worst status among the checks that ran, with the
+// output of each
+func combine(results []result) models.Heartbeat {
+	hb := models.Heartbeat{Status: models.StatusPassing}
+	var outputs []string
+	for _, res := range results {
+		if res.status == "" {
+			continue
+		}
+		if severity(res.status) > severity(hb.Status) {
+			hb.Status = res.status
+		}
+		outputs = append(outputs, res.output)
+	}
+	hb.Output = strings.Join(outputs, "; ")
+	hb.Output = truncate(hb.Output, maxOutput)
+	return hb
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func severity(status string) int {
+	switch status {
+	case models.StatusWarning:
+		return 1
+	case models.StatusCritical:
+		return 2
+	}
+	return 0
+}
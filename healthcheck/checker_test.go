@@ -0,0 +1,162 @@
+package healthcheck
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spidey52/service-discovery/models"
+	"github.com/spidey52/service-discovery/repository"
+)
+
+func TestCheckerReportsHTTPHealth(t *testing.T) {
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/health" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	ctx := context.Background()
+	repo := repository.NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, models.Instance{
+		ServiceName: "order-service",
+		ID:          "order-1",
+		Host:        host,
+		Port:        portNum,
+		Mode:        "dev",
+		TTL:         30,
+		Checks:      []models.Check{{Type: models.CheckHTTP, Path: "/health"}},
+	})
+
+	type report struct {
+		health, previous string
+		renewed          bool
+	}
+	reports := make(chan report, 1)
+	c := New(repo, 1, func(inst, previous models.Instance, renewed bool) {
+		reports <- report{inst.Health, previous.Health, renewed}
+	})
+	round := func(now time.Time) report {
+		t.Helper()
+		if err := c.scan(ctx, now); err != nil {
+			t.Fatalf("scan() error = %v", err)
+		}
+		c.run(ctx, <-c.jobs)
+		return <-reports
+	}
+
+	now := time.Now()
+	if got := round(now); got != (report{models.HealthUp, models.HealthUp, true}) {
+		t.Errorf("passing check reported %+v", got)
+	}
+
+	status = http.StatusServiceUnavailable
+	if err := c.scan(ctx, now); err != nil || len(c.jobs) != 0 {
+		t.Fatalf("scan() queued a check before its interval elapsed")
+	}
+	got := round(now.Add(10 * time.Second))
+	if got != (report{models.HealthCritical, models.HealthUp, false}) {
+		t.Errorf("failing check reported %+v", got)
+	}
+	insts, _ := repo.Find(ctx, repository.Filter{IncludeUnhealthy: true}, 0)
+	if len(insts) != 1 || insts[0].CheckOutput == "" {
+		t.Errorf("failing check stored %+v, want its output", insts)
+	}
+}
+
+func TestCheckerKeepsReportedHealth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+
+	ctx := context.Background()
+	repo := repository.NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, models.Instance{
+		ServiceName: "order-service",
+		ID:          "order-1",
+		Host:        host,
+		Port:        portNum,
+		Mode:        "dev",
+		TTL:         30,
+		Checks:      []models.Check{{Type: models.CheckTCP}},
+	})
+	beat, _, _ := repo.UpdateHeartbeat(ctx, models.Heartbeat{
+		ServiceName: "order-service",
+		ID:          "order-1",
+		Status:      models.StatusWarning,
+		Output:      "queue backing up",
+	})
+
+	renewed := make(chan bool, 1)
+	c := New(repo, 1, func(inst, previous models.Instance, r bool) {
+		if previous.Health != inst.Health {
+			t.Errorf("passing check changed health from %s to %s", previous.Health, inst.Health)
+		}
+		renewed <- r
+	})
+	if err := c.scan(ctx, time.Now()); err != nil {
+		t.Fatalf("scan() error = %v", err)
+	}
+	c.run(ctx, <-c.jobs)
+	if <-renewed {
+		t.Errorf("passing check renewed the lease of an instance sending heartbeats")
+	}
+
+	inst, _ := repo.Get(ctx, "order-service", "order-1")
+	if inst.Health != models.HealthWarning || inst.Output != "queue backing up" || inst.CheckHealth != models.HealthUp {
+		t.Errorf("stored health %s %q (checks %s), want the reported WARNING kept", inst.Health, inst.Output, inst.CheckHealth)
+	}
+	if !inst.ExpiresAt.Equal(beat.ExpiresAt) {
+		t.Errorf("expiresAt = %v, want the heartbeat's %v", inst.ExpiresAt, beat.ExpiresAt)
+	}
+}
+
+func TestCheckerScansInstancesInMaintenance(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, models.Instance{
+		ServiceName: "order-service",
+		ID:          "order-1",
+		Host:        "127.0.0.1",
+		Port:        1,
+		Mode:        "dev",
+		TTL:         30,
+		Checks:      []models.Check{{Type: models.CheckTCP}},
+	})
+	m := &models.Maintenance{State: models.StateMaintenance, Since: time.Now()}
+	if _, err := repo.SetMaintenance(ctx, "order-service", "order-1", m); err != nil {
+		t.Fatalf("SetMaintenance() error = %v", err)
+	}
+
+	c := New(repo, 1, func(models.Instance, models.Instance, bool) {})
+	if err := c.scan(ctx, time.Now()); err != nil {
+		t.Fatalf("scan() error = %v", err)
+	}
+	if len(c.jobs) != 1 {
+		t.Errorf("scan() queued %d checks for an instance in maintenance, want 1", len(c.jobs))
+	}
+}
+
+func TestCombineTruncatesOutput(t *testing.T) {
+	output := strings.Repeat("a", maxOutput-1) + "é"
+	hb := combine([]result{{status: models.StatusCritical, output: output}})
+	if !utf8.ValidString(hb.Output) {
+		t.Errorf("combined output is not valid UTF-8")
+	}
+	if hb.Output != output[:maxOutput-1] {
+		t.Errorf("combined output has %d bytes, want %d", len(hb.Output), maxOutput-1)
+	}
+}
@@ -0,0 +1,117 @@
+package healthcheck
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/spidey52/service-discovery/models"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// result is the outcome of a single check run
+type result struct {
+	status string // a models.Status* value, empty before the first run
+	output string
+}
+
+func passing(format string, args ...any) result {
+	return result{status: models.StatusPassing, output: fmt.Sprintf(format, args...)}
+}
+
+func warning(format string, args ...any) result {
+	return result{status: models.StatusWarning, output: fmt.Sprintf(format, args...)}
+}
+
+func critical(format string, args ...any) result {
+	return result{status: models.StatusCritical, output: fmt.Sprintf(format, args...)}
+}
+
+// httpClient runs HTTP checks; the check's context bounds each request
+var httpClient = &http.Client{}
+
+// probe runs check against inst
+func probe(ctx context.Context, inst models.Instance, check models.Check) result {
+	ctx, cancel := context.WithTimeout(ctx, check.Deadline())
+	defer cancel()
+
+	port := check.Port
+	if port == 0 {
+		port = inst.Port
+	}
+	addr := net.JoinHostPort(inst.Host, strconv.Itoa(port))
+
+	switch check.Type {
+	case models.CheckHTTP:
+		return probeHTTP(ctx, addr, check)
+	case models.CheckTCP:
+		return probeTCP(ctx, addr)
+	case models.CheckGRPC:
+		return probeGRPC(ctx, addr, check)
+	}
+	return critical("unknown check type %q", check.Type)
+}
+
+// probeHTTP passes on the expected status, or any 2xx when none is set.
+// 429 Too Many Requests is a warning.
+func probeHTTP(ctx context.Context, addr string, check models.Check) result {
+	path := check.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	url := "http://" + addr + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return critical("HTTP GET %s: %v", url, err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return critical("HTTP GET %s: %v", url, err)
+	}
+	resp.Body.Close()
+
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+	if check.Status != 0 {
+		ok = resp.StatusCode == check.Status
+	}
+	switch {
+	case ok:
+		return passing("HTTP GET %s: %s", url, resp.Status)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return warning("HTTP GET %s: %s", url, resp.Status)
+	}
+	return critical("HTTP GET %s: %s", url, resp.Status)
+}
+
+func probeTCP(ctx context.Context, addr string) result {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return critical("TCP connect %s: %v", addr, err)
+	}
+	conn.Close()
+	return passing("TCP connect %s: success", addr)
+}
+
+// probeGRPC calls the standard grpc.health.v1 service
+func probeGRPC(ctx context.Context, addr string, check models.Check) result {
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return critical("gRPC health %s: %v", addr, err)
+	}
+	defer conn.Close()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: check.Service})
+	if err != nil {
+		return critical("gRPC health %s: %v", addr, err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return critical("gRPC health %s: %s", addr, resp.GetStatus())
+	}
+	return passing("gRPC health %s: %s", addr, resp.GetStatus())
+}
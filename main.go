@@ -4,47 +4,138 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/spidey52/service-discovery/balancer"
+	"github.com/spidey52/service-discovery/dns"
 	"github.com/spidey52/service-discovery/handlers"
+	"github.com/spidey52/service-discovery/healthcheck"
 	"github.com/spidey52/service-discovery/repository"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	mongoURI := "mongodb://localhost:27017/?directConnection=true"
+	backend := getEnv("REGISTRY_BACKEND", "mongo")
+	mongoURI := getEnv("MONGO_URI", "mongodb://localhost:27017/?directConnection=true")
+	registryPath := getEnv("REGISTRY_PATH", "data/registry.log")
+	changeStreams := getEnv("CHANGE_STREAMS", "false") == "true"
 	dbName := "service_registry"
 	collName := "registry"
-	heartbeatTTL := 30 * time.Second
+	lease := handlers.Lease{
+		Default: getEnvDuration("HEARTBEAT_TTL", 30*time.Second),
+		Min:     getEnvDuration("MIN_TTL", 5*time.Second),
+		Max:     getEnvDuration("MAX_TTL", 10*time.Minute),
+	}
+	heartbeatTTL := lease.Default
+	// Instances whose lease ran out are CRITICAL for deregistrationTimeout
+	// before being removed, and are then remembered for tombstoneTTL
+	deregistrationTimeout := getEnvDuration("DEREGISTRATION_TIMEOUT", time.Minute)
+	tombstoneTTL := getEnvDuration("TOMBSTONE_TTL", 5*time.Minute)
+	checkWorkers, err := strconv.Atoi(getEnv("HEALTH_CHECK_WORKERS", "16"))
+	if err != nil || checkWorkers < 1 {
+		log.Fatalf("invalid HEALTH_CHECK_WORKERS %q", os.Getenv("HEALTH_CHECK_WORKERS"))
+	}
+	// Sticky sessions of /resolve are forgotten after this long without use
+	stickySessionTTL := getEnvDuration("STICKY_SESSION_TTL", 30*time.Minute)
+	// DNS interface, unauthenticated and so off unless DNS_ADDR is set,
+	// e.g. to 127.0.0.1:8600
+	dnsAddr := getEnv("DNS_ADDR", "off")
+	dnsDomain := getEnv("DNS_DOMAIN", "discovery")
+	dnsTTL := getEnvDuration("DNS_TTL", 5*time.Second)
+	// gRPC API, unauthenticated and so off unless GRPC_ADDR is set, e.g. to
+	// 127.0.0.1:4001
+	grpcAddr := getEnv("GRPC_ADDR", "off")
 	cleanupInterval := 10 * time.Second
+	compactInterval := 5 * time.Minute
 
-	// Mongo connection
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
-	if err != nil {
-		log.Fatal(err)
+	var repo repository.Registry
+	var client *mongo.Client
+	var fileRepo *repository.FileRepo
+	switch backend {
+	case "mongo":
+		// Mongo connection
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var err error
+		client, err = mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+		if err != nil {
+			log.Fatal(err)
+		}
+		coll := client.Database(dbName).Collection(collName)
+		mongoRepo := repository.NewMongoRepo(coll)
+		if err := mongoRepo.EnsureIndexes(ctx); err != nil {
+			log.Printf("creating indexes failed: %v", err)
+		}
+		repo = mongoRepo
+	case "memory":
+		repo = repository.NewMemoryRepo()
+	case "file":
+		var err error
+		fileRepo, err = repository.OpenFileRepo(registryPath, compactInterval)
+		if err != nil {
+			log.Fatal(err)
+		}
+		repo = fileRepo
+	default:
+		log.Fatalf("unknown REGISTRY_BACKEND %q (expected mongo, memory or file)", backend)
 	}
-	coll := client.Database(dbName).Collection(collName)
-	repo := repository.NewMongoRepo(coll)
 
 	// Gin setup
 	r := gin.Default()
 
-	// WebSocket endpoint for real-time updates
-	r.GET("/ws", handlers.HandleWebSocket)
+	handlers.SetupRoutes(r, repo, lease, balancer.New(stickySessionTTL))
 
-	handlers.SetupRoutes(r, repo, heartbeatTTL)
+	// gRPC API sharing the registry and the event hub with the routes
+	var grpcServer *grpc.Server
+	if grpcAddr != "off" {
+		grpcServer = grpc.NewServer()
+		handlers.RegisterGRPC(grpcServer, repo, lease)
+	}
 
 	// Serve SPA
 	spaHandler := handlers.NewSPAHandler("./ui")
 	r.NoRoute(spaHandler.Handle)
 
+	// Change stream watcher, so replicas sharing the backend emit the same events
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	if changeStreams {
+		watcher, ok := repo.(repository.Watcher)
+		if !ok {
+			log.Fatalf("CHANGE_STREAMS is not supported by the %s backend", backend)
+		}
+		go func() {
+			if err := handlers.WatchRegistry(watchCtx, watcher); err != nil && watchCtx.Err() == nil {
+				log.Printf("change stream watcher stopped: %v", err)
+			}
+		}()
+	}
+
+	// Server-side health checks declared by instances
+	checkCtx, stopChecks := context.WithCancel(context.Background())
+	defer stopChecks()
+	go healthcheck.New(repo, checkWorkers, handlers.PublishReport).Run(checkCtx)
+
+	// DNS interface for clients that only resolve names
+	dnsCtx, stopDNS := context.WithCancel(context.Background())
+	defer stopDNS()
+	if dnsAddr != "off" {
+		go func() {
+			fmt.Printf("DNS interface running on %s for %s.\n", dnsAddr, dnsDomain)
+			if err := dns.New(repo, dnsDomain, dnsTTL, heartbeatTTL).ListenAndServe(dnsCtx, dnsAddr); err != nil {
+				log.Printf("DNS interface stopped: %v", err)
+			}
+		}()
+	}
+
 	// Cleanup goroutine
 	stop := make(chan struct{})
 	go func() {
@@ -55,7 +146,36 @@ func main() {
 			case <-stop:
 				return
 			case <-ticker.C:
-				_ = repo.CleanupDead(context.Background(), heartbeatTTL)
+				marked, err := repo.MarkCritical(context.Background(), heartbeatTTL)
+				if err != nil {
+					log.Printf("expiry failed: %v", err)
+				}
+				for _, inst := range marked {
+					handlers.Publish(handlers.ServiceUpdate{
+						Action:  handlers.ActionExpire,
+						Service: inst,
+					})
+				}
+				ended, err := repo.EndMaintenance(context.Background())
+				if err != nil {
+					log.Printf("maintenance expiry failed: %v", err)
+				}
+				for _, inst := range ended {
+					handlers.Publish(handlers.ServiceUpdate{
+						Action:  handlers.ActionMaintenance,
+						Service: inst,
+					})
+				}
+				removed, err := repo.CleanupDead(context.Background(), heartbeatTTL, deregistrationTimeout, tombstoneTTL)
+				if err != nil {
+					log.Printf("cleanup failed: %v", err)
+				}
+				for _, inst := range removed {
+					handlers.Publish(handlers.ServiceUpdate{
+						Action:  handlers.ActionDeregister,
+						Service: inst,
+					})
+				}
 			}
 		}
 	}()
@@ -68,11 +188,60 @@ func main() {
 		}
 	}()
 
+	if grpcServer != nil {
+		go func() {
+			ln, err := net.Listen("tcp", grpcAddr)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("gRPC API running on %s\n", grpcAddr)
+			if err := grpcServer.Serve(ln); err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	// Graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 	close(stop)
-	_ = client.Disconnect(context.Background())
+	stopWatch()
+	stopChecks()
+	stopDNS()
+	if grpcServer != nil {
+		// Watch streams never end by themselves, so don't wait for them
+		grpcServer.Stop()
+	}
+	if client != nil {
+		_ = client.Disconnect(context.Background())
+	}
+	if fileRepo != nil {
+		if err := fileRepo.Close(); err != nil {
+			log.Printf("registry log close failed: %v", err)
+		}
+	}
 	fmt.Println("Shutdown complete")
 }
+
+// getEnv returns the value of an environment variable or a fallback
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// getEnvDuration returns the duration in an environment variable, e.g. 30s,
+// or a fallback
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("invalid %s %q", key, v)
+	}
+	return d
+}
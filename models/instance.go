@@ -1,22 +1,269 @@
 package models
 
-import "time"
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// Health states of an instance
+const (
+	HealthUp      = "UP"
+	HealthWarning = "WARNING" // degraded, but still returned by lookups
+	// HealthCritical marks an instance reporting a critical status or whose
+	// lease ran out. It is left out of default lookups.
+	HealthCritical = "CRITICAL"
+)
+
+// Statuses an instance reports with its heartbeats
+const (
+	StatusPassing  = "passing"
+	StatusWarning  = "warning"
+	StatusCritical = "critical"
+)
 
 type Metadata struct {
 	Environment  string `json:"environment" bson:"environment" binding:"required,oneof=dev staging prod"`
 	Region       string `json:"region" bson:"region" binding:"required"`
+	Zone         string `json:"zone,omitempty" bson:"zone,omitempty"` // optional availability zone within the region
 	Version      int    `json:"version" bson:"version" binding:"required"`
 	Developer    string `json:"developer" bson:"developer"`       // optional
 	Experimental bool   `json:"experimental" bson:"experimental"` // optional
 }
 
 type Instance struct {
-	ServiceName   string    `json:"serviceName" bson:"serviceName" binding:"required"`
-	ID            string    `json:"id" bson:"id" binding:"required"`
-	Host          string    `json:"host" bson:"host" binding:"required"`
-	Port          int       `json:"port" bson:"port" binding:"required"`
-	Mode          string    `json:"mode" bson:"mode" binding:"required,oneof=dev staging prod"`
-	Metadata      Metadata  `json:"metadata" bson:"metadata" binding:"required"`
-	Health        string    `json:"health" bson:"health"`
-	LastHeartbeat time.Time `json:"lastHeartbeat" bson:"lastHeartbeat"`
+	ServiceName    string             `json:"serviceName" bson:"serviceName" binding:"required"`
+	ID             string             `json:"id" bson:"id" binding:"required"`
+	Host           string             `json:"host" bson:"host" binding:"required"`
+	Port           int                `json:"port" bson:"port" binding:"required"`
+	Mode           string             `json:"mode" bson:"mode" binding:"required,oneof=dev staging prod"`
+	Metadata       Metadata           `json:"metadata" bson:"metadata" binding:"required"`
+	Labels         map[string]string  `json:"labels,omitempty" bson:"labels,omitempty" binding:"max=32,dive,keys,min=1,max=63,excludesall=.$,endkeys,max=255"`
+	Tags           []string           `json:"tags,omitempty" bson:"tags,omitempty" binding:"max=32,dive,min=1,max=63"`
+	Weight         int                `json:"weight,omitempty" bson:"weight,omitempty" binding:"min=0,max=1000"`                // share of weighted load balancing, 0 for 1
+	Endpoints      []Endpoint         `json:"endpoints,omitempty" bson:"endpoints,omitempty" binding:"max=16,unique=Name,dive"` // besides Host and Port
+	TTL            int                `json:"ttl,omitempty" bson:"ttl,omitempty" binding:"min=0"`                               // lease in seconds, 0 for the server default
+	Checks         []Check            `json:"checks,omitempty" bson:"checks,omitempty" binding:"max=4,dive"`
+	Maintenance    *Maintenance       `json:"maintenance,omitempty" bson:"maintenance,omitempty"`       // set by operators, not by registration
+	Health         string             `json:"health" bson:"health"`                                     // worse of ReportedHealth and CheckHealth, CRITICAL once the lease ran out
+	ReportedHealth string             `json:"reportedHealth,omitempty" bson:"reportedHealth,omitempty"` // of the last heartbeat, empty before the first
+	Output         string             `json:"output,omitempty" bson:"output,omitempty"`                 // message of the last heartbeat
+	Load           map[string]float64 `json:"load,omitempty" bson:"load,omitempty"`                     // load indicators of the last heartbeat
+	CheckHealth    string             `json:"checkHealth,omitempty" bson:"checkHealth,omitempty"`       // of the checks' latest round
+	CheckOutput    string             `json:"checkOutput,omitempty" bson:"checkOutput,omitempty"`       // messages of the checks' latest round
+	LastHeartbeat  time.Time          `json:"lastHeartbeat" bson:"lastHeartbeat"`
+	ExpiresAt      time.Time          `json:"expiresAt,omitzero" bson:"expiresAt,omitempty"` // end of the current lease
+}
+
+// States that take an instance out of rotation
+const (
+	StateMaintenance = "maintenance"
+	StateDraining    = "draining" // about to shut down
+)
+
+// Maintenance takes an instance out of default lookups while it is active
+type Maintenance struct {
+	State  string    `json:"state" bson:"state"`
+	Reason string    `json:"reason,omitempty" bson:"reason,omitempty"`
+	Since  time.Time `json:"since" bson:"since"`
+	Until  time.Time `json:"until,omitzero" bson:"until,omitempty"` // when it ends by itself, zero for never
+}
+
+// Active reports whether the maintenance is in effect at now
+func (m *Maintenance) Active(now time.Time) bool {
+	return m != nil && (m.Until.IsZero() || now.Before(m.Until))
+}
+
+// Protocols of an endpoint
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+	ProtocolGRPC  = "grpc"
+	ProtocolTCP   = "tcp"
+)
+
+// Endpoint is a named port an instance serves on its host besides the
+// primary Port, e.g. grpc or metrics
+type Endpoint struct {
+	Name     string `json:"name" bson:"name" binding:"required,max=32,excludesall=.$"`
+	Port     int    `json:"port" bson:"port" binding:"required,min=1,max=65535"`
+	Protocol string `json:"protocol" bson:"protocol" binding:"required,oneof=http https grpc tcp"`
+	Path     string `json:"path,omitempty" bson:"path,omitempty" binding:"omitempty,startswith=/,max=256"` // http and https
+	// Address is where clients reach the endpoint, filled in by lookups
+	Address string `json:"address,omitempty" bson:"-"`
+}
+
+// URL returns the address of the endpoint on host: a URL for http and
+// https, host:port otherwise
+func (e Endpoint) URL(host string) string {
+	hostPort := net.JoinHostPort(host, strconv.Itoa(e.Port))
+	if e.Protocol == ProtocolHTTP || e.Protocol == ProtocolHTTPS {
+		return e.Protocol + "://" + hostPort + e.Path
+	}
+	return hostPort
+}
+
+// WithAddresses fills in the address of each of the instance's endpoints
+func (i Instance) WithAddresses() Instance {
+	if len(i.Endpoints) == 0 {
+		return i
+	}
+	endpoints := make([]Endpoint, len(i.Endpoints))
+	for n, e := range i.Endpoints {
+		e.Address = e.URL(i.Host)
+		endpoints[n] = e
+	}
+	i.Endpoints = endpoints
+	return i
+}
+
+// Types of the health checks the server runs against an instance
+const (
+	CheckHTTP = "http"
+	CheckTCP  = "tcp"
+	CheckGRPC = "grpc"
+)
+
+// Check is a health check the server runs against an instance: an HTTP GET
+// expecting a status, a TCP connect, or a call to the gRPC health service.
+// Until the instance sends its first heartbeat, a passing round of checks
+// renews its lease like one.
+type Check struct {
+	Type     string `json:"type" bson:"type" binding:"required,oneof=http tcp grpc"`
+	Port     int    `json:"port,omitempty" bson:"port,omitempty" binding:"min=0,max=65535"` // 0 for the instance's port
+	Path     string `json:"path,omitempty" bson:"path,omitempty"`                           // http: request path
+	Status   int    `json:"status,omitempty" bson:"status,omitempty"`                       // http: expected status, 0 for any 2xx
+	Service  string `json:"service,omitempty" bson:"service,omitempty"`                     // grpc: service to check, empty for the server
+	Interval int    `json:"interval,omitempty" bson:"interval,omitempty" binding:"min=0"`   // seconds between runs, 0 for 10
+	Timeout  int    `json:"timeout,omitempty" bson:"timeout,omitempty" binding:"min=0"`     // seconds, 0 for 2
+}
+
+// Every returns the interval between runs of the check
+func (c Check) Every() time.Duration {
+	if c.Interval <= 0 {
+		return 10 * time.Second
+	}
+	return time.Duration(c.Interval) * time.Second
+}
+
+// Deadline returns how long a single run of the check may take
+func (c Check) Deadline() time.Duration {
+	if c.Timeout <= 0 {
+		return 2 * time.Second
+	}
+	return time.Duration(c.Timeout) * time.Second
+}
+
+// LeaseTTL returns the instance's lease as a duration
+func (i Instance) LeaseTTL() time.Duration {
+	return time.Duration(i.TTL) * time.Second
+}
+
+// LeaseExpiry returns when the instance's lease runs out. Instances stored
+// without a lease expire defaultTTL after their last heartbeat.
+func (i Instance) LeaseExpiry(defaultTTL time.Duration) time.Time {
+	if !i.ExpiresAt.IsZero() {
+		return i.ExpiresAt
+	}
+	return i.LastHeartbeat.Add(defaultTTL)
+}
+
+// Renew starts a new lease at now
+func (i *Instance) Renew(now time.Time) {
+	i.LastHeartbeat = now
+	i.ExpiresAt = time.Time{}
+	if i.TTL > 0 {
+		i.ExpiresAt = now.Add(i.LeaseTTL())
+	}
+}
+
+// Amend replaces the fields set at registration with those of spec,
+// keeping the instance's health and maintenance. The lease keeps its start,
+// the last heartbeat, and ends after the new TTL.
+func (i *Instance) Amend(spec Instance) {
+	i.Host = spec.Host
+	i.Port = spec.Port
+	i.Mode = spec.Mode
+	i.Metadata = spec.Metadata
+	i.Labels = spec.Labels
+	i.Tags = spec.Tags
+	i.Endpoints = spec.Endpoints
+	i.Weight = spec.Weight
+	i.TTL = spec.TTL
+	i.Checks = spec.Checks
+	i.ExpiresAt = time.Time{}
+	if i.TTL > 0 {
+		i.ExpiresAt = i.LastHeartbeat.Add(i.LeaseTTL())
+	}
+}
+
+// Heartbeat is the health report renewing an instance's lease. An empty
+// status counts as passing.
+type Heartbeat struct {
+	ServiceName string             `json:"serviceName"`
+	ID          string             `json:"id"`
+	Status      string             `json:"status" binding:"omitempty,oneof=passing warning critical"`
+	Output      string             `json:"output" binding:"max=1024"`
+	Load        map[string]float64 `json:"load" binding:"max=8,dive,keys,min=1,max=32,endkeys"`
+}
+
+// Health returns the health state the heartbeat's status stands for
+func (h Heartbeat) Health() string {
+	switch h.Status {
+	case StatusWarning:
+		return HealthWarning
+	case StatusCritical:
+		return HealthCritical
+	}
+	return HealthUp
+}
+
+// ResetHealth forgets the health reported so far, leaving the instance UP
+// as when it registers
+func (i *Instance) ResetHealth() {
+	i.Health = HealthUp
+	i.ReportedHealth = ""
+	i.Output = ""
+	i.Load = nil
+	i.CheckHealth = ""
+	i.CheckOutput = ""
+}
+
+// Report records the health reported by the heartbeat hb
+func (i *Instance) Report(hb Heartbeat) {
+	i.ReportedHealth = hb.Health()
+	i.Output = hb.Output
+	i.Load = hb.Load
+	i.Health = WorseHealth(i.ReportedHealth, i.CheckHealth)
+}
+
+// HeartbeatLease reports whether the instance's lease is renewed by its
+// heartbeats, that is whether it sent one since it registered. Otherwise
+// its checks renew it.
+func (i Instance) HeartbeatLease() bool {
+	return i.ReportedHealth != ""
+}
+
+// ReportChecks records the result of a round of the instance's checks,
+// hb carrying their worst status and their messages. Unless the round
+// renewed the lease, an instance that is CRITICAL because its lease ran out
+// stays so.
+func (i *Instance) ReportChecks(hb Heartbeat, renewed bool) {
+	expired := i.Health == HealthCritical && WorseHealth(i.ReportedHealth, i.CheckHealth) != HealthCritical
+	i.CheckHealth = hb.Health()
+	i.CheckOutput = hb.Output
+	i.Health = WorseHealth(i.ReportedHealth, i.CheckHealth)
+	if expired && !renewed {
+		i.Health = HealthCritical
+	}
+}
+
+// WorseHealth returns the worse of two health states; empty ones count as UP
+func WorseHealth(a, b string) string {
+	for _, health := range []string{HealthCritical, HealthWarning} {
+		if a == health || b == health {
+			return health
+		}
+	}
+	return HealthUp
 }
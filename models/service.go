@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// ServiceSummary aggregates the instances registered under one service name
+type ServiceSummary struct {
+	Name          string    `json:"name"`
+	Instances     int       `json:"instances"`
+	Healthy       int       `json:"healthy"`     // lease running and not CRITICAL
+	Unhealthy     int       `json:"unhealthy"`   // CRITICAL or lease ran out
+	Maintenance   int       `json:"maintenance"` // in maintenance or draining
+	Modes         []string  `json:"modes"`
+	Regions       []string  `json:"regions"`
+	Versions      []int     `json:"versions"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"` // most recent of any instance
+}
+
+// ServiceDetail breaks the instances of a service down by health,
+// maintenance state, mode, region and version
+type ServiceDetail struct {
+	ServiceSummary
+	Health            map[string]int `json:"health"`
+	MaintenanceStates map[string]int `json:"maintenanceStates"`
+	ByMode            map[string]int `json:"byMode"`
+	ByRegion          map[string]int `json:"byRegion"`
+	ByVersion         map[int]int    `json:"byVersion"`
+}
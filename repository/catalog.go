@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"slices"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+// bucket counts the instances of a service that share every field the
+// catalog breaks down. Backends group instances into buckets and catalog
+// folds them into summaries.
+type bucket struct {
+	Key struct {
+		Service     string `bson:"service"`
+		Health      string `bson:"health"`      // CRITICAL once the lease ran out
+		Maintenance string `bson:"maintenance"` // state of active maintenance
+		Mode        string `bson:"mode"`
+		Region      string `bson:"region"`
+		Version     int    `bson:"version"`
+	} `bson:"_id"`
+	Count         int       `bson:"count"`
+	LastHeartbeat time.Time `bson:"lastHeartbeat"`
+}
+
+// bucketOf returns the single-instance bucket of inst at now
+func bucketOf(inst models.Instance, now time.Time, ttl time.Duration) bucket {
+	var b bucket
+	b.Key.Service = inst.ServiceName
+	b.Key.Health = inst.Health
+	if inst.LeaseExpiry(ttl).Before(now) {
+		b.Key.Health = models.HealthCritical
+	}
+	if inst.Maintenance.Active(now) {
+		b.Key.Maintenance = inst.Maintenance.State
+	}
+	b.Key.Mode = inst.Mode
+	b.Key.Region = inst.Metadata.Region
+	b.Key.Version = inst.Metadata.Version
+	b.Count = 1
+	b.LastHeartbeat = inst.LastHeartbeat
+	return b
+}
+
+// catalog folds buckets into the details of each service, ordered by name
+func catalog(buckets []bucket) []models.ServiceDetail {
+	byName := map[string]*models.ServiceDetail{}
+	for _, b := range buckets {
+		d, ok := byName[b.Key.Service]
+		if !ok {
+			d = &models.ServiceDetail{
+				ServiceSummary:    models.ServiceSummary{Name: b.Key.Service, Modes: []string{}, Regions: []string{}, Versions: []int{}},
+				Health:            map[string]int{},
+				MaintenanceStates: map[string]int{},
+				ByMode:            map[string]int{},
+				ByRegion:          map[string]int{},
+				ByVersion:         map[int]int{},
+			}
+			byName[b.Key.Service] = d
+		}
+		health := b.Key.Health
+		if health == "" {
+			// Stored before instances reported their health
+			health = models.HealthUp
+		}
+		d.Instances += b.Count
+		if health == models.HealthCritical {
+			d.Unhealthy += b.Count
+		} else {
+			d.Healthy += b.Count
+		}
+		d.Health[health] += b.Count
+		if b.Key.Maintenance != "" {
+			d.Maintenance += b.Count
+			d.MaintenanceStates[b.Key.Maintenance] += b.Count
+		}
+		d.ByMode[b.Key.Mode] += b.Count
+		d.ByRegion[b.Key.Region] += b.Count
+		d.ByVersion[b.Key.Version] += b.Count
+		if b.LastHeartbeat.After(d.LastHeartbeat) {
+			d.LastHeartbeat = b.LastHeartbeat
+		}
+	}
+
+	details := make([]models.ServiceDetail, 0, len(byName))
+	for _, d := range byName {
+		for mode := range d.ByMode {
+			d.Modes = append(d.Modes, mode)
+		}
+		for region := range d.ByRegion {
+			d.Regions = append(d.Regions, region)
+		}
+		for version := range d.ByVersion {
+			d.Versions = append(d.Versions, version)
+		}
+		slices.Sort(d.Modes)
+		slices.Sort(d.Regions)
+		slices.Sort(d.Versions)
+		details = append(details, *d)
+	}
+	slices.SortFunc(details, func(a, b models.ServiceDetail) int {
+		if a.Name < b.Name {
+			return -1
+		}
+		if a.Name > b.Name {
+			return 1
+		}
+		return 0
+	})
+	return details
+}
+
+// summaries strips details down to their summaries
+func summaries(details []models.ServiceDetail) []models.ServiceSummary {
+	out := make([]models.ServiceSummary, len(details))
+	for i, d := range details {
+		out[i] = d.ServiceSummary
+	}
+	return out
+}
+
+// detail returns the only detail of a catalog built for one service
+func detail(details []models.ServiceDetail) (models.ServiceDetail, error) {
+	if len(details) == 0 {
+		return models.ServiceDetail{}, ErrServiceNotFound
+	}
+	return details[0], nil
+}
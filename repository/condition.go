@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"encoding/json"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Op is the operator of a Condition
+type Op string
+
+const (
+	OpEq     Op = "="
+	OpNe     Op = "!="
+	OpGt     Op = ">"
+	OpGte    Op = ">="
+	OpLt     Op = "<"
+	OpLte    Op = "<="
+	OpIn     Op = "in"
+	OpPrefix Op = "^="
+	OpExists Op = "exists"
+)
+
+// Condition tests a field of the stored instance, following MongoDB query
+// semantics: a condition on an array holds if it holds for any element, and
+// one on a missing field does not hold unless negated.
+//
+// Values are strings, bools or json.Numbers. A json.Number is compared by
+// value with numeric fields, and by its text with string fields, so a
+// version given as 2 matches whether it was registered as 2 or "2".
+type Condition struct {
+	Field  string // dotted path of the stored document, e.g. metadata.version
+	Op     Op
+	Values []any // one, several for OpIn, none for OpExists
+	Not    bool
+	// Fold makes equality compare strings ignoring case, as DNS names are
+	Fold bool
+}
+
+// forms returns the values v matches by equality, ignoring the case of a
+// string when fold is set
+func forms(v any, fold bool) bson.A {
+	if s, ok := v.(string); ok && fold {
+		return bson.A{primitive.Regex{Pattern: "^" + regexp.QuoteMeta(s) + "$", Options: "i"}}
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return bson.A{v}
+	}
+	if i, err := n.Int64(); err == nil {
+		return bson.A{i, n.String()}
+	}
+	f, _ := n.Float64()
+	return bson.A{f, n.String()}
+}
+
+// ordered returns v as used by range comparisons
+func ordered(v any) any {
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+		f, _ := n.Float64()
+		return f
+	}
+	return v
+}
+
+// mongo translates the condition into a MongoDB query document
+func (c Condition) mongo() bson.M {
+	var expr bson.M
+	switch c.Op {
+	case OpEq, OpNe, OpIn:
+		var in bson.A
+		for _, v := range c.Values {
+			in = append(in, forms(v, c.Fold)...)
+		}
+		expr = bson.M{"$in": in}
+	case OpGt:
+		expr = bson.M{"$gt": ordered(c.Values[0])}
+	case OpGte:
+		expr = bson.M{"$gte": ordered(c.Values[0])}
+	case OpLt:
+		expr = bson.M{"$lt": ordered(c.Values[0])}
+	case OpLte:
+		expr = bson.M{"$lte": ordered(c.Values[0])}
+	case OpPrefix:
+		expr = bson.M{"$regex": "^" + regexp.QuoteMeta(text(c.Values[0]))}
+	case OpExists:
+		expr = bson.M{"$exists": true}
+	}
+	if c.Not != (c.Op == OpNe) {
+		expr = bson.M{"$not": expr}
+	}
+	return bson.M{c.Field: expr}
+}
+
+// match evaluates the condition against a stored document
+func (c Condition) match(doc bson.M) bool {
+	got, ok := lookupPath(doc, c.Field)
+	held := false
+	if ok {
+		switch c.Op {
+		case OpExists:
+			held = true
+		case OpEq, OpNe, OpIn:
+			held = anyElem(got, func(g any) bool {
+				for _, v := range c.Values {
+					if literalEqual(g, v, c.Fold) {
+						return true
+					}
+				}
+				return false
+			})
+		case OpGt, OpGte, OpLt, OpLte:
+			held = anyElem(got, func(g any) bool {
+				cmp, ok := compareLiteral(g, c.Values[0])
+				switch c.Op {
+				case OpGt:
+					return ok && cmp > 0
+				case OpGte:
+					return ok && cmp >= 0
+				case OpLt:
+					return ok && cmp < 0
+				}
+				return ok && cmp <= 0
+			})
+		case OpPrefix:
+			held = anyElem(got, func(g any) bool {
+				s, ok := g.(string)
+				return ok && strings.HasPrefix(s, text(c.Values[0]))
+			})
+		}
+	}
+	return held != (c.Not != (c.Op == OpNe))
+}
+
+// anyElem applies fn to v, or to each element when v is an array
+func anyElem(v any, fn func(any) bool) bool {
+	if arr, ok := v.(bson.A); ok {
+		for _, elem := range arr {
+			if fn(elem) {
+				return true
+			}
+		}
+		return false
+	}
+	return fn(v)
+}
+
+// literalEqual reports whether a stored value equals a condition value,
+// ignoring the case of strings when fold is set
+func literalEqual(got, want any, fold bool) bool {
+	if s, ok := want.(string); ok && fold {
+		g, ok := got.(string)
+		return ok && strings.EqualFold(g, s)
+	}
+	if n, ok := want.(json.Number); ok {
+		if s, ok := got.(string); ok {
+			return s == n.String()
+		}
+		want = ordered(n)
+	}
+	return valuesEqual(got, want)
+}
+
+// compareLiteral orders a stored value against a condition value; only
+// numbers and strings are ordered, each among themselves
+func compareLiteral(got, want any) (int, bool) {
+	if g, ok := toFloat(got); ok {
+		w, ok := toFloat(ordered(want))
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case g < w:
+			return -1, true
+		case g > w:
+			return 1, true
+		}
+		return 0, true
+	}
+	g, ok := got.(string)
+	w, ok2 := want.(string)
+	if !ok || !ok2 {
+		return 0, false
+	}
+	return strings.Compare(g, w), true
+}
+
+// text returns the text of a condition value
+func text(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
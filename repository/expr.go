@@ -0,0 +1,308 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Limits of a filter expression
+const (
+	maxExprLen    = 1024
+	maxConditions = 16
+	maxInValues   = 64
+)
+
+// instanceFields are the top-level instance fields an expression can name
+// directly; other plain names are metadata fields
+var instanceFields = map[string]bool{
+	"serviceName": true, "id": true, "host": true, "port": true,
+	"mode": true, "health": true, "ttl": true, "tags": true, "weight": true,
+}
+
+// fieldRoots are the instance fields whose members an expression can name
+// by a dotted path
+var fieldRoots = map[string]bool{"metadata": true, "labels": true, "endpoints": true, "load": true, "maintenance": true}
+
+// comparisons are the operators written between a field and a value
+var comparisons = map[Op]bool{OpEq: true, OpNe: true, OpGt: true, OpGte: true, OpLt: true, OpLte: true, OpPrefix: true}
+
+// ParseExpr parses a filter expression, a list of conditions joined by
+// "and", each of which may be negated with "not":
+//
+//	version >= 2 and region in (us-east, eu-west) and not exists(zone)
+//
+// Conditions compare a field with =, !=, >, >=, <, <=, ^= (prefix) or
+// [not] in followed by a list, parenthesized or not. exists(field) tests
+// for presence. Fields are metadata fields, top-level instance fields such
+// as host, health or tags, or dotted paths below metadata, labels,
+// endpoints, load and maintenance.
+// Values are numbers, true, false, bare words or quoted strings. A blank
+// expression has no conditions.
+func ParseExpr(s string) ([]Condition, error) {
+	if len(s) > maxExprLen {
+		return nil, fmt.Errorf("filter longer than %d characters", maxExprLen)
+	}
+	tokens, err := tokenize(s)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	p := &exprParser{tokens: tokens}
+	var conds []Condition
+	for {
+		cond, err := p.condition()
+		if err != nil {
+			return nil, err
+		}
+		conds = append(conds, cond)
+		if len(conds) > maxConditions {
+			return nil, fmt.Errorf("filter has more than %d conditions", maxConditions)
+		}
+		if p.done() {
+			return conds, nil
+		}
+		if !p.keyword("and") {
+			return nil, fmt.Errorf("expected and, found %s", p.peek())
+		}
+	}
+}
+
+type tokenKind int
+
+const (
+	tokWord   tokenKind = iota // bare word
+	tokString                  // quoted string
+	tokOp                      // comparison operator
+	tokPunct                   // ( ) ,
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func (t token) String() string {
+	return strconv.Quote(t.text)
+}
+
+// wordBreaks end a bare word
+const wordBreaks = `()=!<>^,"'`
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case unicode.IsSpace(rune(ch)):
+			i++
+		case ch == '(' || ch == ')' || ch == ',':
+			tokens = append(tokens, token{tokPunct, string(ch)})
+			i++
+		case ch == '!' && !strings.HasPrefix(s[i:], "!="):
+			// ! negates like not
+			tokens = append(tokens, token{tokWord, "not"})
+			i++
+		case strings.ContainsRune("=!<>^", rune(ch)):
+			op := string(ch)
+			if i+1 < len(s) && s[i+1] == '=' {
+				op += "="
+			}
+			if !comparisons[Op(op)] {
+				return nil, fmt.Errorf("unknown operator %s at %d", op, i)
+			}
+			tokens = append(tokens, token{tokOp, op})
+			i += len(op)
+		case ch == '"' || ch == '\'':
+			end := strings.IndexByte(s[i+1:], ch)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+			tokens = append(tokens, token{tokString, s[i+1 : i+1+end]})
+			i += end + 2
+		default:
+			start := i
+			for i < len(s) && !unicode.IsSpace(rune(s[i])) && !strings.ContainsRune(wordBreaks, rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokWord, s[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *exprParser) peek() string {
+	if p.done() {
+		return "end of filter"
+	}
+	return p.tokens[p.pos].String()
+}
+
+// keyword consumes the bare word w, ignoring case
+func (p *exprParser) keyword(w string) bool {
+	if !p.done() && p.tokens[p.pos].kind == tokWord && strings.EqualFold(p.tokens[p.pos].text, w) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// punct consumes the punctuation ch
+func (p *exprParser) punct(ch string) bool {
+	if !p.done() && p.tokens[p.pos].kind == tokPunct && p.tokens[p.pos].text == ch {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) condition() (Condition, error) {
+	if p.keyword("not") {
+		cond, err := p.condition()
+		cond.Not = !cond.Not
+		return cond, err
+	}
+	if p.keyword("exists") {
+		if !p.punct("(") {
+			return Condition{}, fmt.Errorf("expected ( after exists, found %s", p.peek())
+		}
+		field, err := p.field()
+		if err != nil {
+			return Condition{}, err
+		}
+		if !p.punct(")") {
+			return Condition{}, fmt.Errorf("expected ), found %s", p.peek())
+		}
+		return Condition{Field: field, Op: OpExists}, nil
+	}
+
+	field, err := p.field()
+	if err != nil {
+		return Condition{}, err
+	}
+	cond := Condition{Field: field}
+	if p.keyword("not") {
+		if !p.keyword("in") {
+			return Condition{}, fmt.Errorf("expected in after not, found %s", p.peek())
+		}
+		cond.Not = true
+		cond.Op = OpIn
+	} else if p.keyword("in") {
+		cond.Op = OpIn
+	} else if !p.done() && p.tokens[p.pos].kind == tokOp {
+		cond.Op = Op(p.tokens[p.pos].text)
+		p.pos++
+	} else {
+		return Condition{}, fmt.Errorf("expected operator after %s, found %s", field, p.peek())
+	}
+
+	if cond.Op != OpIn {
+		v, err := p.value()
+		if err != nil {
+			return Condition{}, err
+		}
+		if cond.Op == OpPrefix {
+			v = text(v)
+		}
+		if _, ok := v.(bool); ok && cond.Op != OpEq && cond.Op != OpNe {
+			return Condition{}, fmt.Errorf("%s cannot compare booleans", cond.Op)
+		}
+		cond.Values = []any{v}
+		return cond, nil
+	}
+
+	paren := p.punct("(")
+	for {
+		v, err := p.value()
+		if err != nil {
+			return Condition{}, err
+		}
+		cond.Values = append(cond.Values, v)
+		if len(cond.Values) > maxInValues {
+			return Condition{}, fmt.Errorf("in list longer than %d values", maxInValues)
+		}
+		if !p.punct(",") {
+			break
+		}
+	}
+	if paren && !p.punct(")") {
+		return Condition{}, fmt.Errorf("expected ), found %s", p.peek())
+	}
+	return cond, nil
+}
+
+// field parses a field name and resolves it to its stored path
+func (p *exprParser) field() (string, error) {
+	if p.done() || p.tokens[p.pos].kind != tokWord {
+		return "", fmt.Errorf("expected field, found %s", p.peek())
+	}
+	name := p.tokens[p.pos].text
+	p.pos++
+	return FieldPath(name)
+}
+
+// FieldPath resolves a field name of a filter to its stored path: top-level
+// instance fields stay as they are, dotted paths must start below
+// metadata, labels, endpoints, load or maintenance, and other names are
+// metadata fields
+func FieldPath(name string) (string, error) {
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.' {
+			return "", fmt.Errorf("invalid field %q", name)
+		}
+	}
+	root, rest, dotted := strings.Cut(name, ".")
+	switch {
+	case name == "":
+		return "", fmt.Errorf("invalid field %q", name)
+	case dotted:
+		if !fieldRoots[root] || rest == "" || strings.Contains(rest, "..") || strings.HasSuffix(rest, ".") {
+			return "", fmt.Errorf("invalid field %q", name)
+		}
+		return name, nil
+	case instanceFields[name]:
+		return name, nil
+	}
+	return "metadata." + name, nil
+}
+
+// value parses a literal
+func (p *exprParser) value() (any, error) {
+	if p.done() || (p.tokens[p.pos].kind != tokWord && p.tokens[p.pos].kind != tokString) {
+		return nil, fmt.Errorf("expected value, found %s", p.peek())
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	if tok.kind == tokString {
+		return tok.text, nil
+	}
+	return Literal(tok.text), nil
+}
+
+var number = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// Literal types an unquoted value: true and false are bools, numbers are
+// json.Numbers and anything else a string
+func Literal(s string) any {
+	switch s {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if number.MatchString(s) {
+		return json.Number(s)
+	}
+	return s
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/spidey52/service-discovery/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []Condition
+	}{
+		{"version>=2", []Condition{{Field: "metadata.version", Op: OpGte, Values: []any{json.Number("2")}}}},
+		{"region in us-east,eu-west and not exists(developer)", []Condition{
+			{Field: "metadata.region", Op: OpIn, Values: []any{"us-east", "eu-west"}},
+			{Field: "metadata.developer", Op: OpExists, Not: true},
+		}},
+		{`mode not in (dev, "staging") and host ^= 10.0.`, []Condition{
+			{Field: "mode", Op: OpIn, Values: []any{"dev", "staging"}, Not: true},
+			{Field: "host", Op: OpPrefix, Values: []any{"10.0."}},
+		}},
+		{"!experimental = true AND load.cpu < 0.5", []Condition{
+			{Field: "metadata.experimental", Op: OpEq, Values: []any{true}, Not: true},
+			{Field: "load.cpu", Op: OpLt, Values: []any{json.Number("0.5")}},
+		}},
+		{"  ", nil},
+	}
+	for _, tt := range tests {
+		got, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Errorf("ParseExpr(%q) error = %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseExpr(%q) = %+v, want %+v", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{
+		"version", "version >", "version >= 2 or region = x", "region in (a, b",
+		"exists developer", "experimental > true", "secret.key = 1", `region = "open`, "version ^ 2",
+		"version == 2", "zone => a",
+	} {
+		if _, err := ParseExpr(expr); err == nil {
+			t.Errorf("ParseExpr(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestConditionMatch(t *testing.T) {
+	inst := testInstance("order-service", "order-1", "us-east", 2)
+	inst.Load = map[string]float64{"cpu": 0.4}
+	inst.Labels = map[string]string{"team": "payments", "tier": "1"}
+	inst.Tags = []string{"canary", "arm64"}
+	inst.Endpoints = []models.Endpoint{{Name: "grpc", Port: 9090, Protocol: models.ProtocolGRPC}}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"version = 2", true},
+		{"version >= 3", false},
+		{"version in 1,2", true},
+		{"region != us-east", false},
+		{"not region in (eu-west)", true},
+		{"region ^= us-", true},
+		{"region > eu", true},
+		{"version > abc", false},
+		{"exists(developer)", true},
+		{"exists(team)", false},
+		{"not exists(team)", true},
+		{"not exists(zone)", true},
+		{`developer = ""`, true},
+		{"team != x", true},
+		{"load.cpu <= 0.4", true},
+		{"port = 8080 and mode = dev", true},
+		{"labels.team = payments and labels.tier = 1", true},
+		{"labels.team ^= search", false},
+		{"tags = arm64", true},
+		{"tags not in (canary)", false},
+		{"endpoints.name = grpc and endpoints.port = 9090", true},
+		{"endpoints.protocol = http", false},
+	}
+	for _, tt := range tests {
+		conds, err := ParseExpr(tt.expr)
+		if err != nil {
+			t.Fatalf("ParseExpr(%q) error = %v", tt.expr, err)
+		}
+		if got := (Filter{Conditions: conds}).Match(inst); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+
+	// A number matches its text when the field was registered as a string
+	stringly := document(models.Instance{Metadata: models.Metadata{Region: "2"}})
+	if c := (Condition{Field: "metadata.region", Op: OpEq, Values: []any{json.Number("2")}}); !c.match(stringly) {
+		t.Errorf("match(region = 2) = false for a string region")
+	}
+
+	// A folded condition ignores the case of strings
+	mixed := document(models.Instance{Metadata: models.Metadata{Region: "EU-West"}})
+	if c := (Condition{Field: "metadata.region", Op: OpEq, Values: []any{"eu-west"}, Fold: true}); !c.match(mixed) {
+		t.Errorf("match(region = eu-west) = false for EU-West with Fold")
+	}
+	if c := (Condition{Field: "metadata.region", Op: OpEq, Values: []any{"eu-west"}}); c.match(mixed) {
+		t.Errorf("match(region = eu-west) = true for EU-West without Fold")
+	}
+}
+
+func TestConditionMongo(t *testing.T) {
+	tests := []struct {
+		cond Condition
+		want bson.M
+	}{
+		{Condition{Field: "metadata.version", Op: OpEq, Values: []any{json.Number("2")}},
+			bson.M{"metadata.version": bson.M{"$in": bson.A{int64(2), "2"}}}},
+		{Condition{Field: "metadata.version", Op: OpNe, Values: []any{"x"}},
+			bson.M{"metadata.version": bson.M{"$not": bson.M{"$in": bson.A{"x"}}}}},
+		{Condition{Field: "load.cpu", Op: OpLt, Values: []any{json.Number("0.5")}},
+			bson.M{"load.cpu": bson.M{"$lt": 0.5}}},
+		{Condition{Field: "host", Op: OpPrefix, Values: []any{"10.0."}, Not: true},
+			bson.M{"host": bson.M{"$not": bson.M{"$regex": `^10\.0\.`}}}},
+		{Condition{Field: "metadata.developer", Op: OpExists},
+			bson.M{"metadata.developer": bson.M{"$exists": true}}},
+		{Condition{Field: "metadata.region", Op: OpEq, Values: []any{"eu-west.1"}, Fold: true},
+			bson.M{"metadata.region": bson.M{"$in": bson.A{primitive.Regex{Pattern: `^eu-west\.1$`, Options: "i"}}}}},
+	}
+	for _, tt := range tests {
+		if got := tt.cond.mongo(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mongo(%+v) = %v, want %v", tt.cond, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package repository
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// syncInterval is how often appended records are synced to disk
+const syncInterval = time.Second
+
+// logRecord is one line of the append-only registry log
+type logRecord struct {
+	Op          string           `json:"op"`
+	Instance    *models.Instance `json:"instance,omitempty"`
+	ServiceName string           `json:"serviceName,omitempty"`
+	ID          string           `json:"id,omitempty"`
+	Tombstone   *time.Time       `json:"tombstone,omitempty"` // end of the tombstone a delete leaves
+}
+
+// FileRepo is a Registry that keeps instances in memory and persists every
+// change to an append-only log file, so a restarted server recovers all
+// registrations, heartbeat timestamps and tombstones. The log is
+// periodically compacted into a snapshot of the live instances and
+// tombstones.
+//
+// Every change is appended to the log before it is applied, so a crash of
+// the process loses nothing. Appends are synced to disk every syncInterval
+// rather than one by one, keeping heartbeats off the disk's latency; a
+// crash of the machine loses at most the changes of the last interval.
+//
+// A change whose append fails is not applied and the partial record is cut
+// off the log. When that fails too, changes are refused until the next
+// compaction rewrites the log.
+type FileRepo struct {
+	*MemoryRepo
+
+	fileMu  sync.Mutex
+	path    string
+	file    *os.File
+	size    int64 // of the log up to the last complete record
+	records int   // records appended since the last compaction
+	dirty   bool  // records were appended since the last sync
+	broken  error // a failed write that could not be undone, set until the next compaction
+
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenFileRepo opens (or creates) the registry log at path, replays it and
+// starts compacting it every compactInterval
+func OpenFileRepo(path string, compactInterval time.Duration) (*FileRepo, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &FileRepo{
+		MemoryRepo: NewMemoryRepo(),
+		path:       path,
+		file:       file,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if err := r.replay(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("replay %s: %w", path, err)
+	}
+	r.MemoryRepo.journal = r
+
+	go r.compactLoop(compactInterval)
+	return r, nil
+}
+
+// replay loads the log into memory. A torn record at the end of the file,
+// left behind by a crash in the middle of a write, is truncated away; a
+// record that cannot be read anywhere else fails the replay rather than
+// dropping the records after it.
+func (r *FileRepo) replay() error {
+	now := time.Now()
+	reader := bufio.NewReader(r.file)
+	var offset int64
+	for n := 1; ; n++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Any bytes read belong to a record missing its newline
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var rec logRecord
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			if _, err := reader.Peek(1); err == io.EOF {
+				break
+			}
+			return fmt.Errorf("corrupt record on line %d: %w", n, err)
+		}
+		switch rec.Op {
+		case opPut:
+			if rec.Instance != nil {
+				key := keyOf(*rec.Instance)
+				r.MemoryRepo.instances[key] = *rec.Instance
+				delete(r.MemoryRepo.tombstones, key)
+			}
+		case opDelete:
+			key := instanceKey{serviceName: rec.ServiceName, id: rec.ID}
+			delete(r.MemoryRepo.instances, key)
+			if rec.Tombstone != nil && now.Before(*rec.Tombstone) {
+				r.MemoryRepo.tombstones[key] = *rec.Tombstone
+			}
+		}
+		offset += int64(len(line))
+		r.records++
+	}
+
+	r.size = offset
+	return r.rewind()
+}
+
+// rewind cuts the log back to its last complete record, dropping what a
+// failed write left behind
+func (r *FileRepo) rewind() error {
+	if err := r.file.Truncate(r.size); err != nil {
+		return err
+	}
+	_, err := r.file.Seek(r.size, io.SeekStart)
+	return err
+}
+
+func (r *FileRepo) logPut(inst models.Instance) error {
+	return r.append(logRecord{Op: opPut, Instance: &inst})
+}
+
+func (r *FileRepo) logRemove(key instanceKey, tombstone time.Time) error {
+	return r.append(deleteRecord(key, tombstone))
+}
+
+// deleteRecord is the record of a removal leaving a tombstone until
+// tombstone, none when it is zero
+func deleteRecord(key instanceKey, tombstone time.Time) logRecord {
+	rec := logRecord{Op: opDelete, ServiceName: key.serviceName, ID: key.id}
+	if !tombstone.IsZero() {
+		rec.Tombstone = &tombstone
+	}
+	return rec
+}
+
+func (r *FileRepo) append(rec logRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	r.fileMu.Lock()
+	defer r.fileMu.Unlock()
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	if r.broken != nil {
+		return fmt.Errorf("registry log unwritable: %w", r.broken)
+	}
+	if _, err := r.file.Write(line); err != nil {
+		// A partial record would fail the next replay, so it is cut off,
+		// or when that fails too no record is appended after it
+		if rerr := r.rewind(); rerr != nil {
+			r.broken = err
+		}
+		return err
+	}
+	r.size += int64(len(line))
+	r.records++
+	r.dirty = true
+	return nil
+}
+
+func (r *FileRepo) compactLoop(interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	syncTicker := time.NewTicker(syncInterval)
+	defer syncTicker.Stop()
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			_ = r.Compact()
+		case <-syncTicker.C:
+			if err := r.sync(); err != nil && !errors.Is(err, os.ErrClosed) {
+				log.Printf("registry log sync failed: %v", err)
+			}
+		}
+	}
+}
+
+// sync syncs the records appended since the last sync to disk
+func (r *FileRepo) sync() error {
+	r.fileMu.Lock()
+	defer r.fileMu.Unlock()
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	if !r.dirty {
+		return nil
+	}
+	if err := r.file.Sync(); err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
+
+// Compact rewrites the log as a snapshot of the live instances and
+// tombstones. It is a no-op when the log holds nothing else already.
+func (r *FileRepo) Compact() error {
+	// Holding the read lock keeps writers, and therefore journal appends,
+	// out while the snapshot is written and swapped in
+	r.MemoryRepo.mu.RLock()
+	defer r.MemoryRepo.mu.RUnlock()
+	r.fileMu.Lock()
+	defer r.fileMu.Unlock()
+
+	if r.file == nil {
+		return os.ErrClosed
+	}
+	live := len(r.MemoryRepo.instances) + len(r.MemoryRepo.tombstones)
+	if r.records <= live && r.broken == nil {
+		if err := r.file.Sync(); err != nil {
+			return err
+		}
+		r.dirty = false
+		return nil
+	}
+
+	// The snapshot is kept open to append to once it replaced the log, so
+	// no reopen can fail after the swap
+	tmpPath := r.path + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, inst := range r.MemoryRepo.instances {
+		if err := enc.Encode(logRecord{Op: opPut, Instance: &inst}); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	for key, until := range r.MemoryRepo.tombstones {
+		if err := enc.Encode(deleteRecord(key, until)); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	info, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmpPath, r.path); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	r.file.Close()
+	r.file = tmp
+	r.size = info.Size()
+	r.records = live
+	r.dirty = false
+	r.broken = nil
+	return nil
+}
+
+// Close compacts the log one last time and closes the file. Closing it
+// again returns os.ErrClosed.
+func (r *FileRepo) Close() error {
+	err := os.ErrClosed
+	r.closeOnce.Do(func() { err = r.close() })
+	return err
+}
+
+func (r *FileRepo) close() error {
+	close(r.stop)
+	<-r.done
+	err := r.Compact()
+
+	r.fileMu.Lock()
+	defer r.fileMu.Unlock()
+	if cerr := r.file.Close(); err == nil {
+		err = cerr
+	}
+	r.file = nil
+	return err
+}
@@ -0,0 +1,196 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+func TestFileRepoRecoversAfterRestart(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "registry.log")
+
+	repo, err := OpenFileRepo(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileRepo() error = %v", err)
+	}
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "us-east", 1))
+	_, _, _ = repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-1"})
+	before, _ := repo.Find(ctx, Filter{IncludeUnhealthy: true}, 0)
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// Simulate a crash that left half a record behind
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	_, _ = f.WriteString(`{"op":"put","instance":{"serviceName":`)
+	f.Close()
+
+	reopened, err := OpenFileRepo(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileRepo() after restart error = %v", err)
+	}
+	defer reopened.Close()
+
+	after, _ := reopened.Find(ctx, Filter{IncludeUnhealthy: true}, 0)
+	if len(after) != len(before) {
+		t.Fatalf("recovered %d instances, want %d", len(after), len(before))
+	}
+	for i := range before {
+		if !after[i].LastHeartbeat.Equal(before[i].LastHeartbeat) {
+			t.Errorf("instance %s heartbeat = %v, want %v", after[i].ID, after[i].LastHeartbeat, before[i].LastHeartbeat)
+		}
+	}
+}
+
+func TestFileRepoCompact(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "registry.log")
+
+	repo, err := OpenFileRepo(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileRepo() error = %v", err)
+	}
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+	for i := 0; i < 10; i++ {
+		_, _, _ = repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-1"})
+	}
+	if err := repo.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "us-east", 1))
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened, err := OpenFileRepo(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileRepo() error = %v", err)
+	}
+	defer reopened.Close()
+	if reopened.records != 2 {
+		t.Errorf("compacted log holds %d records, want 2", reopened.records)
+	}
+	if got, _ := reopened.Find(ctx, Filter{IncludeUnhealthy: true}, 0); len(got) != 2 {
+		t.Errorf("recovered %d instances, want 2", len(got))
+	}
+}
+
+func TestFileRepoRejectsCorruptLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "registry.log")
+	log := `{"op":"put","instance":{"serviceName":"order-service","id":"order-1"}}
+{"op":"put","inst
+{"op":"put","instance":{"serviceName":"order-service","id":"order-2"}}
+`
+	if err := os.WriteFile(path, []byte(log), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if repo, err := OpenFileRepo(path, time.Hour); err == nil {
+		repo.Close()
+		t.Fatal("OpenFileRepo() succeeded on a log corrupt before its end")
+	}
+	if data, _ := os.ReadFile(path); string(data) != log {
+		t.Errorf("log was changed to %q", data)
+	}
+}
+
+func TestFileRepoKeepsTombstones(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "registry.log")
+
+	repo, err := OpenFileRepo(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileRepo() error = %v", err)
+	}
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "us-east", 1))
+	time.Sleep(5 * time.Millisecond)
+	if removed, _ := repo.CleanupDead(ctx, time.Millisecond, 0, time.Minute); len(removed) != 2 {
+		t.Fatalf("CleanupDead() removed %v, want both instances", removed)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := repo.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("second Close() error = %v, want os.ErrClosed", err)
+	}
+
+	// The log is compacted on close and once more after registering again
+	for i := 0; i < 2; i++ {
+		reopened, err := OpenFileRepo(path, time.Hour)
+		if err != nil {
+			t.Fatalf("OpenFileRepo() error = %v", err)
+		}
+		if _, _, err := reopened.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-1"}); !errors.Is(err, ErrExpired) {
+			t.Errorf("UpdateHeartbeat() after restart %d error = %v, want ErrExpired", i+1, err)
+		}
+		if i == 0 {
+			_, _, _ = reopened.Register(ctx, testInstance("order-service", "order-2", "us-east", 1))
+		}
+		if _, _, err := reopened.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-2"}); err != nil {
+			t.Errorf("UpdateHeartbeat() of the registered instance after restart %d error = %v", i+1, err)
+		}
+		if err := reopened.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+}
+
+func TestFileRepoFailedWrite(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "registry.log")
+
+	repo, err := OpenFileRepo(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileRepo() error = %v", err)
+	}
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+
+	// A read-only handle fails the write and the truncation after it
+	readOnly, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo.fileMu.Lock()
+	writable := repo.file
+	repo.file = readOnly
+	repo.fileMu.Unlock()
+	if _, _, err := repo.Register(ctx, testInstance("order-service", "order-2", "us-east", 1)); err == nil {
+		t.Fatal("Register() succeeded with an unwritable log")
+	}
+	repo.fileMu.Lock()
+	repo.file = writable
+	repo.fileMu.Unlock()
+	readOnly.Close()
+	if _, _, err := repo.Register(ctx, testInstance("order-service", "order-3", "us-east", 1)); err == nil {
+		t.Error("Register() succeeded after a write that could not be undone")
+	}
+
+	// Compacting rewrites the log from memory and accepts writes again
+	if err := repo.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if _, _, err := repo.Register(ctx, testInstance("order-service", "order-4", "us-east", 1)); err != nil {
+		t.Fatalf("Register() after compaction error = %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	reopened, err := OpenFileRepo(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenFileRepo() after restart error = %v", err)
+	}
+	defer reopened.Close()
+	got, _ := reopened.Find(ctx, Filter{IncludeUnhealthy: true}, 0)
+	if len(got) != 2 || got[0].ID != "order-1" || got[1].ID != "order-4" {
+		t.Errorf("recovered %v, want order-1 and order-4", got)
+	}
+}
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/spidey52/service-discovery/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Filter selects instances by service name, mode, metadata fields, health,
+// load and conditions. Empty fields match everything, metadata keys are
+// compared by equality and all conditions must hold.
+type Filter struct {
+	ServiceName string
+	Mode        string
+	Metadata    map[string]interface{}
+	Health      []string           // health states to select
+	MaxLoad     map[string]float64 // upper bounds of reported load indicators
+	HasChecks   bool               // only instances declaring health checks
+	Conditions  []Condition        // see ParseExpr
+	// FoldServiceName compares ServiceName ignoring case, as DNS names are
+	FoldServiceName bool
+	// IncludeUnhealthy makes queries return CRITICAL instances and those
+	// whose lease ran out as well, IncludeMaintenance those in maintenance.
+	// Match ignores both: events about such instances, like their expiry,
+	// concern every subscriber.
+	IncludeUnhealthy   bool
+	IncludeMaintenance bool
+	// Page orders and windows the result of Find; Match ignores it
+	Page Page
+}
+
+// Match reports whether inst is selected by the filter, using the same
+// semantics as the query MongoRepo.Find sends to MongoDB
+func (f Filter) Match(inst models.Instance) bool {
+	if f.ServiceName != "" && inst.ServiceName != f.ServiceName &&
+		!(f.FoldServiceName && strings.EqualFold(inst.ServiceName, f.ServiceName)) {
+		return false
+	}
+	if f.Mode != "" && inst.Mode != f.Mode {
+		return false
+	}
+	if len(f.Health) > 0 && !slices.Contains(f.Health, inst.Health) {
+		return false
+	}
+	if f.HasChecks && len(inst.Checks) == 0 {
+		return false
+	}
+	for name, limit := range f.MaxLoad {
+		if load, ok := inst.Load[name]; !ok || load > limit {
+			return false
+		}
+	}
+	if len(f.Metadata) == 0 && len(f.Conditions) == 0 {
+		return true
+	}
+	doc := document(inst)
+	for k, want := range f.Metadata {
+		got, ok := lookupPath(doc, "metadata."+k)
+		if !ok || !valuesEqual(got, want) {
+			return false
+		}
+	}
+	for _, cond := range f.Conditions {
+		if !cond.match(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+// document renders an instance the way it is stored in MongoDB so that
+// in-memory matching sees the same field names and value types
+func document(inst models.Instance) bson.M {
+	raw, err := bson.Marshal(inst)
+	if err != nil {
+		return bson.M{}
+	}
+	var doc bson.M
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return bson.M{}
+	}
+	return doc
+}
+
+// lookupPath resolves a dotted field path inside a document. Like MongoDB
+// it descends into arrays, resolving the rest of the path in each element.
+func lookupPath(doc bson.M, path string) (interface{}, bool) {
+	return lookupParts(doc, strings.Split(path, "."))
+}
+
+func lookupParts(cur interface{}, parts []string) (interface{}, bool) {
+	if len(parts) == 0 {
+		return cur, true
+	}
+	switch v := cur.(type) {
+	case bson.M:
+		next, ok := v[parts[0]]
+		if !ok {
+			return nil, false
+		}
+		return lookupParts(next, parts[1:])
+	case bson.A:
+		var found bson.A
+		for _, elem := range v {
+			if got, ok := lookupParts(elem, parts); ok {
+				found = append(found, got)
+			}
+		}
+		return found, len(found) > 0
+	}
+	return nil, false
+}
+
+// valuesEqual compares values like MongoDB equality does: numbers are
+// compared by value regardless of their type, arrays match any element
+func valuesEqual(got, want interface{}) bool {
+	if arr, ok := got.(bson.A); ok {
+		for _, elem := range arr {
+			if valuesEqual(elem, want) {
+				return true
+			}
+		}
+		return false
+	}
+	if g, ok := toFloat(got); ok {
+		w, ok := toFloat(want)
+		return ok && g == w
+	}
+	return reflect.DeepEqual(got, want)
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
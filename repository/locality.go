@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+// Tier is how close to the caller the instances a locality selects are
+type Tier string
+
+const (
+	TierZone   Tier = "zone"
+	TierRegion Tier = "region"
+	TierAny    Tier = "any"
+)
+
+// Locality prefers instances in the caller's zone, then in its region, then
+// anywhere. A tier is used when it holds at least MinHealthy healthy
+// instances and at least MinPercent percent of all healthy candidates;
+// otherwise lookups fail over to the next one.
+type Locality struct {
+	Region     string
+	Zone       string
+	MinHealthy int // 0 for 1
+	MinPercent int // 0 to 100
+}
+
+// Enabled reports whether l expresses a preference
+func (l Locality) Enabled() bool {
+	return l.Region != "" || l.Zone != ""
+}
+
+// Tier returns the closest tier of candidates that meets the thresholds at
+// now. Instances are healthy unless CRITICAL, past their lease or in
+// maintenance.
+func (l Locality) Tier(candidates []models.Instance, now time.Time, ttl time.Duration) Tier {
+	tiers := l.tiers()
+	healthy := 0
+	counts := map[Tier]int{}
+	for _, inst := range candidates {
+		if inst.Health == models.HealthCritical || inst.LeaseExpiry(ttl).Before(now) || inst.Maintenance.Active(now) {
+			continue
+		}
+		healthy++
+		for _, tier := range tiers {
+			if (Filter{Conditions: l.Conditions(tier)}).Match(inst) {
+				counts[tier]++
+			}
+		}
+	}
+
+	for _, tier := range tiers {
+		n := counts[tier]
+		if n >= max(l.MinHealthy, 1) && n*100 >= l.MinPercent*healthy {
+			return tier
+		}
+	}
+	return TierAny
+}
+
+// tiers returns the tiers l prefers, closest first
+func (l Locality) tiers() []Tier {
+	var tiers []Tier
+	if l.Zone != "" {
+		tiers = append(tiers, TierZone)
+	}
+	if l.Region != "" {
+		tiers = append(tiers, TierRegion)
+	}
+	return tiers
+}
+
+// Conditions returns the conditions selecting the instances of tier, nil
+// for TierAny and for tiers l has no preference for
+func (l Locality) Conditions(tier Tier) []Condition {
+	var conds []Condition
+	switch {
+	case tier == TierZone && l.Zone != "":
+		conds = append(conds, Condition{Field: "metadata.zone", Op: OpEq, Values: []any{l.Zone}})
+		if l.Region != "" {
+			conds = append(conds, Condition{Field: "metadata.region", Op: OpEq, Values: []any{l.Region}})
+		}
+	case tier == TierRegion && l.Region != "":
+		conds = append(conds, Condition{Field: "metadata.region", Op: OpEq, Values: []any{l.Region}})
+	}
+	return conds
+}
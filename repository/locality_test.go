@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+func TestLocalityTier(t *testing.T) {
+	now := time.Now()
+	zoned := func(id, region, zone string) models.Instance {
+		inst := testInstance("order-service", id, region, 1)
+		inst.Metadata.Zone = zone
+		inst.LastHeartbeat = now
+		return inst
+	}
+	candidates := []models.Instance{
+		zoned("order-1", "us-east", "us-east-1a"),
+		zoned("order-2", "us-east", "us-east-1b"),
+		zoned("order-3", "us-east", "us-east-1b"),
+		zoned("order-4", "eu-west", "eu-west-1a"),
+	}
+	down := zoned("order-5", "us-east", "us-east-1a")
+	down.Health = models.HealthCritical
+	candidates = append(candidates, down)
+
+	tests := []struct {
+		name string
+		loc  Locality
+		want Tier
+	}{
+		{"zone", Locality{Region: "us-east", Zone: "us-east-1a"}, TierZone},
+		{"zone below min", Locality{Region: "us-east", Zone: "us-east-1a", MinHealthy: 2}, TierRegion},
+		{"zone below percent", Locality{Region: "us-east", Zone: "us-east-1b", MinPercent: 60}, TierRegion},
+		{"region below min", Locality{Region: "us-east", Zone: "us-east-1a", MinHealthy: 4}, TierAny},
+		{"zone only", Locality{Zone: "us-east-1b"}, TierZone},
+		{"unknown region", Locality{Region: "ap-south"}, TierAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.loc.Tier(candidates, now, time.Minute); got != tt.want {
+				t.Errorf("Tier() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	local := Filter{Conditions: Locality{Region: "us-east", Zone: "us-east-1b"}.Conditions(TierZone)}
+	if !local.Match(candidates[1]) || local.Match(candidates[0]) {
+		t.Errorf("zone conditions select the wrong instances")
+	}
+}
@@ -0,0 +1,300 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+type instanceKey struct {
+	serviceName string
+	id          string
+}
+
+func keyOf(inst models.Instance) instanceKey {
+	return instanceKey{serviceName: inst.ServiceName, id: inst.ID}
+}
+
+// journal records the mutations of a MemoryRepo. It is called with the
+// repository lock held, before the change is applied in memory.
+type journal interface {
+	logPut(inst models.Instance) error
+	// logRemove records a removal leaving a tombstone until then, none
+	// when it is zero
+	logRemove(key instanceKey, tombstone time.Time) error
+}
+
+// MemoryRepo is a concurrency-safe Registry kept entirely in process memory
+type MemoryRepo struct {
+	mu         sync.RWMutex
+	instances  map[instanceKey]models.Instance
+	tombstones map[instanceKey]time.Time // end of each tombstone
+	journal    journal                   // optional, nil for a purely in-memory registry
+}
+
+// NewMemoryRepo creates an empty in-memory repository
+func NewMemoryRepo() *MemoryRepo {
+	return &MemoryRepo{
+		instances:  make(map[instanceKey]models.Instance),
+		tombstones: make(map[instanceKey]time.Time),
+	}
+}
+
+func (r *MemoryRepo) Register(ctx context.Context, inst models.Instance) (models.Instance, *models.Instance, error) {
+	inst.Renew(time.Now().UTC())
+	inst.ResetHealth()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	existing, exists := r.instances[keyOf(inst)]
+	inst.Maintenance = existing.Maintenance
+	if err := r.put(inst); err != nil {
+		return inst, nil, err
+	}
+	delete(r.tombstones, keyOf(inst))
+	if !exists {
+		return inst, nil, nil
+	}
+	return inst, &existing, nil
+}
+
+func (r *MemoryRepo) Get(ctx context.Context, serviceName, id string) (models.Instance, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	inst, ok := r.instances[instanceKey{serviceName: serviceName, id: id}]
+	if !ok {
+		return inst, ErrNotFound
+	}
+	return inst, nil
+}
+
+func (r *MemoryRepo) Update(ctx context.Context, spec models.Instance) (models.Instance, models.Instance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inst, ok := r.instances[keyOf(spec)]
+	if !ok {
+		return inst, inst, ErrNotFound
+	}
+	previous := inst
+	inst.Amend(spec)
+	return inst, previous, r.put(inst)
+}
+
+func (r *MemoryRepo) UpdateHeartbeat(ctx context.Context, hb models.Heartbeat) (models.Instance, models.Instance, error) {
+	key := instanceKey{serviceName: hb.ServiceName, id: hb.ID}
+	now := time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inst, ok := r.instances[key]
+	if !ok {
+		if until, ok := r.tombstones[key]; ok && now.Before(until) {
+			return inst, inst, ErrExpired
+		}
+		return inst, inst, ErrNotFound
+	}
+	previous := inst
+	inst.Renew(now)
+	inst.Report(hb)
+	return inst, previous, r.put(inst)
+}
+
+func (r *MemoryRepo) ReportHealth(ctx context.Context, hb models.Heartbeat) (models.Instance, models.Instance, error) {
+	key := instanceKey{serviceName: hb.ServiceName, id: hb.ID}
+	now := time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inst, ok := r.instances[key]
+	if !ok {
+		return inst, inst, ErrNotFound
+	}
+	previous := inst
+	renewed := hb.Health() == models.HealthUp && !inst.HeartbeatLease()
+	if renewed {
+		inst.Renew(now)
+	}
+	inst.ReportChecks(hb, renewed)
+	return inst, previous, r.put(inst)
+}
+
+func (r *MemoryRepo) SetMaintenance(ctx context.Context, serviceName, id string, m *models.Maintenance) ([]models.Instance, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed := []models.Instance{}
+	for key, inst := range r.instances {
+		if key.serviceName != serviceName || (id != "" && key.id != id) {
+			continue
+		}
+		inst.Maintenance = nil
+		if m != nil {
+			copied := *m
+			inst.Maintenance = &copied
+		}
+		if err := r.put(inst); err != nil {
+			return changed, err
+		}
+		changed = append(changed, inst)
+	}
+	if len(changed) == 0 {
+		return changed, ErrNotFound
+	}
+	sortInstances(changed)
+	return changed, nil
+}
+
+func (r *MemoryRepo) EndMaintenance(ctx context.Context) ([]models.Instance, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ended := []models.Instance{}
+	for _, inst := range r.instances {
+		if inst.Maintenance != nil && !inst.Maintenance.Active(now) {
+			inst.Maintenance = nil
+			if err := r.put(inst); err != nil {
+				return ended, err
+			}
+			ended = append(ended, inst)
+		}
+	}
+	sortInstances(ended)
+	return ended, nil
+}
+
+func (r *MemoryRepo) Deregister(ctx context.Context, serviceName, id string) (models.Instance, error) {
+	key := instanceKey{serviceName: serviceName, id: id}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	inst, ok := r.instances[key]
+	if !ok {
+		return inst, ErrNotFound
+	}
+	return inst, r.remove(key, time.Time{})
+}
+
+func (r *MemoryRepo) Find(ctx context.Context, filter Filter, ttl time.Duration) ([]models.Instance, error) {
+	now := time.Now()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	instances := []models.Instance{}
+	for _, inst := range r.instances {
+		if !filter.IncludeUnhealthy && (inst.Health == models.HealthCritical || inst.LeaseExpiry(ttl).Before(now)) {
+			continue
+		}
+		if !filter.IncludeMaintenance && inst.Maintenance.Active(now) {
+			continue
+		}
+		if filter.Match(inst) {
+			instances = append(instances, inst)
+		}
+	}
+	return filter.Page.apply(instances)
+}
+
+func (r *MemoryRepo) Services(ctx context.Context, ttl time.Duration) ([]models.ServiceSummary, error) {
+	return summaries(r.catalog("", ttl)), nil
+}
+
+func (r *MemoryRepo) Service(ctx context.Context, name string, ttl time.Duration) (models.ServiceDetail, error) {
+	return detail(r.catalog(name, ttl))
+}
+
+// catalog summarizes the service called name, or every service when name is empty
+func (r *MemoryRepo) catalog(name string, ttl time.Duration) []models.ServiceDetail {
+	now := time.Now()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var buckets []bucket
+	for _, inst := range r.instances {
+		if name == "" || inst.ServiceName == name {
+			buckets = append(buckets, bucketOf(inst, now, ttl))
+		}
+	}
+	return catalog(buckets)
+}
+
+func (r *MemoryRepo) MarkCritical(ctx context.Context, ttl time.Duration) ([]models.Instance, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	marked := []models.Instance{}
+	for _, inst := range r.instances {
+		if inst.Health != models.HealthCritical && inst.LeaseExpiry(ttl).Before(now) {
+			inst.Health = models.HealthCritical
+			if err := r.put(inst); err != nil {
+				return marked, err
+			}
+			marked = append(marked, inst)
+		}
+	}
+	sortInstances(marked)
+	return marked, nil
+}
+
+func (r *MemoryRepo) CleanupDead(ctx context.Context, ttl, timeout, tombstoneTTL time.Duration) ([]models.Instance, error) {
+	now := time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, until := range r.tombstones {
+		if !now.Before(until) {
+			delete(r.tombstones, key)
+		}
+	}
+	removed := []models.Instance{}
+	for key, inst := range r.instances {
+		if inst.LeaseExpiry(ttl).Add(timeout).Before(now) {
+			if err := r.remove(key, now.Add(tombstoneTTL)); err != nil {
+				return removed, err
+			}
+			removed = append(removed, inst)
+		}
+	}
+	sortInstances(removed)
+	return removed, nil
+}
+
+// put stores inst, recording it in the journal first. Callers hold r.mu.
+func (r *MemoryRepo) put(inst models.Instance) error {
+	if r.journal != nil {
+		if err := r.journal.logPut(inst); err != nil {
+			return err
+		}
+	}
+	r.instances[keyOf(inst)] = inst
+	return nil
+}
+
+// remove deletes an instance, leaving a tombstone until tombstone unless it
+// is zero, and records it in the journal first. Callers hold r.mu.
+func (r *MemoryRepo) remove(key instanceKey, tombstone time.Time) error {
+	if r.journal != nil {
+		if err := r.journal.logRemove(key, tombstone); err != nil {
+			return err
+		}
+	}
+	delete(r.instances, key)
+	if !tombstone.IsZero() {
+		r.tombstones[key] = tombstone
+	}
+	return nil
+}
+
+// sortInstances orders instances by service name and id so results are stable
+func sortInstances(instances []models.Instance) {
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].ServiceName != instances[j].ServiceName {
+			return instances[i].ServiceName < instances[j].ServiceName
+		}
+		return instances[i].ID < instances[j].ID
+	})
+}
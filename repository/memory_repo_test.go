@@ -0,0 +1,394 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+func testInstance(serviceName, id, region string, version int) models.Instance {
+	return models.Instance{
+		ServiceName: serviceName,
+		ID:          id,
+		Host:        "127.0.0.1",
+		Port:        8080,
+		Mode:        "dev",
+		Metadata: models.Metadata{
+			Environment: "dev",
+			Region:      region,
+			Version:     version,
+		},
+	}
+}
+
+func TestMemoryRepoFind(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "eu-west", 2))
+	_, _, _ = repo.Register(ctx, testInstance("user-service", "user-1", "us-east", 1))
+
+	tests := []struct {
+		name        string
+		serviceName string
+		metadata    map[string]interface{}
+		want        []string
+	}{
+		{name: "all", want: []string{"order-1", "order-2", "user-1"}},
+		{name: "by service", serviceName: "order-service", want: []string{"order-1", "order-2"}},
+		{name: "by region", metadata: map[string]interface{}{"region": "us-east"}, want: []string{"order-1", "user-1"}},
+		{name: "numeric version", metadata: map[string]interface{}{"version": 2}, want: []string{"order-2"}},
+		{name: "string does not match int", metadata: map[string]interface{}{"version": "2"}, want: nil},
+		{name: "bool", metadata: map[string]interface{}{"experimental": false}, want: []string{"order-1", "order-2", "user-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := repo.Find(ctx, Filter{ServiceName: tt.serviceName, Metadata: tt.metadata}, time.Minute)
+			if err != nil {
+				t.Fatalf("Find() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Find() returned %d instances, want %d", len(got), len(tt.want))
+			}
+			for i, inst := range got {
+				if inst.ID != tt.want[i] {
+					t.Errorf("Find()[%d] = %s, want %s", i, inst.ID, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryRepoHeartbeatAndCleanup(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+
+	if _, _, err := repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "missing"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("UpdateHeartbeat() error = %v, want ErrNotFound", err)
+	}
+
+	if _, previous, _ := repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1)); previous != nil {
+		t.Error("Register() returned a previous instance for a new instance")
+	}
+	if _, previous, _ := repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 2)); previous == nil || previous.Metadata.Version != 1 {
+		t.Errorf("Register() previous = %v for an existing instance, want version 1", previous)
+	}
+	inst, previous, err := repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-1"})
+	if err != nil {
+		t.Fatalf("UpdateHeartbeat() error = %v", err)
+	}
+	if inst.Metadata.Version != 2 || previous.Health != models.HealthUp {
+		t.Errorf("UpdateHeartbeat() = version %d, previous %s; want 2, UP", inst.Metadata.Version, previous.Health)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if got, _ := repo.Find(ctx, Filter{}, time.Millisecond); len(got) != 0 {
+		t.Errorf("Find() returned %d expired instances", len(got))
+	}
+	if removed, _ := repo.CleanupDead(ctx, time.Millisecond, time.Minute, time.Minute); len(removed) != 0 {
+		t.Errorf("CleanupDead() removed %v before the deregistration timeout", removed)
+	}
+	marked, _ := repo.MarkCritical(ctx, time.Millisecond)
+	if len(marked) != 1 || marked[0].Health != models.HealthCritical {
+		t.Errorf("MarkCritical() = %v, want order-1 marked CRITICAL", marked)
+	}
+	if marked, _ := repo.MarkCritical(ctx, time.Millisecond); len(marked) != 0 {
+		t.Errorf("MarkCritical() marked %v again", marked)
+	}
+	if got, _ := repo.Find(ctx, Filter{IncludeUnhealthy: true}, 0); len(got) != 1 {
+		t.Errorf("Find() returned %d instances, want the critical one", len(got))
+	}
+
+	removed, _ := repo.CleanupDead(ctx, time.Millisecond, 0, time.Minute)
+	if len(removed) != 1 || removed[0].ID != "order-1" {
+		t.Errorf("CleanupDead() removed %v, want order-1", removed)
+	}
+	if got, _ := repo.Find(ctx, Filter{IncludeUnhealthy: true}, 0); len(got) != 0 {
+		t.Errorf("CleanupDead() left %d instances", len(got))
+	}
+	if _, _, err := repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-1"}); !errors.Is(err, ErrExpired) {
+		t.Errorf("UpdateHeartbeat() after removal error = %v, want ErrExpired", err)
+	}
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 2))
+	if _, _, err := repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-1"}); err != nil {
+		t.Errorf("UpdateHeartbeat() after registering again error = %v", err)
+	}
+}
+
+func TestMemoryRepoLeaseTTL(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	long := testInstance("order-service", "order-1", "us-east", 1)
+	long.TTL = 60
+	_, _, _ = repo.Register(ctx, long)
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "us-east", 1))
+
+	inst, _, _ := repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-1"})
+	if want := inst.LastHeartbeat.Add(time.Minute); !inst.ExpiresAt.Equal(want) {
+		t.Errorf("UpdateHeartbeat() expiresAt = %v, want %v", inst.ExpiresAt, want)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	got, _ := repo.Find(ctx, Filter{}, time.Millisecond)
+	if len(got) != 1 || got[0].ID != "order-1" {
+		t.Errorf("Find() = %v, want order-1 only", got)
+	}
+	removed, _ := repo.CleanupDead(ctx, time.Millisecond, 0, 0)
+	if len(removed) != 1 || removed[0].ID != "order-2" {
+		t.Errorf("CleanupDead() removed %v, want order-2", removed)
+	}
+}
+
+func TestMemoryRepoHealthReports(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "us-east", 1))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-3", "us-east", 1))
+
+	inst, previous, _ := repo.UpdateHeartbeat(ctx, models.Heartbeat{
+		ServiceName: "order-service",
+		ID:          "order-1",
+		Status:      models.StatusWarning,
+		Output:      "queue backing up",
+		Load:        map[string]float64{"cpu": 0.9},
+	})
+	if inst.Health != models.HealthWarning || previous.Health != models.HealthUp || inst.Output != "queue backing up" {
+		t.Errorf("UpdateHeartbeat() = %s (was %s) %q, want WARNING (was UP) with output", inst.Health, previous.Health, inst.Output)
+	}
+	_, _, _ = repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-2", Load: map[string]float64{"cpu": 0.2}})
+	_, _, _ = repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-3", Status: models.StatusCritical})
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"critical left out", Filter{}, []string{"order-1", "order-2"}},
+		{"unhealthy included", Filter{IncludeUnhealthy: true}, []string{"order-1", "order-2", "order-3"}},
+		{"by health", Filter{Health: []string{models.HealthWarning}}, []string{"order-1"}},
+		{"by load", Filter{MaxLoad: map[string]float64{"cpu": 0.5}}, []string{"order-2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := repo.Find(ctx, tt.filter, time.Minute)
+			var ids []string
+			for _, inst := range got {
+				ids = append(ids, inst.ID)
+			}
+			if !slices.Equal(ids, tt.want) {
+				t.Errorf("Find() = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryRepoMaintenance(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "us-east", 1))
+
+	if _, err := repo.SetMaintenance(ctx, "order-service", "missing", nil); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SetMaintenance() error = %v, want ErrNotFound", err)
+	}
+	drain := &models.Maintenance{State: models.StateDraining, Reason: "deploy", Since: time.Now()}
+	if changed, _ := repo.SetMaintenance(ctx, "order-service", "", drain); len(changed) != 2 {
+		t.Fatalf("SetMaintenance() changed %d instances, want 2", len(changed))
+	}
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 2))
+
+	if got, _ := repo.Find(ctx, Filter{}, time.Minute); len(got) != 0 {
+		t.Errorf("Find() returned %d instances in maintenance", len(got))
+	}
+	if got, _ := repo.Find(ctx, Filter{IncludeMaintenance: true}, time.Minute); len(got) != 2 || got[0].Maintenance == nil {
+		t.Errorf("Find(IncludeMaintenance) = %v, want both draining instances", got)
+	}
+
+	_, _ = repo.SetMaintenance(ctx, "order-service", "order-2", &models.Maintenance{State: models.StateMaintenance, Until: time.Now()})
+	ended, _ := repo.EndMaintenance(ctx)
+	if len(ended) != 1 || ended[0].ID != "order-2" || ended[0].Maintenance != nil {
+		t.Errorf("EndMaintenance() = %v, want order-2 out of maintenance", ended)
+	}
+}
+
+func TestMemoryRepoFindPages(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 3))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "us-east", 1))
+	_, _, _ = repo.Register(ctx, testInstance("user-service", "user-1", "us-east", 3))
+	_, _, _ = repo.Register(ctx, testInstance("user-service", "user-2", "us-east", 2))
+
+	page := Page{Sort: SortVersion, Desc: true, Limit: 3}
+	var got []string
+	for {
+		instances, err := repo.Find(ctx, Filter{Page: page}, time.Minute)
+		if err != nil {
+			t.Fatalf("Find() error = %v", err)
+		}
+		for _, inst := range instances {
+			got = append(got, inst.ID)
+		}
+		if len(instances) < page.Limit {
+			break
+		}
+		page.After = Cursor(instances[len(instances)-1], page)
+	}
+	if want := []string{"user-1", "order-1", "user-2", "order-2"}; !slices.Equal(got, want) {
+		t.Errorf("Find() pages = %v, want %v", got, want)
+	}
+
+	page.After = Cursor(testInstance("order-service", "order-1", "us-east", 3), Page{Sort: SortVersion})
+	if _, err := repo.Find(ctx, Filter{Page: page}, time.Minute); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Find() with an ascending cursor error = %v, want ErrInvalidCursor", err)
+	}
+}
+
+func TestMemoryRepoCheckReports(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	registered, _, _ := repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "us-east", 1))
+	_, _, _ = repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-2", Status: models.StatusWarning})
+	time.Sleep(5 * time.Millisecond)
+	_, _ = repo.MarkCritical(ctx, time.Millisecond)
+
+	// order-1 only has checks, which renew its lease
+	inst, previous, _ := repo.ReportHealth(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-1", Status: models.StatusPassing})
+	if inst.Health != models.HealthUp || previous.Health != models.HealthCritical || !inst.LastHeartbeat.After(registered.LastHeartbeat) {
+		t.Errorf("ReportHealth() = %s (was %s), want the lease renewed and UP", inst.Health, previous.Health)
+	}
+	// order-2 sends heartbeats, its checks neither renew its lease nor hide its own health
+	inst, _, _ = repo.ReportHealth(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-2", Status: models.StatusPassing})
+	if inst.Health != models.HealthCritical || inst.CheckHealth != models.HealthUp {
+		t.Errorf("ReportHealth() of an expired instance = %s, want CRITICAL", inst.Health)
+	}
+	inst, _, _ = repo.UpdateHeartbeat(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-2", Status: models.StatusWarning})
+	if inst.Health != models.HealthWarning {
+		t.Errorf("UpdateHeartbeat() = %s, want WARNING", inst.Health)
+	}
+	inst, _, _ = repo.ReportHealth(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-2", Status: models.StatusCritical, Output: "down"})
+	if inst.Health != models.HealthCritical || inst.ReportedHealth != models.HealthWarning || inst.CheckOutput != "down" {
+		t.Errorf("ReportHealth() = %+v, want CRITICAL from the checks", inst)
+	}
+	inst, _, _ = repo.ReportHealth(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-2", Status: models.StatusPassing})
+	if inst.Health != models.HealthWarning {
+		t.Errorf("ReportHealth() = %s, want the reported WARNING back", inst.Health)
+	}
+}
+
+func TestMemoryRepoUpdate(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+	reported, _, _ := repo.ReportHealth(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-1", Status: models.StatusWarning, Output: "slow"})
+
+	spec := testInstance("order-service", "order-1", "eu-west", 2)
+	spec.Port = 9090
+	updated, previous, err := repo.Update(ctx, spec)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if updated.Port != 9090 || updated.Metadata.Region != "eu-west" {
+		t.Errorf("Update() = %+v, want the new registration", updated)
+	}
+	if previous.Port != 8080 || previous.Metadata.Region != "us-east" {
+		t.Errorf("Update() previous = %+v, want the registration it replaced", previous)
+	}
+	if updated.Health != models.HealthWarning || updated.CheckOutput != "slow" || !updated.LastHeartbeat.Equal(reported.LastHeartbeat) {
+		t.Errorf("Update() = %+v, want health and lease kept", updated)
+	}
+	if got, _ := repo.Get(ctx, "order-service", "order-1"); got.Port != 9090 {
+		t.Errorf("Get() = %+v, want the updated instance", got)
+	}
+	if _, _, err := repo.Update(ctx, testInstance("order-service", "missing", "us-east", 1)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Update(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemoryRepoUpdateLease(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	spec := testInstance("order-service", "order-1", "us-east", 1)
+	spec.TTL = 30
+	registered, _, _ := repo.Register(ctx, spec)
+
+	spec.TTL = 600
+	updated, _, err := repo.Update(ctx, spec)
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if want := registered.LastHeartbeat.Add(10 * time.Minute); !updated.ExpiresAt.Equal(want) {
+		t.Errorf("Update() expiresAt = %v, want %v", updated.ExpiresAt, want)
+	}
+	if !updated.LastHeartbeat.Equal(registered.LastHeartbeat) {
+		t.Errorf("Update() lastHeartbeat = %v, want it kept", updated.LastHeartbeat)
+	}
+
+	spec.TTL = 0
+	updated, _, _ = repo.Update(ctx, spec)
+	if !updated.ExpiresAt.IsZero() {
+		t.Errorf("Update() without a ttl kept expiresAt %v", updated.ExpiresAt)
+	}
+}
+
+func TestMemoryRepoServices(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-2", "eu-west", 2))
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-3", "eu-west", 2))
+	_, _, _ = repo.Register(ctx, testInstance("user-service", "user-1", "us-east", 1))
+	_, _, _ = repo.ReportHealth(ctx, models.Heartbeat{ServiceName: "order-service", ID: "order-2", Status: models.StatusCritical})
+	_, _ = repo.SetMaintenance(ctx, "order-service", "order-3", &models.Maintenance{State: models.StateDraining})
+
+	services, err := repo.Services(ctx, time.Minute)
+	if err != nil {
+		t.Fatalf("Services() error = %v", err)
+	}
+	if len(services) != 2 || services[0].Name != "order-service" || services[1].Name != "user-service" {
+		t.Fatalf("Services() = %v, want order-service and user-service", services)
+	}
+	order := services[0]
+	if order.Instances != 3 || order.Healthy != 2 || order.Unhealthy != 1 || order.Maintenance != 1 {
+		t.Errorf("Services()[0] counts = %+v", order)
+	}
+	if !slices.Equal(order.Regions, []string{"eu-west", "us-east"}) || !slices.Equal(order.Versions, []int{1, 2}) {
+		t.Errorf("Services()[0] regions = %v, versions = %v", order.Regions, order.Versions)
+	}
+
+	detail, err := repo.Service(ctx, "order-service", time.Minute)
+	if err != nil {
+		t.Fatalf("Service() error = %v", err)
+	}
+	if detail.Health[models.HealthCritical] != 1 || detail.Health[models.HealthUp] != 2 ||
+		detail.MaintenanceStates[models.StateDraining] != 1 || detail.ByVersion[2] != 2 {
+		t.Errorf("Service() = %+v", detail)
+	}
+	if _, err := repo.Service(ctx, "missing", time.Minute); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("Service(missing) error = %v, want ErrServiceNotFound", err)
+	}
+}
+
+func TestMemoryRepoDeregister(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryRepo()
+	_, _, _ = repo.Register(ctx, testInstance("order-service", "order-1", "us-east", 1))
+
+	inst, err := repo.Deregister(ctx, "order-service", "order-1")
+	if err != nil {
+		t.Fatalf("Deregister() error = %v", err)
+	}
+	if inst.Host != "127.0.0.1" {
+		t.Errorf("Deregister() returned %+v, want the stored instance", inst)
+	}
+	if _, err := repo.Deregister(ctx, "order-service", "order-1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Deregister() error = %v, want ErrNotFound", err)
+	}
+}
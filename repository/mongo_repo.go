@@ -2,6 +2,7 @@ package repository
 
 import (
 	"context"
+	"regexp"
 	"time"
 
 	"github.com/spidey52/service-discovery/models"
@@ -10,54 +11,392 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// docID is the _id of a registry document
+type docID struct {
+	ServiceName string `bson:"serviceName"`
+	ID          string `bson:"id"`
+}
+
+// tombstone marks an instance removed because its lease ran out
+type tombstone struct {
+	ID          docID     `bson:"_id"`
+	ServiceName string    `bson:"serviceName"`
+	InstanceID  string    `bson:"id"`
+	ExpiresAt   time.Time `bson:"expiresAt"`
+}
+
+// MongoRepo is a Registry backed by a MongoDB collection. Tombstones are
+// kept in a sibling collection named after it.
 type MongoRepo struct {
-	coll *mongo.Collection
+	coll       *mongo.Collection
+	tombstones *mongo.Collection
 }
 
 // NewMongoRepo creates a new repository
 func NewMongoRepo(coll *mongo.Collection) *MongoRepo {
-	return &MongoRepo{coll: coll}
+	return &MongoRepo{
+		coll:       coll,
+		tombstones: coll.Database().Collection(coll.Name() + "_tombstones"),
+	}
 }
 
-func (r *MongoRepo) Register(ctx context.Context, inst models.Instance) error {
-	inst.LastHeartbeat = time.Now().UTC()
-	inst.Health = "UP"
-	filter := bson.M{"serviceName": inst.ServiceName, "id": inst.ID}
-	update := bson.M{"$set": inst}
-	_, err := r.coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+// EnsureIndexes creates the indexes lookups rely on: the instance key,
+// tags, endpoint names and every label
+func (r *MongoRepo) EnsureIndexes(ctx context.Context) error {
+	_, err := r.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "serviceName", Value: 1}, {Key: "id", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "endpoints.name", Value: 1}}},
+		{Keys: bson.D{{Key: "labels.$**", Value: 1}}},
+	})
 	return err
 }
 
-func (r *MongoRepo) UpdateHeartbeat(ctx context.Context, serviceName, id string) error {
-	filter := bson.M{"serviceName": serviceName, "id": id}
-	update := bson.M{"$set": bson.M{"lastHeartbeat": time.Now().UTC(), "health": "UP"}}
-	res, err := r.coll.UpdateOne(ctx, filter, update)
+func (r *MongoRepo) Register(ctx context.Context, inst models.Instance) (models.Instance, *models.Instance, error) {
+	inst.Renew(time.Now().UTC())
+	inst.ResetHealth()
+	// Maintenance is left as stored
+	inst.Maintenance = nil
+	filter := bson.M{"serviceName": inst.ServiceName, "id": inst.ID}
+	// Fields left empty are omitted from $set and have to be cleared
+	unset := bson.M{"reportedHealth": "", "output": "", "load": "", "checkHealth": "", "checkOutput": ""}
+	if inst.TTL == 0 {
+		unset["ttl"] = ""
+		unset["expiresAt"] = ""
+	}
+	if len(inst.Checks) == 0 {
+		unset["checks"] = ""
+	}
+	if len(inst.Labels) == 0 {
+		unset["labels"] = ""
+	}
+	if len(inst.Tags) == 0 {
+		unset["tags"] = ""
+	}
+	if len(inst.Endpoints) == 0 {
+		unset["endpoints"] = ""
+	}
+	if inst.Weight == 0 {
+		unset["weight"] = ""
+	}
+	update := bson.M{
+		"$set":   inst,
+		"$unset": unset,
+		// A predictable _id lets change stream delete events, which only
+		// carry the document key, identify the removed instance
+		"$setOnInsert": bson.M{"_id": docID{ServiceName: inst.ServiceName, ID: inst.ID}},
+	}
+	var previous models.Instance
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+	err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&previous)
+	created := err == mongo.ErrNoDocuments
+	if err != nil && !created {
+		return inst, nil, err
+	}
+	inst.Maintenance = previous.Maintenance
+	key := docID{ServiceName: inst.ServiceName, ID: inst.ID}
+	if _, err := r.tombstones.DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		return inst, nil, err
+	}
+	if created {
+		return inst, nil, nil
+	}
+	return inst, &previous, nil
+}
+
+func (r *MongoRepo) Get(ctx context.Context, serviceName, id string) (models.Instance, error) {
+	var inst models.Instance
+	err := r.coll.FindOne(ctx, bson.M{"serviceName": serviceName, "id": id}).Decode(&inst)
+	if err == mongo.ErrNoDocuments {
+		return inst, ErrNotFound
+	}
+	return inst, err
+}
+
+func (r *MongoRepo) Update(ctx context.Context, spec models.Instance) (models.Instance, models.Instance, error) {
+	// A pipeline update ends the lease after the new ttl, counted from the
+	// last heartbeat like models.Instance.Amend does
+	expiresAt := interface{}("$$REMOVE")
+	if spec.TTL > 0 {
+		expiresAt = bson.M{"$add": bson.A{"$lastHeartbeat", spec.TTL * 1000}}
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"host":      literal(spec.Host),
+		"port":      literal(spec.Port),
+		"mode":      literal(spec.Mode),
+		"metadata":  literal(spec.Metadata),
+		"ttl":       orRemove(spec.TTL > 0, spec.TTL),
+		"expiresAt": expiresAt,
+		"checks":    orRemove(len(spec.Checks) > 0, spec.Checks),
+		"labels":    orRemove(len(spec.Labels) > 0, spec.Labels),
+		"tags":      orRemove(len(spec.Tags) > 0, spec.Tags),
+		"endpoints": orRemove(len(spec.Endpoints) > 0, spec.Endpoints),
+		"weight":    orRemove(spec.Weight > 0, spec.Weight),
+	}}}}
+
+	// The previous document is returned as well; the update is then
+	// applied to it locally
+	var previous models.Instance
+	filter := bson.M{"serviceName": spec.ServiceName, "id": spec.ID}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&previous)
+	if err == mongo.ErrNoDocuments {
+		return previous, previous, ErrNotFound
+	}
+	if err != nil {
+		return previous, previous, err
+	}
+	inst := previous
+	inst.Amend(spec)
+	return inst, previous, nil
+}
+
+func (r *MongoRepo) UpdateHeartbeat(ctx context.Context, hb models.Heartbeat) (models.Instance, models.Instance, error) {
+	var inst models.Instance
+	// MongoDB stores milliseconds, truncating keeps the returned copy exact
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	filter := bson.M{"serviceName": hb.ServiceName, "id": hb.ID}
+	// A pipeline update renews the lease from the instance's own ttl
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"lastHeartbeat":  now,
+		"health":         worseHealth(hb.Health(), "$checkHealth"),
+		"reportedHealth": hb.Health(),
+		"output":         orRemove(hb.Output != "", hb.Output),
+		"load":           orRemove(len(hb.Load) > 0, hb.Load),
+		"expiresAt":      leaseEnd(now),
+	}}}}
+	// The previous document is returned as well; the update is then
+	// applied to it locally
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&inst)
+	if err == mongo.ErrNoDocuments {
+		key := docID{ServiceName: hb.ServiceName, ID: hb.ID}
+		n, err := r.tombstones.CountDocuments(ctx, bson.M{"_id": key, "expiresAt": bson.M{"$gt": now}})
+		if err != nil {
+			return inst, inst, err
+		}
+		if n > 0 {
+			return inst, inst, ErrExpired
+		}
+		return inst, inst, ErrNotFound
+	}
+	if err != nil {
+		return inst, inst, err
+	}
+	previous := inst
+	inst.Renew(now)
+	inst.Report(hb)
+	return inst, previous, nil
+}
+
+// leaseEnd is the expression for the end of a lease renewed at now, from
+// the instance's own ttl
+func leaseEnd(now time.Time) bson.M {
+	return bson.M{"$cond": bson.A{
+		bson.M{"$gt": bson.A{"$ttl", 0}},
+		bson.M{"$add": bson.A{now, bson.M{"$multiply": bson.A{"$ttl", 1000}}}},
+		"$$REMOVE",
+	}}
+}
+
+// worseHealth is the expression for models.WorseHealth
+func worseHealth(a, b interface{}) bson.M {
+	both := bson.A{a, b}
+	return bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": bson.M{"$in": bson.A{models.HealthCritical, both}}, "then": models.HealthCritical},
+			bson.M{"case": bson.M{"$in": bson.A{models.HealthWarning, both}}, "then": models.HealthWarning},
+		},
+		"default": models.HealthUp,
+	}}
+}
+
+// orRemove returns v, or $$REMOVE to drop the field in a pipeline update
+// when keep is false
+func orRemove(keep bool, v interface{}) interface{} {
+	if !keep {
+		return "$$REMOVE"
+	}
+	return literal(v)
+}
+
+// literal keeps v from being evaluated as an expression in a pipeline update
+func literal(v interface{}) bson.M {
+	return bson.M{"$literal": v}
+}
+
+func (r *MongoRepo) ReportHealth(ctx context.Context, hb models.Heartbeat) (models.Instance, models.Instance, error) {
+	var inst models.Instance
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	filter := bson.M{"serviceName": hb.ServiceName, "id": hb.ID}
+	// Like models.Instance.ReportChecks: a passing round renews the lease
+	// of an instance that has not sent a heartbeat since it registered, and
+	// an instance marked CRITICAL because its lease ran out stays so unless
+	// the round renewed it
+	passing := hb.Health() == models.HealthUp
+	var renew interface{} = false
+	if passing {
+		renew = bson.M{"$eq": bson.A{bson.M{"$ifNull": bson.A{"$reportedHealth", ""}}, ""}}
+	}
+	expired := bson.M{"$and": bson.A{
+		bson.M{"$eq": bson.A{"$health", models.HealthCritical}},
+		bson.M{"$ne": bson.A{worseHealth("$reportedHealth", "$checkHealth"), models.HealthCritical}},
+	}}
+	set := bson.M{
+		"checkHealth": hb.Health(),
+		"checkOutput": orRemove(hb.Output != "", hb.Output),
+		"health": bson.M{"$cond": bson.A{
+			bson.M{"$and": bson.A{expired, bson.M{"$not": bson.A{renew}}}},
+			models.HealthCritical,
+			worseHealth("$reportedHealth", hb.Health()),
+		}},
+	}
+	if passing {
+		set["lastHeartbeat"] = bson.M{"$cond": bson.A{renew, now, "$lastHeartbeat"}}
+		set["expiresAt"] = bson.M{"$cond": bson.A{renew, leaseEnd(now), "$expiresAt"}}
+	}
+	update := mongo.Pipeline{{{Key: "$set", Value: set}}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+	err := r.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&inst)
+	if err == mongo.ErrNoDocuments {
+		return inst, inst, ErrNotFound
+	}
 	if err != nil {
-		return err
+		return inst, inst, err
+	}
+	previous := inst
+	renewed := passing && !inst.HeartbeatLease()
+	if renewed {
+		inst.Renew(now)
+	}
+	inst.ReportChecks(hb, renewed)
+	return inst, previous, nil
+}
+
+func (r *MongoRepo) SetMaintenance(ctx context.Context, serviceName, id string, m *models.Maintenance) ([]models.Instance, error) {
+	filter := bson.M{"serviceName": serviceName}
+	if id != "" {
+		filter["id"] = id
+	}
+	update := bson.M{"$unset": bson.M{"maintenance": ""}}
+	if m != nil {
+		update = bson.M{"$set": bson.M{"maintenance": m}}
+	}
+	res, err := r.coll.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return nil, err
 	}
 	if res.MatchedCount == 0 {
-		return mongo.ErrNoDocuments
+		return nil, ErrNotFound
+	}
+
+	cur, err := r.coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	changed := []models.Instance{}
+	if err := cur.All(ctx, &changed); err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+func (r *MongoRepo) EndMaintenance(ctx context.Context) ([]models.Instance, error) {
+	expired := bson.M{"maintenance.until": bson.M{"$lte": time.Now()}}
+	ids, err := r.ids(ctx, expired)
+	if err != nil {
+		return nil, err
+	}
+
+	// Re-checked one by one so only the server making the change reports it
+	update := bson.M{"$unset": bson.M{"maintenance": ""}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	ended := []models.Instance{}
+	for _, id := range ids {
+		var inst models.Instance
+		expired["_id"] = id
+		err := r.coll.FindOneAndUpdate(ctx, expired, update, opts).Decode(&inst)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return ended, err
+		}
+		ended = append(ended, inst)
 	}
-	return nil
+	return ended, nil
 }
 
-func (r *MongoRepo) Find(ctx context.Context, serviceName, mode string, metadata map[string]interface{}, aliveOnly bool, ttl time.Duration) ([]models.Instance, error) {
+func (r *MongoRepo) Deregister(ctx context.Context, serviceName, id string) (models.Instance, error) {
+	var inst models.Instance
+	filter := bson.M{"serviceName": serviceName, "id": id}
+	err := r.coll.FindOneAndDelete(ctx, filter).Decode(&inst)
+	if err == mongo.ErrNoDocuments {
+		return inst, ErrNotFound
+	}
+	return inst, err
+}
+
+func (r *MongoRepo) Find(ctx context.Context, f Filter, ttl time.Duration) ([]models.Instance, error) {
 	filter := bson.M{}
-	if serviceName != "" {
-		filter["serviceName"] = serviceName
+	if f.FoldServiceName && f.ServiceName != "" {
+		filter["serviceName"] = bson.M{"$regex": "^" + regexp.QuoteMeta(f.ServiceName) + "$", "$options": "i"}
+	} else if f.ServiceName != "" {
+		filter["serviceName"] = f.ServiceName
 	}
-	if mode != "" {
-		filter["mode"] = mode
+	if f.Mode != "" {
+		filter["mode"] = f.Mode
 	}
-	for k, v := range metadata {
+	for k, v := range f.Metadata {
 		filter["metadata."+k] = v
 	}
-	if aliveOnly {
-		cutoff := time.Now().Add(-ttl)
-		filter["lastHeartbeat"] = bson.M{"$gte": cutoff}
+	health := bson.M{}
+	if len(f.Health) > 0 {
+		health["$in"] = f.Health
+	}
+	for name, limit := range f.MaxLoad {
+		filter["load."+name] = bson.M{"$lte": limit}
+	}
+	if f.HasChecks {
+		filter["checks.0"] = bson.M{"$exists": true}
+	}
+	now := time.Now()
+	var and bson.A
+	for _, cond := range f.Conditions {
+		and = append(and, cond.mongo())
+	}
+	if !f.IncludeUnhealthy {
+		health["$ne"] = models.HealthCritical
+		and = append(and, bson.M{"$or": leaseAlive(now, ttl)})
+	}
+	if len(health) > 0 {
+		filter["health"] = health
+	}
+	if !f.IncludeMaintenance {
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"maintenance": bson.M{"$exists": false}},
+			bson.M{"maintenance.until": bson.M{"$lte": now}},
+		}})
+	}
+	after, err := f.Page.after()
+	if err != nil {
+		return nil, err
+	}
+	if after != nil {
+		and = append(and, f.Page.seek(*after))
+	}
+	if len(and) > 0 {
+		filter["$and"] = and
 	}
 
-	cur, err := r.coll.Find(ctx, filter)
+	opts := options.Find().SetSort(f.Page.sort())
+	if f.Page.Limit > 0 {
+		opts.SetLimit(int64(f.Page.Limit))
+	}
+	if proj := f.Page.projection(); proj != nil {
+		opts.SetProjection(proj)
+	}
+	cur, err := r.coll.Find(ctx, filter, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -70,8 +409,163 @@ func (r *MongoRepo) Find(ctx context.Context, serviceName, mode string, metadata
 	return instances, nil
 }
 
-func (r *MongoRepo) CleanupDead(ctx context.Context, ttl time.Duration) error {
-	cutoff := time.Now().Add(-ttl)
-	_, err := r.coll.DeleteMany(ctx, bson.M{"lastHeartbeat": bson.M{"$lt": cutoff}})
-	return err
+func (r *MongoRepo) Services(ctx context.Context, ttl time.Duration) ([]models.ServiceSummary, error) {
+	details, err := r.catalog(ctx, bson.M{}, ttl)
+	return summaries(details), err
+}
+
+func (r *MongoRepo) Service(ctx context.Context, name string, ttl time.Duration) (models.ServiceDetail, error) {
+	details, err := r.catalog(ctx, bson.M{"serviceName": name}, ttl)
+	if err != nil {
+		return models.ServiceDetail{}, err
+	}
+	return detail(details)
+}
+
+// catalog groups the documents selected by match into buckets on the
+// server, so only their counts are transferred
+func (r *MongoRepo) catalog(ctx context.Context, match bson.M, ttl time.Duration) ([]models.ServiceDetail, error) {
+	now := time.Now()
+	alive := bson.M{"$cond": bson.A{
+		bson.M{"$ifNull": bson.A{"$expiresAt", false}},
+		bson.M{"$gte": bson.A{"$expiresAt", now}},
+		bson.M{"$gte": bson.A{"$lastHeartbeat", now.Add(-ttl)}},
+	}}
+	maintenance := bson.M{"$cond": bson.A{
+		bson.M{"$or": bson.A{
+			bson.M{"$not": bson.A{"$maintenance.state"}},
+			bson.M{"$and": bson.A{
+				bson.M{"$ifNull": bson.A{"$maintenance.until", false}},
+				bson.M{"$lte": bson.A{"$maintenance.until", now}},
+			}},
+		}},
+		"",
+		"$maintenance.state",
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: match}},
+		{{Key: "$group", Value: bson.M{
+			"_id": bson.M{
+				"service":     "$serviceName",
+				"health":      bson.M{"$cond": bson.A{alive, bson.M{"$ifNull": bson.A{"$health", ""}}, models.HealthCritical}},
+				"maintenance": maintenance,
+				"mode":        "$mode",
+				"region":      "$metadata.region",
+				"version":     "$metadata.version",
+			},
+			"count":         bson.M{"$sum": 1},
+			"lastHeartbeat": bson.M{"$max": "$lastHeartbeat"},
+		}}},
+	}
+
+	cur, err := r.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	var buckets []bucket
+	if err := cur.All(ctx, &buckets); err != nil {
+		return nil, err
+	}
+	return catalog(buckets), nil
+}
+
+func (r *MongoRepo) MarkCritical(ctx context.Context, ttl time.Duration) ([]models.Instance, error) {
+	expired := bson.M{"$or": leaseExpired(time.Now(), ttl), "health": bson.M{"$ne": models.HealthCritical}}
+	ids, err := r.ids(ctx, expired)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update one by one with the filter re-checked, so an instance that
+	// heartbeated in the meantime stays UP and each change is reported
+	// only by the server that actually made it
+	update := bson.M{"$set": bson.M{"health": models.HealthCritical}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	marked := []models.Instance{}
+	for _, id := range ids {
+		var inst models.Instance
+		expired["_id"] = id
+		err := r.coll.FindOneAndUpdate(ctx, expired, update, opts).Decode(&inst)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return marked, err
+		}
+		marked = append(marked, inst)
+	}
+	return marked, nil
+}
+
+func (r *MongoRepo) CleanupDead(ctx context.Context, ttl, timeout, tombstoneTTL time.Duration) ([]models.Instance, error) {
+	now := time.Now()
+	if _, err := r.tombstones.DeleteMany(ctx, bson.M{"expiresAt": bson.M{"$lte": now}}); err != nil {
+		return nil, err
+	}
+	dead := bson.M{"$or": leaseExpired(now.Add(-timeout), ttl)}
+	ids, err := r.ids(ctx, dead)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete one by one with the lease re-checked, so an instance that
+	// heartbeated in the meantime survives and each removal is reported
+	// only by the server that actually performed it
+	removed := []models.Instance{}
+	for _, id := range ids {
+		var inst models.Instance
+		dead["_id"] = id
+		err := r.coll.FindOneAndDelete(ctx, dead).Decode(&inst)
+		if err == mongo.ErrNoDocuments {
+			continue
+		}
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, inst)
+
+		key := docID{ServiceName: inst.ServiceName, ID: inst.ID}
+		tomb := tombstone{ID: key, ServiceName: inst.ServiceName, InstanceID: inst.ID, ExpiresAt: now.Add(tombstoneTTL)}
+		_, err = r.tombstones.ReplaceOne(ctx, bson.M{"_id": key}, tomb, options.Replace().SetUpsert(true))
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// ids returns the _id of every document matching filter
+func (r *MongoRepo) ids(ctx context.Context, filter bson.M) ([]interface{}, error) {
+	cur, err := r.coll.Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var docs []struct {
+		ID interface{} `bson:"_id"`
+	}
+	if err := cur.All(ctx, &docs); err != nil {
+		return nil, err
+	}
+	ids := make([]interface{}, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.ID
+	}
+	return ids, nil
+}
+
+// leaseAlive matches documents whose lease runs at least until now.
+// Documents stored without a lease live for ttl after their last heartbeat.
+func leaseAlive(now time.Time, ttl time.Duration) bson.A {
+	return bson.A{
+		bson.M{"expiresAt": bson.M{"$gte": now}},
+		bson.M{"expiresAt": bson.M{"$exists": false}, "lastHeartbeat": bson.M{"$gte": now.Add(-ttl)}},
+	}
+}
+
+// leaseExpired matches the documents leaseAlive does not
+func leaseExpired(now time.Time, ttl time.Duration) bson.A {
+	return bson.A{
+		bson.M{"expiresAt": bson.M{"$lt": now}},
+		bson.M{"expiresAt": bson.M{"$exists": false}, "lastHeartbeat": bson.M{"$lt": now.Add(-ttl)}},
+	}
 }
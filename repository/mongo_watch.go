@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	watchRetryMin = time.Second
+	watchRetryMax = 30 * time.Second
+)
+
+// heartbeatFields are the fields a heartbeat touches besides the health; an
+// update changing nothing else is reported as ChangeHeartbeat
+var heartbeatFields = map[string]bool{
+	"lastHeartbeat":  true,
+	"expiresAt":      true,
+	"reportedHealth": true,
+	"output":         true,
+	"load":           true,
+	"checkHealth":    true,
+	"checkOutput":    true,
+}
+
+// changeEvent is the subset of a change stream event the watcher needs
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	DocumentKey   struct {
+		ID bson.RawValue `bson:"_id"`
+	} `bson:"documentKey"`
+	FullDocument             *models.Instance `bson:"fullDocument"`
+	FullDocumentBeforeChange *models.Instance `bson:"fullDocumentBeforeChange"`
+	UpdateDescription        struct {
+		UpdatedFields bson.M   `bson:"updatedFields"`
+		RemovedFields []string `bson:"removedFields"`
+	} `bson:"updateDescription"`
+}
+
+// Watch follows the registry collection's change stream and calls fn for
+// every write, including those made by other servers. The stream is resumed
+// from the last seen event after errors. It requires a replica set; on a
+// standalone server it keeps retrying without ever calling live(true).
+func (r *MongoRepo) Watch(ctx context.Context, fn func(Change), live func(bool)) error {
+	// Pre-images let delete events carry the removed instance. They need
+	// MongoDB 6.0, without them deletes only identify the instance.
+	err := r.coll.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: r.coll.Name()},
+		{Key: "changeStreamPreAndPostImages", Value: bson.M{"enabled": true}},
+	}).Err()
+	if err != nil {
+		log.Printf("change stream pre-images unavailable: %v", err)
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": bson.M{"$in": bson.A{"insert", "update", "replace", "delete"}}}}},
+	}
+	var resumeToken bson.Raw
+	backoff := watchRetryMin
+	for {
+		opts := options.ChangeStream().
+			SetFullDocument(options.UpdateLookup).
+			SetFullDocumentBeforeChange(options.WhenAvailable)
+		if resumeToken != nil {
+			opts.SetResumeAfter(resumeToken)
+		}
+
+		stream, err := r.coll.Watch(ctx, pipeline, opts)
+		if err == nil {
+			backoff = watchRetryMin
+			live(true)
+			for stream.Next(ctx) {
+				resumeToken = stream.ResumeToken()
+				var ev changeEvent
+				if err := stream.Decode(&ev); err != nil {
+					log.Printf("change stream decode failed: %v", err)
+					continue
+				}
+				if change, ok := ev.change(); ok {
+					fn(change)
+				}
+			}
+			live(false)
+			err = stream.Err()
+			stream.Close(context.Background())
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		log.Printf("change stream interrupted, retrying in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, watchRetryMax)
+	}
+}
+
+// change converts a change stream event into a registry Change
+func (ev changeEvent) change() (Change, bool) {
+	switch ev.OperationType {
+	case "insert":
+		if ev.FullDocument == nil {
+			return Change{}, false
+		}
+		return Change{Kind: ChangeRegister, Instance: *ev.FullDocument}, true
+
+	case "update", "replace":
+		// The document may already be gone when the update is looked up
+		if ev.FullDocument == nil {
+			return Change{}, false
+		}
+		if ev.maintenanceOnly() {
+			return Change{Kind: ChangeMaintenance, Instance: *ev.FullDocument}, true
+		}
+		kind := ChangeHeartbeat
+		if ev.OperationType == "replace" || len(ev.UpdateDescription.UpdatedFields) == 0 {
+			kind = ChangeUpdate
+		}
+		for field := range ev.UpdateDescription.UpdatedFields {
+			if !heartbeatFields[field] && field != "health" {
+				kind = ChangeUpdate
+				break
+			}
+		}
+		// A health change alone is a health transition, unless the
+		// instance became CRITICAL because its lease ran out
+		change := Change{Kind: kind, Instance: *ev.FullDocument, Previous: ev.FullDocumentBeforeChange}
+		if _, ok := ev.UpdateDescription.UpdatedFields["health"]; ok && kind == ChangeHeartbeat {
+			_, heartbeat := ev.UpdateDescription.UpdatedFields["lastHeartbeat"]
+			inst := ev.FullDocument
+			if !heartbeat && inst.Health == models.HealthCritical && !inst.ExpiresAt.After(time.Now()) {
+				change.Kind = ChangeExpire
+			} else {
+				change.Kind = ChangeHealth
+				if ev.FullDocumentBeforeChange != nil {
+					change.PreviousHealth = ev.FullDocumentBeforeChange.Health
+				}
+			}
+		}
+		return change, true
+
+	case "delete":
+		if ev.FullDocumentBeforeChange != nil {
+			return Change{Kind: ChangeDeregister, Instance: *ev.FullDocumentBeforeChange}, true
+		}
+		var key docID
+		if err := ev.DocumentKey.ID.Unmarshal(&key); err != nil || key.ServiceName == "" {
+			// Registered before _id carried the instance key
+			return Change{}, false
+		}
+		inst := models.Instance{ServiceName: key.ServiceName, ID: key.ID}
+		return Change{Kind: ChangeDeregister, Instance: inst}, true
+	}
+	return Change{}, false
+}
+
+// maintenanceOnly reports whether an update only set or removed the
+// instance's maintenance
+func (ev changeEvent) maintenanceOnly() bool {
+	desc := ev.UpdateDescription
+	if ev.OperationType != "update" || len(desc.UpdatedFields)+len(desc.RemovedFields) == 0 {
+		return false
+	}
+	for field := range desc.UpdatedFields {
+		if field != "maintenance" && !strings.HasPrefix(field, "maintenance.") {
+			return false
+		}
+	}
+	for _, field := range desc.RemovedFields {
+		if field != "maintenance" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"testing"
+
+	"github.com/spidey52/service-discovery/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestChangeEventKind(t *testing.T) {
+	inst := &models.Instance{ServiceName: "order-service", ID: "order-1"}
+	key := func(v interface{}) bson.RawValue {
+		t, data, _ := bson.MarshalValue(v)
+		return bson.RawValue{Type: t, Value: data}
+	}
+
+	tests := []struct {
+		name   string
+		event  changeEvent
+		want   ChangeKind
+		wantOK bool
+	}{
+		{
+			name:   "insert",
+			event:  changeEvent{OperationType: "insert", FullDocument: inst},
+			want:   ChangeRegister,
+			wantOK: true,
+		},
+		{
+			name: "heartbeat",
+			event: func() changeEvent {
+				ev := changeEvent{OperationType: "update", FullDocument: inst}
+				ev.UpdateDescription.UpdatedFields = bson.M{"lastHeartbeat": 1}
+				return ev
+			}(),
+			want:   ChangeHeartbeat,
+			wantOK: true,
+		},
+		{
+			name: "lease expired",
+			event: func() changeEvent {
+				ev := changeEvent{OperationType: "update", FullDocument: &models.Instance{
+					ServiceName: "order-service",
+					ID:          "order-1",
+					Health:      models.HealthCritical,
+				}}
+				ev.UpdateDescription.UpdatedFields = bson.M{"health": models.HealthCritical}
+				return ev
+			}(),
+			want:   ChangeExpire,
+			wantOK: true,
+		},
+		{
+			name: "health transition",
+			event: func() changeEvent {
+				ev := changeEvent{OperationType: "update", FullDocument: inst}
+				ev.UpdateDescription.UpdatedFields = bson.M{"lastHeartbeat": 1, "health": models.HealthWarning}
+				return ev
+			}(),
+			want:   ChangeHealth,
+			wantOK: true,
+		},
+		{
+			name: "maintenance ended",
+			event: func() changeEvent {
+				ev := changeEvent{OperationType: "update", FullDocument: inst}
+				ev.UpdateDescription.RemovedFields = []string{"maintenance"}
+				return ev
+			}(),
+			want:   ChangeMaintenance,
+			wantOK: true,
+		},
+		{
+			name: "metadata update",
+			event: func() changeEvent {
+				ev := changeEvent{OperationType: "update", FullDocument: inst}
+				ev.UpdateDescription.UpdatedFields = bson.M{"lastHeartbeat": 1, "metadata.version": 2}
+				return ev
+			}(),
+			want:   ChangeUpdate,
+			wantOK: true,
+		},
+		{
+			name: "delete by key",
+			event: func() changeEvent {
+				ev := changeEvent{OperationType: "delete"}
+				ev.DocumentKey.ID = key(docID{ServiceName: "order-service", ID: "order-1"})
+				return ev
+			}(),
+			want:   ChangeDeregister,
+			wantOK: true,
+		},
+		{
+			name: "delete of legacy document",
+			event: func() changeEvent {
+				ev := changeEvent{OperationType: "delete"}
+				ev.DocumentKey.ID = key("650000000000000000000000")
+				return ev
+			}(),
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			change, ok := tt.event.change()
+			if ok != tt.wantOK {
+				t.Fatalf("change() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if ok && change.Kind != tt.want {
+				t.Errorf("change() kind = %s, want %s", change.Kind, tt.want)
+			}
+			if ok && change.Instance.ID != "order-1" {
+				t.Errorf("change() instance = %+v, want order-1", change.Instance)
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package repository
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrInvalidCursor is returned by Find for a cursor it did not issue for
+// the same sort order
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortKey orders the instances returned by Find. Ties are broken by service
+// name and id, so every order is total and pages never overlap.
+type SortKey string
+
+const (
+	SortServiceName   SortKey = "serviceName"
+	SortLastHeartbeat SortKey = "lastHeartbeat"
+	SortVersion       SortKey = "version"
+)
+
+// Valid reports whether k is a known sort key
+func (k SortKey) Valid() bool {
+	return k == SortServiceName || k == SortLastHeartbeat || k == SortVersion
+}
+
+// field returns the stored field k sorts on, empty when the tie breakers
+// alone define the order
+func (k SortKey) field() string {
+	switch k {
+	case SortLastHeartbeat:
+		return "lastHeartbeat"
+	case SortVersion:
+		return "metadata.version"
+	}
+	return ""
+}
+
+// Page shapes the result of Find: its order, the window following a cursor
+// and the fields to load. The zero Page returns every instance ordered by
+// service name and id.
+type Page struct {
+	Sort  SortKey // empty for SortServiceName
+	Desc  bool
+	Limit int    // 0 for no limit
+	After string // cursor of the last instance of the previous page, see Cursor
+	// Fields lists the fields backends need to load, as dotted paths of
+	// the stored document; empty for all. Service name, id and the sort
+	// field are always loaded, and so is the host along with endpoints,
+	// whose addresses are built from it. Backends holding instances in
+	// memory ignore it.
+	Fields []string
+}
+
+func (p Page) key() SortKey {
+	if p.Sort == "" {
+		return SortServiceName
+	}
+	return p.Sort
+}
+
+// projection returns the fields MongoDB has to load, nil for all
+func (p Page) projection() bson.M {
+	if len(p.Fields) == 0 {
+		return nil
+	}
+	proj := bson.M{"_id": 0, "serviceName": 1, "id": 1}
+	if field := p.key().field(); field != "" {
+		proj[field] = 1
+	}
+	for _, field := range p.Fields {
+		// A parent and its child cannot both be projected
+		if !slices.ContainsFunc(p.Fields, func(parent string) bool { return strings.HasPrefix(field, parent+".") }) {
+			proj[field] = 1
+		}
+		if field == "endpoints" || strings.HasPrefix(field, "endpoints.") {
+			proj["host"] = 1
+		}
+	}
+	return proj
+}
+
+// position is an instance's place in a sort order, the content of a cursor
+type position struct {
+	Sort    SortKey   `json:"k"`
+	Desc    bool      `json:"d,omitempty"`
+	Time    time.Time `json:"t,omitzero"`
+	Version int       `json:"v,omitempty"`
+	Service string    `json:"s"`
+	ID      string    `json:"i"`
+}
+
+func positionOf(inst models.Instance, p Page) position {
+	pos := position{Sort: p.key(), Desc: p.Desc, Service: inst.ServiceName, ID: inst.ID}
+	switch pos.Sort {
+	case SortLastHeartbeat:
+		pos.Time = inst.LastHeartbeat
+	case SortVersion:
+		pos.Version = inst.Metadata.Version
+	}
+	return pos
+}
+
+// compare orders positions of the same sort key, ascending
+func (a position) compare(b position) int {
+	return cmp.Or(
+		a.Time.Compare(b.Time),
+		cmp.Compare(a.Version, b.Version),
+		cmp.Compare(a.Service, b.Service),
+		cmp.Compare(a.ID, b.ID),
+	)
+}
+
+// Cursor returns the cursor resuming p after inst, the last instance of a page
+func Cursor(inst models.Instance, p Page) string {
+	raw, _ := json.Marshal(positionOf(inst, p))
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+// after decodes p.After, which must have been issued for p's sort order
+func (p Page) after() (*position, error) {
+	if p.After == "" {
+		return nil, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(p.After)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var pos position
+	if err := json.Unmarshal(raw, &pos); err != nil || pos.Sort != p.key() || pos.Desc != p.Desc {
+		return nil, ErrInvalidCursor
+	}
+	return &pos, nil
+}
+
+// apply orders instances and cuts out the page, for backends holding
+// instances in memory
+func (p Page) apply(instances []models.Instance) ([]models.Instance, error) {
+	after, err := p.after()
+	if err != nil {
+		return nil, err
+	}
+	order := func(a, b position) int {
+		if p.Desc {
+			return b.compare(a)
+		}
+		return a.compare(b)
+	}
+	slices.SortFunc(instances, func(a, b models.Instance) int {
+		return order(positionOf(a, p), positionOf(b, p))
+	})
+	if after != nil {
+		start, _ := slices.BinarySearchFunc(instances, *after, func(inst models.Instance, pos position) int {
+			if c := order(positionOf(inst, p), pos); c != 0 {
+				return c
+			}
+			return -1 // the cursor's own instance precedes the page
+		})
+		instances = instances[start:]
+	}
+	if p.Limit > 0 && len(instances) > p.Limit {
+		instances = instances[:p.Limit]
+	}
+	return instances, nil
+}
+
+// sort returns the MongoDB sort document of p
+func (p Page) sort() bson.D {
+	dir := 1
+	if p.Desc {
+		dir = -1
+	}
+	var sort bson.D
+	if field := p.key().field(); field != "" {
+		sort = append(sort, bson.E{Key: field, Value: dir})
+	}
+	return append(sort, bson.E{Key: "serviceName", Value: dir}, bson.E{Key: "id", Value: dir})
+}
+
+// seek returns the MongoDB filter selecting the documents following pos
+// in p's order
+func (p Page) seek(pos position) bson.M {
+	op := "$gt"
+	if p.Desc {
+		op = "$lt"
+	}
+	type kv struct {
+		field string
+		value any
+	}
+	keys := []kv{{"serviceName", pos.Service}, {"id", pos.ID}}
+	switch pos.Sort {
+	case SortLastHeartbeat:
+		keys = append([]kv{{"lastHeartbeat", pos.Time}}, keys...)
+	case SortVersion:
+		keys = append([]kv{{"metadata.version", pos.Version}}, keys...)
+	}
+	// (a > x) or (a = x and b > y) or (a = x and b = y and c > z)
+	var or bson.A
+	for i, k := range keys {
+		clause := bson.M{k.field: bson.M{op: k.value}}
+		for _, prev := range keys[:i] {
+			clause[prev.field] = prev.value
+		}
+		or = append(or, clause)
+	}
+	return bson.M{"$or": or}
+}
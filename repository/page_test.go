@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestPageSeek(t *testing.T) {
+	page := Page{Sort: SortVersion, Desc: true}
+	pos := positionOf(testInstance("order-service", "order-1", "us-east", 3), page)
+
+	want := bson.M{"$or": bson.A{
+		bson.M{"metadata.version": bson.M{"$lt": 3}},
+		bson.M{"metadata.version": 3, "serviceName": bson.M{"$lt": "order-service"}},
+		bson.M{"metadata.version": 3, "serviceName": "order-service", "id": bson.M{"$lt": "order-1"}},
+	}}
+	if got := page.seek(pos); !reflect.DeepEqual(got, want) {
+		t.Errorf("seek() = %v, want %v", got, want)
+	}
+	wantSort := bson.D{{Key: "metadata.version", Value: -1}, {Key: "serviceName", Value: -1}, {Key: "id", Value: -1}}
+	if got := page.sort(); !reflect.DeepEqual(got, wantSort) {
+		t.Errorf("sort() = %v, want %v", got, wantSort)
+	}
+}
+
+func TestPageProjection(t *testing.T) {
+	page := Page{Sort: SortLastHeartbeat, Fields: []string{"metadata", "metadata.region", "port"}}
+	want := bson.M{"_id": 0, "serviceName": 1, "id": 1, "lastHeartbeat": 1, "metadata": 1, "port": 1}
+	if got := page.projection(); !reflect.DeepEqual(got, want) {
+		t.Errorf("projection() = %v, want %v", got, want)
+	}
+	page = Page{Fields: []string{"endpoints"}}
+	want = bson.M{"_id": 0, "serviceName": 1, "id": 1, "host": 1, "endpoints": 1}
+	if got := page.projection(); !reflect.DeepEqual(got, want) {
+		t.Errorf("projection() of endpoints = %v, want the host as well", got)
+	}
+	if got := (Page{}).projection(); got != nil {
+		t.Errorf("projection() without fields = %v, want nil", got)
+	}
+}
@@ -0,0 +1,125 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/spidey52/service-discovery/models"
+)
+
+var (
+	// ErrNotFound is returned when an operation targets an instance that is not registered
+	ErrNotFound = errors.New("instance not found")
+	// ErrExpired is returned when an operation targets an instance recently
+	// removed because its lease ran out; it has to register again
+	ErrExpired = errors.New("instance expired, re-register")
+	// ErrServiceNotFound is returned when no instance of a service is registered
+	ErrServiceNotFound = errors.New("service not found")
+)
+
+// Registry is the storage backend the handlers depend on. Each instance
+// carries its own lease (see models.Instance.TTL); the ttl taken by Find,
+// MarkCritical and CleanupDead is the lease of instances stored without one.
+// Heartbeats renew the lease and report the instance's health.
+//
+// An instance whose lease runs out is first marked CRITICAL and only
+// removed once a deregistration timeout has passed. Its removal leaves a
+// tombstone for a while, so a late heartbeat gets ErrExpired rather than
+// ErrNotFound.
+//
+// Instances in maintenance (see models.Maintenance) are left out of default
+// lookups. Maintenance is set by operators and kept when an instance
+// registers again.
+type Registry interface {
+	// Register upserts an instance and marks it as alive. It returns the
+	// stored instance and the instance it replaced, nil when it was newly
+	// created.
+	Register(ctx context.Context, inst models.Instance) (models.Instance, *models.Instance, error)
+	// Get returns a registered instance, whatever its health
+	Get(ctx context.Context, serviceName, id string) (models.Instance, error)
+	// Update replaces the registration of an existing instance with inst
+	// (see models.Instance.Amend) and returns the stored instance and the
+	// instance before the update. Unlike Register it keeps the health and
+	// the last heartbeat; the lease ends after the new TTL.
+	Update(ctx context.Context, inst models.Instance) (models.Instance, models.Instance, error)
+	// UpdateHeartbeat renews the lease of a registered instance and records
+	// the health it reports. It returns the stored instance and the
+	// instance before the heartbeat.
+	UpdateHeartbeat(ctx context.Context, hb models.Heartbeat) (models.Instance, models.Instance, error)
+	// ReportHealth records the result of a round of the instance's
+	// server-side checks (see models.Instance.ReportChecks), apart from the
+	// health it reports itself. A passing round renews the lease only of an
+	// instance that has not sent a heartbeat since it registered. It
+	// returns the stored instance and the instance before the report.
+	ReportHealth(ctx context.Context, hb models.Heartbeat) (models.Instance, models.Instance, error)
+	// SetMaintenance puts an instance, or every instance of the service when
+	// id is empty, into maintenance m, or takes them out of it when m is
+	// nil. It returns the changed instances, or ErrNotFound if none matched.
+	SetMaintenance(ctx context.Context, serviceName, id string, m *models.Maintenance) ([]models.Instance, error)
+	// EndMaintenance takes the instances whose maintenance expired out of
+	// it and returns them
+	EndMaintenance(ctx context.Context) ([]models.Instance, error)
+	// Deregister removes an instance and returns it as it was last stored
+	Deregister(ctx context.Context, serviceName, id string) (models.Instance, error)
+	// Find returns the instances selected by filter. Unless the filter
+	// includes unhealthy instances, only those whose lease is still running
+	// and that are not CRITICAL are returned; unless it includes instances
+	// in maintenance, those are left out too.
+	Find(ctx context.Context, filter Filter, ttl time.Duration) ([]models.Instance, error)
+	// Services summarizes every registered service, ordered by name.
+	// Instances whose lease ran out count as CRITICAL.
+	Services(ctx context.Context, ttl time.Duration) ([]models.ServiceSummary, error)
+	// Service returns the detailed summary of one service, or
+	// ErrServiceNotFound if none of its instances is registered
+	Service(ctx context.Context, name string, ttl time.Duration) (models.ServiceDetail, error)
+	// MarkCritical marks the instances whose lease has run out as CRITICAL
+	// and returns those it changed
+	MarkCritical(ctx context.Context, ttl time.Duration) ([]models.Instance, error)
+	// CleanupDead removes the instances whose lease ran out more than
+	// timeout ago and returns them. Each leaves a tombstone lasting
+	// tombstoneTTL; older tombstones are dropped.
+	CleanupDead(ctx context.Context, ttl, timeout, tombstoneTTL time.Duration) ([]models.Instance, error)
+}
+
+// ChangeKind describes a registry change observed by a Watcher
+type ChangeKind string
+
+const (
+	ChangeRegister    ChangeKind = "register"
+	ChangeUpdate      ChangeKind = "update"
+	ChangeHeartbeat   ChangeKind = "heartbeat"
+	ChangeHealth      ChangeKind = "health"      // a heartbeat or check changed the instance's health
+	ChangeExpire      ChangeKind = "expire"      // the lease ran out and the instance was marked CRITICAL
+	ChangeMaintenance ChangeKind = "maintenance" // the instance entered or left maintenance
+	ChangeDeregister  ChangeKind = "deregister"
+)
+
+// Change is a single write to the registry, whichever server performed it
+type Change struct {
+	Kind     ChangeKind
+	Instance models.Instance
+	// PreviousHealth is the health before a ChangeHealth, when known
+	PreviousHealth string
+	// Previous is the instance before an update, heartbeat or health
+	// change, when known
+	Previous *models.Instance
+}
+
+// Watcher is implemented by backends shared between several servers that
+// can stream every write made to the registry
+type Watcher interface {
+	// Watch calls fn for every change until ctx is cancelled. It calls live
+	// with true once the stream is open and with false whenever it is
+	// interrupted, e.g. when the backend cannot stream changes, until it
+	// is open again.
+	Watch(ctx context.Context, fn func(Change), live func(bool)) error
+}
+
+var (
+	_ Watcher = (*MongoRepo)(nil)
+
+	_ Registry = (*MongoRepo)(nil)
+	_ Registry = (*MemoryRepo)(nil)
+	_ Registry = (*FileRepo)(nil)
+)
@@ -2,13 +2,21 @@ package servicediscovery
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 )
 
+// ErrInstanceExpired is returned by Heartbeat when the server removed the
+// instance because its lease ran out. The instance must register again.
+var ErrInstanceExpired = errors.New("instance expired, re-register")
+
 // Client represents a Service Discovery client
 type Client struct {
 	httpClient         *resty.Client
@@ -19,6 +27,8 @@ type Client struct {
 	heartbeatMutex     sync.RWMutex
 	currentServiceName string
 	currentInstanceID  string
+	registeredService  string
+	registeredID       string
 }
 
 // NewClient creates a new Service Discovery client
@@ -63,16 +73,55 @@ func (c *Client) Register(ctx context.Context, instance Instance) error {
 		return fmt.Errorf("register failed with status %d: %s", resp.StatusCode(), resp.String())
 	}
 
+	c.heartbeatMutex.Lock()
+	c.registeredService = instance.ServiceName
+	c.registeredID = instance.ID
+	c.heartbeatMutex.Unlock()
+
+	return nil
+}
+
+// Deregister removes a service instance from the discovery server
+func (c *Client) Deregister(ctx context.Context, serviceName, id string) error {
+	req := DeregisterRequest{
+		ServiceName: serviceName,
+		ID:          id,
+	}
+
+	resp, err := c.httpClient.R().
+		SetContext(ctx).
+		SetBody(req).
+		Post("/deregister")
+
+	if err != nil {
+		return fmt.Errorf("deregister request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("deregister failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	c.heartbeatMutex.Lock()
+	if c.registeredService == serviceName && c.registeredID == id {
+		c.registeredService = ""
+		c.registeredID = ""
+	}
+	c.heartbeatMutex.Unlock()
+
 	return nil
 }
 
 // Heartbeat sends a heartbeat to keep the service instance alive
 func (c *Client) Heartbeat(ctx context.Context, serviceName, id string) error {
-	req := HeartbeatRequest{
+	return c.ReportHealth(ctx, HeartbeatRequest{
 		ServiceName: serviceName,
 		ID:          id,
-	}
+	})
+}
 
+// ReportHealth sends a heartbeat carrying the instance's health status, an
+// optional message and load indicators
+func (c *Client) ReportHealth(ctx context.Context, req HeartbeatRequest) error {
 	resp, err := c.httpClient.R().
 		SetContext(ctx).
 		SetBody(req).
@@ -82,6 +131,10 @@ func (c *Client) Heartbeat(ctx context.Context, serviceName, id string) error {
 		return fmt.Errorf("heartbeat request failed: %w", err)
 	}
 
+	if resp.StatusCode() == 410 {
+		return ErrInstanceExpired
+	}
+
 	if resp.StatusCode() != 200 {
 		return fmt.Errorf("heartbeat failed with status %d: %s", resp.StatusCode(), resp.String())
 	}
@@ -163,15 +216,284 @@ func (c *Client) StopHeartbeat() {
 	c.heartbeatMutex.Unlock()
 }
 
+// Services lists every registered service with its instance counts
+func (c *Client) Services(ctx context.Context) ([]ServiceSummary, error) {
+	var services []ServiceSummary
+	resp, err := c.httpClient.R().
+		SetContext(ctx).
+		SetResult(&services).
+		Get("/services")
+
+	if err != nil {
+		return nil, fmt.Errorf("services request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("services failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	return services, nil
+}
+
+// Service returns the detailed summary of one service
+func (c *Client) Service(ctx context.Context, name string) (*ServiceDetail, error) {
+	var service ServiceDetail
+	resp, err := c.httpClient.R().
+		SetContext(ctx).
+		SetResult(&service).
+		Get("/services/" + url.PathEscape(name))
+
+	if err != nil {
+		return nil, fmt.Errorf("service request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("service failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	return &service, nil
+}
+
+// GetInstance returns a registered instance, whatever its health
+func (c *Client) GetInstance(ctx context.Context, serviceName, id string) (*Instance, error) {
+	return c.instance(ctx, c.httpClient.R(), "GET", serviceName, id)
+}
+
+// UpdateInstance replaces the registration of an existing instance, keeping
+// its health and lease
+func (c *Client) UpdateInstance(ctx context.Context, instance Instance) (*Instance, error) {
+	if err := c.validateInstance(instance); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+	return c.instance(ctx, c.httpClient.R().SetBody(instance), "PUT", instance.ServiceName, instance.ID)
+}
+
+// PatchInstance applies a JSON merge patch to a registered instance, e.g.
+// {"metadata": {"developer": "jane"}}; nil values remove a field
+func (c *Client) PatchInstance(ctx context.Context, serviceName, id string, patch map[string]interface{}) (*Instance, error) {
+	req := c.httpClient.R().
+		SetHeader("Content-Type", "application/merge-patch+json").
+		SetBody(patch)
+	return c.instance(ctx, req, "PATCH", serviceName, id)
+}
+
+func (c *Client) instance(ctx context.Context, req *resty.Request, method, serviceName, id string) (*Instance, error) {
+	var instance Instance
+	resp, err := req.
+		SetContext(ctx).
+		SetResult(&instance).
+		Execute(method, "/services/"+url.PathEscape(serviceName)+"/instances/"+url.PathEscape(id))
+
+	if err != nil {
+		return nil, fmt.Errorf("instance request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("instance request failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	return &instance, nil
+}
+
+// SetMaintenance puts an instance, or every registered instance of the
+// service when id is empty, into maintenance and returns the changed
+// instances. Instances in maintenance are left out of default lookups.
+func (c *Client) SetMaintenance(ctx context.Context, serviceName, id string, req MaintenanceRequest) ([]Instance, error) {
+	if err := validateMaintenance(req); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+	return c.maintenance(ctx, serviceName, id, c.httpClient.R().SetBody(req), "PUT")
+}
+
+// Drain puts an instance, or the whole service when id is empty, into the
+// draining state
+func (c *Client) Drain(ctx context.Context, serviceName, id, reason string) ([]Instance, error) {
+	return c.SetMaintenance(ctx, serviceName, id, MaintenanceRequest{State: StateDraining, Reason: reason})
+}
+
+// ClearMaintenance takes an instance, or every instance of the service when
+// id is empty, out of maintenance and returns the changed instances
+func (c *Client) ClearMaintenance(ctx context.Context, serviceName, id string) ([]Instance, error) {
+	return c.maintenance(ctx, serviceName, id, c.httpClient.R(), "DELETE")
+}
+
+func (c *Client) maintenance(ctx context.Context, serviceName, id string, req *resty.Request, method string) ([]Instance, error) {
+	path := "/services/" + url.PathEscape(serviceName) + "/maintenance"
+	var instances []Instance
+	var instance Instance
+	if id != "" {
+		path = "/services/" + url.PathEscape(serviceName) + "/instances/" + url.PathEscape(id) + "/maintenance"
+		req.SetResult(&instance)
+	} else {
+		req.SetResult(&instances)
+	}
+
+	resp, err := req.SetContext(ctx).Execute(method, path)
+	if err != nil {
+		return nil, fmt.Errorf("maintenance request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, fmt.Errorf("maintenance failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	if id != "" {
+		instances = []Instance{instance}
+	}
+	return instances, nil
+}
+
 // Lookup finds service instances matching the filter criteria
 func (c *Client) Lookup(ctx context.Context, filter LookupFilter) ([]Instance, error) {
+	instances, _, err := c.LookupPage(ctx, filter)
+	return instances, err
+}
+
+// LookupAddresses finds the instances matching the filter that expose the
+// named endpoint and returns the endpoint's address on each of them. The
+// empty name stands for the instances' primary host and port.
+func (c *Client) LookupAddresses(ctx context.Context, filter LookupFilter, endpoint string) ([]string, error) {
+	filter.Endpoint = endpoint
+	instances, err := c.Lookup(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	addresses := make([]string, 0, len(instances))
+	for _, inst := range instances {
+		if addr, ok := inst.Address(endpoint); ok {
+			addresses = append(addresses, addr)
+		}
+	}
+	return addresses, nil
+}
+
+// LookupPage finds a page of service instances matching the filter criteria
+// and returns the cursor of the next page, empty after the last one
+func (c *Client) LookupPage(ctx context.Context, filter LookupFilter) ([]Instance, string, error) {
 	req := c.httpClient.R().SetContext(ctx)
+	setFilterParams(req, filter)
+
+	var instances []Instance
+	resp, err := req.SetResult(&instances).Get("/lookup")
+
+	if err != nil {
+		return nil, "", fmt.Errorf("lookup request failed: %w", err)
+	}
+
+	if resp.StatusCode() != 200 {
+		return nil, "", fmt.Errorf("lookup failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+
+	return instances, resp.Header().Get("X-Next-Cursor"), nil
+}
+
+// Resolve picks one instance matching the filter using the request's
+// balancing strategy
+func (c *Client) Resolve(ctx context.Context, filter LookupFilter, req ResolveRequest) (*Instance, error) {
+	r := c.httpClient.R().SetContext(ctx)
+	setResolveParams(r, filter, req)
+
+	var instance Instance
+	if err := c.resolve(r.SetResult(&instance)); err != nil {
+		return nil, err
+	}
+	return &instance, nil
+}
+
+// ResolveN picks up to n distinct instances matching the filter, in order
+// of preference
+func (c *Client) ResolveN(ctx context.Context, filter LookupFilter, req ResolveRequest, n int) ([]Instance, error) {
+	r := c.httpClient.R().SetContext(ctx)
+	setResolveParams(r, filter, req)
+	r.SetQueryParam("count", strconv.Itoa(n))
+
+	var instances []Instance
+	if err := c.resolve(r.SetResult(&instances)); err != nil {
+		return nil, err
+	}
+	return instances, nil
+}
+
+func (c *Client) resolve(req *resty.Request) error {
+	resp, err := req.Get("/resolve")
+	if err != nil {
+		return fmt.Errorf("resolve request failed: %w", err)
+	}
+	if resp.StatusCode() != 200 {
+		return fmt.Errorf("resolve failed with status %d: %s", resp.StatusCode(), resp.String())
+	}
+	return nil
+}
 
+func setResolveParams(r *resty.Request, filter LookupFilter, req ResolveRequest) {
+	setFilterParams(r, filter)
+	if req.Strategy != "" {
+		r.SetQueryParam("strategy", string(req.Strategy))
+	}
+	if req.Key != "" {
+		r.SetQueryParam("key", req.Key)
+	}
+	if req.Session != "" {
+		r.SetQueryParam("session", req.Session)
+	}
+}
+
+// setFilterParams adds the filter's query parameters to req
+func setFilterParams(req *resty.Request, filter LookupFilter) {
 	// Add service filter
 	if filter.Service != "" {
 		req.SetQueryParam("service", filter.Service)
 	}
 
+	if len(filter.Health) > 0 {
+		req.SetQueryParam("health", strings.Join(filter.Health, ","))
+	}
+	for name, limit := range filter.MaxLoad {
+		req.SetQueryParam("maxLoad."+name, strconv.FormatFloat(limit, 'f', -1, 64))
+	}
+	if filter.IncludeUnhealthy {
+		req.SetQueryParam("includeUnhealthy", "true")
+	}
+	if filter.IncludeMaintenance {
+		req.SetQueryParam("includeMaintenance", "true")
+	}
+	for key, value := range filter.Labels {
+		req.SetQueryParam("label."+key, value)
+	}
+	if len(filter.Tags) > 0 {
+		req.SetQueryParam("tag", strings.Join(filter.Tags, ","))
+	}
+	if filter.Endpoint != "" {
+		req.SetQueryParam("endpoint", filter.Endpoint)
+	}
+	if filter.Filter != "" {
+		req.SetQueryParam("filter", filter.Filter)
+	}
+	if filter.Limit > 0 {
+		req.SetQueryParam("limit", strconv.Itoa(filter.Limit))
+	}
+	if filter.Cursor != "" {
+		req.SetQueryParam("cursor", filter.Cursor)
+	}
+	if filter.Sort != "" {
+		req.SetQueryParam("sort", filter.Sort)
+	}
+	if len(filter.Fields) > 0 {
+		req.SetQueryParam("fields", strings.Join(filter.Fields, ","))
+	}
+	if filter.PreferRegion != "" {
+		req.SetQueryParam("preferRegion", filter.PreferRegion)
+	}
+	if filter.PreferZone != "" {
+		req.SetQueryParam("preferZone", filter.PreferZone)
+	}
+	if filter.MinHealthy > 0 {
+		req.SetQueryParam("minHealthy", strconv.Itoa(filter.MinHealthy))
+	}
+	if filter.MinHealthyPercent > 0 {
+		req.SetQueryParam("minHealthyPercent", strconv.Itoa(filter.MinHealthyPercent))
+	}
+
 	// Add metadata filters
 	for key, value := range filter.Metadata {
 		switch v := value.(type) {
@@ -183,19 +505,6 @@ func (c *Client) Lookup(ctx context.Context, filter LookupFilter) ([]Instance, e
 			req.SetQueryParam(key, fmt.Sprintf("%t", v))
 		}
 	}
-
-	var instances []Instance
-	resp, err := req.SetResult(&instances).Get("/lookup")
-
-	if err != nil {
-		return nil, fmt.Errorf("lookup request failed: %w", err)
-	}
-
-	if resp.StatusCode() != 200 {
-		return nil, fmt.Errorf("lookup failed with status %d: %s", resp.StatusCode(), resp.String())
-	}
-
-	return instances, nil
 }
 
 // AutoRegister registers a service and starts automatic heartbeat
@@ -222,7 +531,23 @@ func (c *Client) GetHeartbeatStatus() (isRunning bool, failureCount int) {
 	return
 }
 
-// Close gracefully shuts down the client
+// Close gracefully shuts down the client, stopping the heartbeat and
+// deregistering the last registered instance
 func (c *Client) Close() {
 	c.StopHeartbeat()
+
+	c.heartbeatMutex.RLock()
+	sn := c.registeredService
+	iid := c.registeredID
+	c.heartbeatMutex.RUnlock()
+
+	if sn == "" || iid == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.Timeout)
+	defer cancel()
+	if err := c.Deregister(ctx, sn, iid); err != nil {
+		fmt.Printf("Deregister error: %v\n", err)
+	}
 }
@@ -1,6 +1,10 @@
 package servicediscovery
 
 import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -76,6 +80,60 @@ func TestValidateInstance(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "weight too large",
+			instance: Instance{
+				ServiceName: "test-service",
+				ID:          "test-001",
+				Host:        "127.0.0.1",
+				Port:        8080,
+				Mode:        EnvDev,
+				Metadata: Metadata{
+					Environment: EnvDev,
+					Region:      "us-east",
+					Version:     1,
+				},
+				Weight: 1001,
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate endpoint",
+			instance: Instance{
+				ServiceName: "test-service",
+				ID:          "test-001",
+				Host:        "127.0.0.1",
+				Port:        8080,
+				Mode:        EnvDev,
+				Metadata: Metadata{
+					Environment: EnvDev,
+					Region:      "us-east",
+					Version:     1,
+				},
+				Endpoints: []Endpoint{
+					{Name: "grpc", Port: 9090, Protocol: ProtocolGRPC},
+					{Name: "grpc", Port: 9091, Protocol: ProtocolGRPC},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "label key with a dot",
+			instance: Instance{
+				ServiceName: "test-service",
+				ID:          "test-001",
+				Host:        "127.0.0.1",
+				Port:        8080,
+				Mode:        EnvDev,
+				Metadata: Metadata{
+					Environment: EnvDev,
+					Region:      "us-east",
+					Version:     1,
+				},
+				Labels: map[string]string{"team.name": "payments"},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -147,3 +205,63 @@ func TestGetHeartbeatStatus(t *testing.T) {
 		t.Errorf("Expected failure count to be 0, got %d", failureCount)
 	}
 }
+
+func TestCloseDeregisters(t *testing.T) {
+	var deregistered DeregisterRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/deregister" {
+			_ = json.NewDecoder(r.Body).Decode(&deregistered)
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	client, _ := NewClient(DefaultConfig(server.URL))
+	instance := Instance{
+		ServiceName: "test-service",
+		ID:          "test-001",
+		Host:        "127.0.0.1",
+		Port:        8080,
+		Mode:        EnvDev,
+		Metadata: Metadata{
+			Environment: EnvDev,
+			Region:      "us-east",
+			Version:     1,
+		},
+	}
+	if err := client.Register(context.Background(), instance); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	client.Close()
+
+	if deregistered.ServiceName != "test-service" || deregistered.ID != "test-001" {
+		t.Errorf("Close() deregistered %+v, want test-service/test-001", deregistered)
+	}
+}
+
+func TestInstanceAddress(t *testing.T) {
+	inst := Instance{
+		Host: "10.0.0.1",
+		Port: 8080,
+		Endpoints: []Endpoint{
+			{Name: "grpc", Port: 9090, Protocol: ProtocolGRPC},
+			{Name: "metrics", Port: 9100, Protocol: ProtocolHTTP, Path: "/metrics"},
+		},
+	}
+
+	tests := map[string]string{
+		"":        "10.0.0.1:8080",
+		"grpc":    "10.0.0.1:9090",
+		"metrics": "http://10.0.0.1:9100/metrics",
+	}
+	for name, want := range tests {
+		if got, ok := inst.Address(name); !ok || got != want {
+			t.Errorf("Address(%q) = %q, %v, want %q", name, got, ok, want)
+		}
+	}
+	if _, ok := inst.Address("admin"); ok {
+		t.Errorf("Address(admin) found an endpoint the instance does not expose")
+	}
+}
@@ -2,6 +2,8 @@
 package servicediscovery
 
 import (
+	"net"
+	"strconv"
 	"time"
 )
 
@@ -18,6 +20,7 @@ const (
 type Metadata struct {
 	Environment  Environment `json:"environment" validate:"required,oneof=dev staging prod"`
 	Region       string      `json:"region" validate:"required"`
+	Zone         string      `json:"zone,omitempty"` // availability zone within the region
 	Version      int         `json:"version" validate:"required,min=0"`
 	Developer    string      `json:"developer,omitempty"`
 	Experimental bool        `json:"experimental,omitempty"`
@@ -25,24 +28,213 @@ type Metadata struct {
 
 // Instance represents a service instance
 type Instance struct {
-	ServiceName   string      `json:"serviceName" validate:"required"`
-	ID            string      `json:"id" validate:"required"`
-	Host          string      `json:"host" validate:"required"`
-	Port          int         `json:"port" validate:"required,min=1,max=65535"`
-	Mode          Environment `json:"mode" validate:"required,oneof=dev staging prod"`
-	Metadata      Metadata    `json:"metadata" validate:"required"`
-	Health        string      `json:"health,omitempty"`
-	LastHeartbeat time.Time   `json:"lastHeartbeat,omitempty"`
+	ServiceName    string             `json:"serviceName" validate:"required"`
+	ID             string             `json:"id" validate:"required"`
+	Host           string             `json:"host" validate:"required"`
+	Port           int                `json:"port" validate:"required,min=1,max=65535"`
+	Mode           Environment        `json:"mode" validate:"required,oneof=dev staging prod"`
+	Metadata       Metadata           `json:"metadata" validate:"required"`
+	Labels         map[string]string  `json:"labels,omitempty" validate:"max=32"` // free-form key/value pairs
+	Tags           []string           `json:"tags,omitempty" validate:"max=32"`
+	Endpoints      []Endpoint         `json:"endpoints,omitempty" validate:"max=16,dive"` // besides Host and Port
+	TTL            int                `json:"ttl,omitempty" validate:"min=0"`             // lease in seconds, 0 for the server default
+	Weight         int                `json:"weight,omitempty" validate:"min=0,max=1000"` // share of weighted and hash resolves, 0 for 1
+	Checks         []Check            `json:"checks,omitempty" validate:"max=4,dive"`
+	Health         string             `json:"health,omitempty"` // worse of ReportedHealth and CheckHealth
+	ReportedHealth string             `json:"reportedHealth,omitempty"`
+	Output         string             `json:"output,omitempty"`
+	Load           map[string]float64 `json:"load,omitempty"`
+	CheckHealth    string             `json:"checkHealth,omitempty"` // of the checks' latest round
+	CheckOutput    string             `json:"checkOutput,omitempty"`
+	Maintenance    *Maintenance       `json:"maintenance,omitempty"`
+	LastHeartbeat  time.Time          `json:"lastHeartbeat,omitempty"`
+	ExpiresAt      time.Time          `json:"expiresAt,omitempty"`
+}
+
+// Address returns where the named endpoint of the instance is reached, a URL
+// for http and https endpoints and host:port otherwise. The empty name is
+// the primary Host and Port.
+func (i Instance) Address(name string) (string, bool) {
+	if name == "" {
+		return net.JoinHostPort(i.Host, strconv.Itoa(i.Port)), true
+	}
+	for _, e := range i.Endpoints {
+		if e.Name != name {
+			continue
+		}
+		if e.Address != "" {
+			return e.Address, true
+		}
+		hostPort := net.JoinHostPort(i.Host, strconv.Itoa(e.Port))
+		if e.Protocol == ProtocolHTTP || e.Protocol == ProtocolHTTPS {
+			return string(e.Protocol) + "://" + hostPort + e.Path, true
+		}
+		return hostPort, true
+	}
+	return "", false
+}
+
+// Protocol is the protocol an endpoint speaks
+type Protocol string
+
+const (
+	ProtocolHTTP  Protocol = "http"
+	ProtocolHTTPS Protocol = "https"
+	ProtocolGRPC  Protocol = "grpc"
+	ProtocolTCP   Protocol = "tcp"
+)
+
+// Endpoint is a named port the instance serves besides its primary Port
+type Endpoint struct {
+	Name     string   `json:"name" validate:"required,max=32"`
+	Port     int      `json:"port" validate:"required,min=1,max=65535"`
+	Protocol Protocol `json:"protocol" validate:"required,oneof=http https grpc tcp"`
+	Path     string   `json:"path,omitempty"`    // http and https
+	Address  string   `json:"address,omitempty"` // filled in by the server on lookups
+}
+
+// Health states reported by the server
+const (
+	HealthUp       = "UP"
+	HealthWarning  = "WARNING"
+	HealthCritical = "CRITICAL"
+)
+
+// HealthStatus is the status an instance reports with its heartbeats
+type HealthStatus string
+
+const (
+	StatusPassing  HealthStatus = "passing"
+	StatusWarning  HealthStatus = "warning"
+	StatusCritical HealthStatus = "critical"
+)
+
+// CheckType is the kind of a server-side health check
+type CheckType string
+
+const (
+	CheckHTTP CheckType = "http"
+	CheckTCP  CheckType = "tcp"
+	CheckGRPC CheckType = "grpc"
+)
+
+// Check is a health check the server runs against the instance. Until the
+// instance sends its first heartbeat, passing checks renew its lease like
+// one.
+type Check struct {
+	Type     CheckType `json:"type" validate:"required,oneof=http tcp grpc"`
+	Port     int       `json:"port,omitempty" validate:"min=0,max=65535"` // 0 for the instance's port
+	Path     string    `json:"path,omitempty"`                            // http: request path
+	Status   int       `json:"status,omitempty"`                          // http: expected status, 0 for any 2xx
+	Service  string    `json:"service,omitempty"`                         // grpc: service to check
+	Interval int       `json:"interval,omitempty" validate:"min=0"`       // seconds between runs, 0 for 10
+	Timeout  int       `json:"timeout,omitempty" validate:"min=0"`        // seconds, 0 for 2
+}
+
+// MaintenanceState is the kind of maintenance an instance is in
+type MaintenanceState string
+
+const (
+	// StateMaintenance takes the instance out of lookups entirely
+	StateMaintenance MaintenanceState = "maintenance"
+	// StateDraining takes the instance out of lookups while it finishes
+	// the work it already has
+	StateDraining MaintenanceState = "draining"
+)
+
+// Maintenance is set by operators on instances left out of default lookups
+type Maintenance struct {
+	State  MaintenanceState `json:"state"`
+	Reason string           `json:"reason,omitempty"`
+	Since  time.Time        `json:"since"`
+	Until  time.Time        `json:"until,omitempty"` // zero until cleared
+}
+
+// MaintenanceRequest puts instances into maintenance. It ends by itself at
+// Until, or after Duration, when either is set.
+type MaintenanceRequest struct {
+	State    MaintenanceState `json:"state" validate:"required,oneof=maintenance draining"`
+	Reason   string           `json:"reason,omitempty" validate:"max=256"`
+	Until    *time.Time       `json:"until,omitempty"`
+	Duration string           `json:"duration,omitempty"` // e.g. "30m"
 }
 
 // LookupFilter contains filters for service lookup
 type LookupFilter struct {
-	Service  string                 `json:"service,omitempty"`
-	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Service            string                 `json:"service,omitempty"`
+	Metadata           map[string]interface{} `json:"metadata,omitempty"`
+	Health             []string               `json:"health,omitempty"`             // health states to select
+	MaxLoad            map[string]float64     `json:"maxLoad,omitempty"`            // upper bounds of load indicators
+	IncludeUnhealthy   bool                   `json:"includeUnhealthy,omitempty"`   // include CRITICAL instances
+	IncludeMaintenance bool                   `json:"includeMaintenance,omitempty"` // include instances in maintenance
+	Labels             map[string]string      `json:"labels,omitempty"`             // label values to select
+	Tags               []string               `json:"tags,omitempty"`               // tags an instance must all carry
+	Endpoint           string                 `json:"endpoint,omitempty"`           // name of an endpoint to expose
+	Filter             string                 `json:"filter,omitempty"`             // filter expression, e.g. "version >= 2 and region in (us-east, eu-west)"
+	Limit              int                    `json:"limit,omitempty"`              // page size, see LookupPage
+	Cursor             string                 `json:"cursor,omitempty"`             // next cursor of the previous page
+	Sort               string                 `json:"sort,omitempty"`               // serviceName, lastHeartbeat or version, - prefix for descending
+	Fields             []string               `json:"fields,omitempty"`             // fields to return besides serviceName and id
+	PreferRegion       string                 `json:"preferRegion,omitempty"`       // prefer instances in this region
+	PreferZone         string                 `json:"preferZone,omitempty"`         // prefer instances in this zone, then PreferRegion
+	MinHealthy         int                    `json:"minHealthy,omitempty"`         // healthy instances a preferred tier needs, 0 for 1
+	MinHealthyPercent  int                    `json:"minHealthyPercent,omitempty"`  // share of all healthy instances a preferred tier needs
 }
 
-// HeartbeatRequest represents a heartbeat request
+// Strategy is how Resolve chooses among matching instances
+type Strategy string
+
+const (
+	StrategyRoundRobin  Strategy = "roundRobin"
+	StrategyRandom      Strategy = "random"
+	StrategyWeighted    Strategy = "weighted"    // random, in proportion to instance weights
+	StrategyLeastRecent Strategy = "leastRecent" // the instance returned longest ago
+	StrategyHash        Strategy = "hash"        // consistent hashing of Key
+)
+
+// ResolveRequest configures how Resolve picks instances
+type ResolveRequest struct {
+	Strategy Strategy `json:"strategy,omitempty"` // empty for round robin
+	Key      string   `json:"key,omitempty"`      // required by StrategyHash
+	Session  string   `json:"session,omitempty"`  // sticks to the instance first picked for it
+}
+
+// ServiceSummary aggregates the instances registered under one service name
+type ServiceSummary struct {
+	Name          string    `json:"name"`
+	Instances     int       `json:"instances"`
+	Healthy       int       `json:"healthy"`
+	Unhealthy     int       `json:"unhealthy"`
+	Maintenance   int       `json:"maintenance"`
+	Modes         []string  `json:"modes"`
+	Regions       []string  `json:"regions"`
+	Versions      []int     `json:"versions"`
+	LastHeartbeat time.Time `json:"lastHeartbeat"`
+}
+
+// ServiceDetail breaks the instances of a service down by health,
+// maintenance state, mode, region and version
+type ServiceDetail struct {
+	ServiceSummary
+	Health            map[string]int `json:"health"`
+	MaintenanceStates map[string]int `json:"maintenanceStates"`
+	ByMode            map[string]int `json:"byMode"`
+	ByRegion          map[string]int `json:"byRegion"`
+	ByVersion         map[int]int    `json:"byVersion"`
+}
+
+// HeartbeatRequest represents a heartbeat request. An empty status counts
+// as passing.
 type HeartbeatRequest struct {
+	ServiceName string             `json:"serviceName" validate:"required"`
+	ID          string             `json:"id" validate:"required"`
+	Status      HealthStatus       `json:"status,omitempty" validate:"omitempty,oneof=passing warning critical"`
+	Output      string             `json:"output,omitempty" validate:"max=1024"`
+	Load        map[string]float64 `json:"load,omitempty" validate:"max=8"`
+}
+
+// DeregisterRequest represents a deregistration request
+type DeregisterRequest struct {
 	ServiceName string `json:"serviceName" validate:"required"`
 	ID          string `json:"id" validate:"required"`
 }
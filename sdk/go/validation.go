@@ -27,9 +27,79 @@ func (c *Client) validateInstance(instance Instance) error {
 		return fmt.Errorf("mode must be one of: dev, staging, prod")
 	}
 
+	if instance.TTL < 0 {
+		return fmt.Errorf("ttl must be non-negative")
+	}
+
+	if instance.Weight < 0 || instance.Weight > 1000 {
+		return fmt.Errorf("weight must be between 0 and 1000")
+	}
+
+	if len(instance.Checks) > 4 {
+		return fmt.Errorf("at most 4 checks are allowed")
+	}
+	for _, check := range instance.Checks {
+		if check.Type != CheckHTTP && check.Type != CheckTCP && check.Type != CheckGRPC {
+			return fmt.Errorf("check type must be one of: http, tcp, grpc")
+		}
+		if check.Port < 0 || check.Port > 65535 {
+			return fmt.Errorf("check port must be between 0 and 65535")
+		}
+		if check.Interval < 0 || check.Timeout < 0 {
+			return fmt.Errorf("check interval and timeout must be non-negative")
+		}
+	}
+
+	if err := validateLabels(instance.Labels, instance.Tags); err != nil {
+		return err
+	}
+
+	if len(instance.Endpoints) > 16 {
+		return fmt.Errorf("at most 16 endpoints are allowed")
+	}
+	names := map[string]bool{}
+	for _, e := range instance.Endpoints {
+		if e.Name == "" || len(e.Name) > 32 || names[e.Name] {
+			return fmt.Errorf("endpoint names must be unique and have 1 to 32 characters")
+		}
+		names[e.Name] = true
+		if e.Port < 1 || e.Port > 65535 {
+			return fmt.Errorf("endpoint %s port must be between 1 and 65535", e.Name)
+		}
+		if e.Protocol != ProtocolHTTP && e.Protocol != ProtocolHTTPS && e.Protocol != ProtocolGRPC && e.Protocol != ProtocolTCP {
+			return fmt.Errorf("endpoint %s protocol must be one of: http, https, grpc, tcp", e.Name)
+		}
+	}
+
 	return c.validateMetadata(instance.Metadata)
 }
 
+// validateLabels applies the server's limits to labels and tags
+func validateLabels(labels map[string]string, tags []string) error {
+	if len(labels) > 32 {
+		return fmt.Errorf("at most 32 labels are allowed")
+	}
+	for key, value := range labels {
+		if key == "" || len(key) > 63 || strings.ContainsAny(key, ".$") {
+			return fmt.Errorf("label key %q must have 1 to 63 characters and no . or $", key)
+		}
+		if len(value) > 255 {
+			return fmt.Errorf("label %q must be at most 255 characters", key)
+		}
+	}
+
+	if len(tags) > 32 {
+		return fmt.Errorf("at most 32 tags are allowed")
+	}
+	for _, tag := range tags {
+		if tag == "" || len(tag) > 63 {
+			return fmt.Errorf("tag %q must have 1 to 63 characters", tag)
+		}
+	}
+
+	return nil
+}
+
 // validateMetadata validates service metadata
 func (c *Client) validateMetadata(metadata Metadata) error {
 	if metadata.Environment != EnvDev && metadata.Environment != EnvStaging && metadata.Environment != EnvProd {
@@ -46,3 +116,20 @@ func (c *Client) validateMetadata(metadata Metadata) error {
 
 	return nil
 }
+
+// validateMaintenance validates a maintenance request
+func validateMaintenance(req MaintenanceRequest) error {
+	if req.State != StateMaintenance && req.State != StateDraining {
+		return fmt.Errorf("state must be one of: maintenance, draining")
+	}
+
+	if len(req.Reason) > 256 {
+		return fmt.Errorf("reason must be at most 256 characters")
+	}
+
+	if req.Until != nil && req.Duration != "" {
+		return fmt.Errorf("until and duration cannot be combined")
+	}
+
+	return nil
+}
@@ -1,3 +1,4 @@
+import { instanceAddress } from "../address";
 import { ServiceDiscoveryClient } from "../client";
 import { Instance } from "../types";
 
@@ -77,6 +78,59 @@ describe("ServiceDiscoveryClient", () => {
 
 			expect(() => client["validateInstance"](invalidInstance)).toThrow("Environment must be one of: dev, staging, prod");
 		});
+
+		it("should throw error for duplicate endpoint names", () => {
+			const invalidInstance: Instance = {
+				serviceName: "test-service",
+				id: "test-001",
+				host: "127.0.0.1",
+				port: 8080,
+				mode: "dev",
+				metadata: {
+					environment: "dev",
+					region: "us-east",
+					version: 1,
+				},
+				endpoints: [
+					{ name: "grpc", port: 9090, protocol: "grpc" },
+					{ name: "grpc", port: 9091, protocol: "grpc" },
+				],
+			};
+
+			expect(() => client["validateInstance"](invalidInstance)).toThrow("Endpoint names must be unique and have 1 to 32 characters");
+		});
+	});
+
+	describe("instanceAddress", () => {
+		const instance: Instance = {
+			serviceName: "test-service",
+			id: "test-001",
+			host: "10.0.0.1",
+			port: 8080,
+			mode: "dev",
+			metadata: {
+				environment: "dev",
+				region: "us-east",
+				version: 1,
+			},
+			endpoints: [
+				{ name: "grpc", port: 9090, protocol: "grpc" },
+				{ name: "metrics", port: 9100, protocol: "http", path: "/metrics" },
+				{ name: "admin", port: 9200, protocol: "https", address: "https://admin.example.com" },
+			],
+		};
+
+		it("should resolve the address of each endpoint", () => {
+			expect(instanceAddress(instance)).toBe("10.0.0.1:8080");
+			expect(instanceAddress(instance, "grpc")).toBe("10.0.0.1:9090");
+			expect(instanceAddress(instance, "metrics")).toBe("http://10.0.0.1:9100/metrics");
+			expect(instanceAddress(instance, "admin")).toBe("https://admin.example.com");
+			expect(instanceAddress({ ...instance, host: "::1" }, "grpc")).toBe("[::1]:9090");
+		});
+
+		it("should return undefined for an endpoint the instance does not expose", () => {
+			expect(instanceAddress(instance, "debug")).toBeUndefined();
+		});
 	});
 
 	describe("heartbeat status", () => {
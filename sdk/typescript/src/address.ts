@@ -0,0 +1,37 @@
+import { Instance } from "./types";
+
+/**
+ * Join a host and port, bracketing IPv6 hosts
+ */
+function joinHostPort(host: string, port: number): string {
+	return host.includes(":") ? `[${host}]:${port}` : `${host}:${port}`;
+}
+
+/**
+ * Where the named endpoint of an instance is reached: a URL for http and
+ * https endpoints and host:port otherwise. The empty name is the primary
+ * host and port.
+ *
+ * @param instance - Instance exposing the endpoint
+ * @param name - Endpoint name
+ * @returns The address, or undefined when the instance has no such endpoint
+ */
+export function instanceAddress(instance: Instance, name = ""): string | undefined {
+	if (name === "") {
+		return joinHostPort(instance.host, instance.port);
+	}
+
+	const endpoint = instance.endpoints?.find((e) => e.name === name);
+	if (!endpoint) {
+		return undefined;
+	}
+	if (endpoint.address) {
+		return endpoint.address;
+	}
+
+	const hostPort = joinHostPort(instance.host, endpoint.port);
+	if (endpoint.protocol === "http" || endpoint.protocol === "https") {
+		return `${endpoint.protocol}://${hostPort}${endpoint.path ?? ""}`;
+	}
+	return hostPort;
+}
@@ -1,5 +1,6 @@
 import axios, { AxiosError, AxiosInstance } from "axios";
-import { HeartbeatRequest, Instance, LookupFilter, Metadata, ServiceDiscoveryConfig } from "./types";
+import { instanceAddress } from "./address";
+import { Endpoint, HeartbeatRequest, Instance, LookupFilter, Metadata, ServiceDiscoveryConfig } from "./types";
 
 /**
  * Service Discovery Client
@@ -115,6 +116,10 @@ export class ServiceDiscoveryClient {
 				params.service = filter.service;
 			}
 
+			if (filter.endpoint) {
+				params.endpoint = filter.endpoint;
+			}
+
 			if (filter.metadata) {
 				Object.entries(filter.metadata).forEach(([key, value]) => {
 					if (value !== undefined) {
@@ -131,6 +136,18 @@ export class ServiceDiscoveryClient {
 		}
 	}
 
+	/**
+	 * Lookup the address of an endpoint on every matching instance
+	 *
+	 * @param filter - Lookup filter criteria
+	 * @param endpoint - Endpoint name, or empty for the primary host and port
+	 * @returns Address of the endpoint on each instance exposing it
+	 */
+	async lookupAddresses(filter: LookupFilter = {}, endpoint = ""): Promise<string[]> {
+		const instances = await this.lookup({ ...filter, endpoint });
+		return instances.map((instance) => instanceAddress(instance, endpoint)).filter((address): address is string => address !== undefined);
+	}
+
 	/**
 	 * Automatically register a service and start heartbeat
 	 *
@@ -187,9 +204,40 @@ export class ServiceDiscoveryClient {
 			throw new Error("Mode must be one of: dev, staging, prod");
 		}
 
+		this.validateEndpoints(instance.endpoints ?? []);
+
 		this.validateMetadata(instance.metadata);
 	}
 
+	/**
+	 * Validate endpoints
+	 *
+	 * @private
+	 * @param endpoints - Endpoints to validate
+	 * @throws Error if validation fails
+	 */
+	private validateEndpoints(endpoints: Endpoint[]): void {
+		if (endpoints.length > 16) {
+			throw new Error("At most 16 endpoints are allowed");
+		}
+
+		const names = new Set<string>();
+		for (const endpoint of endpoints) {
+			if (!endpoint.name || endpoint.name.length > 32 || names.has(endpoint.name)) {
+				throw new Error("Endpoint names must be unique and have 1 to 32 characters");
+			}
+			names.add(endpoint.name);
+
+			if (!endpoint.port || endpoint.port <= 0 || endpoint.port > 65535) {
+				throw new Error(`Endpoint ${endpoint.name} port must be between 1 and 65535`);
+			}
+
+			if (!["http", "https", "grpc", "tcp"].includes(endpoint.protocol)) {
+				throw new Error(`Endpoint ${endpoint.name} protocol must be one of: http, https, grpc, tcp`);
+			}
+		}
+	}
+
 	/**
 	 * Validate metadata
 	 *
@@ -1,4 +1,5 @@
 // Main exports
+export { instanceAddress } from "./address";
 export { ServiceDiscoveryClient } from "./client";
-export type { Environment, HeartbeatRequest, Instance, LookupFilter, Metadata, ServiceDiscoveryConfig } from "./types";
+export type { Endpoint, Environment, HeartbeatRequest, Instance, LookupFilter, Metadata, Protocol, ServiceDiscoveryConfig } from "./types";
 
@@ -19,6 +19,22 @@ export interface Metadata {
 	experimental?: boolean;
 }
 
+/** Protocol an endpoint speaks */
+export type Protocol = "http" | "https" | "grpc" | "tcp";
+
+export interface Endpoint {
+	/** Name of the endpoint, unique within the instance */
+	name: string;
+	/** Port number */
+	port: number;
+	/** Protocol the endpoint speaks */
+	protocol: Protocol;
+	/** Path of http and https endpoints (optional) */
+	path?: string;
+	/** Address filled in by the server on lookups (optional) */
+	address?: string;
+}
+
 export interface Instance {
 	/** Name of the service */
 	serviceName: string;
@@ -32,6 +48,8 @@ export interface Instance {
 	mode: Environment;
 	/** Service metadata */
 	metadata: Metadata;
+	/** Named ports besides host and port (optional) */
+	endpoints?: Endpoint[];
 	/** Health status (optional) */
 	health?: string;
 	/** Last heartbeat timestamp (optional) */
@@ -41,6 +59,8 @@ export interface Instance {
 export interface LookupFilter {
 	/** Service name to filter by */
 	service?: string;
+	/** Name of an endpoint instances must expose */
+	endpoint?: string;
 	/** Metadata filters */
 	metadata?: Partial<Metadata>;
 }
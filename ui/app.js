@@ -6,6 +6,7 @@ class ServiceDiscoveryDashboard {
   this.services = [];
   this.filteredServices = [];
   this.websocket = null;
+  this.revision = 0; // Last registry revision received, used to resume after reconnecting
   this.reconnectAttempts = 0;
   this.maxReconnectAttempts = 5;
 
@@ -40,7 +41,7 @@ class ServiceDiscoveryDashboard {
   this.hideError();
 
   try {
-   const response = await fetch(`${this.baseUrl}/lookup`);
+   const response = await fetch(`${this.baseUrl}/lookup?includeUnhealthy=true&includeMaintenance=true`);
    if (!response.ok) {
     throw new Error(`HTTP ${response.status}: ${response.statusText}`);
    }
@@ -61,7 +62,12 @@ class ServiceDiscoveryDashboard {
   const environmentFilter = this.environmentFilter.value;
 
   this.filteredServices = this.services.filter((service) => {
-   const matchesSearch = service.serviceName.toLowerCase().includes(searchTerm) || service.id.toLowerCase().includes(searchTerm) || service.host.toLowerCase().includes(searchTerm);
+   const matchesSearch =
+    service.serviceName.toLowerCase().includes(searchTerm) ||
+    service.id.toLowerCase().includes(searchTerm) ||
+    service.host.toLowerCase().includes(searchTerm) ||
+    (service.tags || []).some((tag) => tag.toLowerCase().includes(searchTerm)) ||
+    Object.entries(service.labels || {}).some(([key, value]) => `${key}=${value}`.toLowerCase().includes(searchTerm));
 
    const matchesEnvironment = !environmentFilter || service.metadata.environment === environmentFilter;
 
@@ -82,16 +88,26 @@ class ServiceDiscoveryDashboard {
  }
 
  isServiceActive(service) {
-  if (!service.lastHeartbeat) return false;
+  if (!service.lastHeartbeat || service.health === "CRITICAL") return false;
+
+  if (service.expiresAt) return new Date(service.expiresAt) > new Date();
 
   const lastHeartbeat = new Date(service.lastHeartbeat);
   const now = new Date();
   const timeDiff = now - lastHeartbeat;
-  const ttl = 30 * 1000; // 30 seconds TTL
+  const ttl = (service.ttl || 30) * 1000; // default 30 seconds TTL
 
   return timeDiff < ttl;
  }
 
+ // maintenanceState returns "maintenance" or "draining" while the instance is in maintenance
+ maintenanceState(service) {
+  const m = service.maintenance;
+  if (!m) return null;
+  if (m.until && new Date(m.until) <= new Date()) return null;
+  return m.state;
+ }
+
  renderServices() {
   this.servicesGrid.innerHTML = "";
 
@@ -111,13 +127,29 @@ class ServiceDiscoveryDashboard {
   });
  }
 
+ // escapeHtml makes free-form text reported by instances safe to render
+ escapeHtml(text) {
+  const div = document.createElement("div");
+  div.textContent = text;
+  return div.innerHTML;
+ }
+
  createServiceCard(service) {
   const card = document.createElement("div");
   card.className = "service-card";
 
   const isActive = this.isServiceActive(service);
-  const statusClass = isActive ? "status-active" : "status-inactive";
-  const statusText = isActive ? "Active" : "Inactive";
+  const isWarning = isActive && service.health === "WARNING";
+  const maintenance = isActive && this.maintenanceState(service);
+  let statusClass = isWarning ? "status-warning" : isActive ? "status-active" : "status-inactive";
+  let statusText = isWarning ? "Warning" : isActive ? "Active" : service.health === "CRITICAL" ? "Critical" : "Inactive";
+  if (maintenance) {
+   statusClass = "status-maintenance";
+   statusText = maintenance === "draining" ? "Draining" : "Maintenance";
+  }
+  const load = Object.entries(service.load || {})
+   .map(([name, value]) => `${name}: ${value}`)
+   .join(", ");
 
   const lastHeartbeat = service.lastHeartbeat ? new Date(service.lastHeartbeat).toLocaleString() : "Never";
 
@@ -138,7 +170,7 @@ class ServiceDiscoveryDashboard {
                     </div>
                     <div class="info-item">
                         <div class="info-label">Region</div>
-                        <div class="info-value">${service.metadata.region}</div>
+                        <div class="info-value">${service.metadata.region}${service.metadata.zone ? ` / ${this.escapeHtml(service.metadata.zone)}` : ''}</div>
                     </div>
                     <div class="info-item">
                         <div class="info-label">Version</div>
@@ -155,6 +187,50 @@ class ServiceDiscoveryDashboard {
                         <div class="info-value">${lastHeartbeat}</div>
                     </div>
                 </div>
+                ${
+                 service.output || service.checkOutput || load
+                  ? `
+                    <div class="info-item" style="grid-column: 1 / -1;">
+                        <div class="info-label">Health Output</div>
+                        <div class="info-value">${this.escapeHtml([service.output, service.checkOutput, load].filter(Boolean).join(" · "))}</div>
+                    </div>
+                `
+                  : ""
+                }
+                ${
+                 maintenance
+                  ? `
+                    <div class="info-item" style="grid-column: 1 / -1;">
+                        <div class="info-label">${statusText}</div>
+                        <div class="info-value">${this.escapeHtml(
+                         [service.maintenance.reason, service.maintenance.until ? `until ${new Date(service.maintenance.until).toLocaleString()}` : ""].filter(Boolean).join(" · ") || "No reason given",
+                        )}</div>
+                    </div>
+                `
+                  : ""
+                }
+                ${
+                 service.endpoints?.length
+                  ? `
+                    <div class="info-item" style="grid-column: 1 / -1;">
+                        <div class="info-label">Endpoints</div>
+                        <div class="info-value">${this.escapeHtml(service.endpoints.map((e) => `${e.name} (${e.protocol}) :${e.port}${e.path || ""}`).join(" · "))}</div>
+                    </div>
+                `
+                  : ""
+                }
+                ${
+                 service.tags?.length || Object.keys(service.labels || {}).length
+                  ? `
+                    <div class="service-labels" style="grid-column: 1 / -1;">
+                        ${(service.tags || []).map((tag) => `<span class="tag">${this.escapeHtml(tag)}</span>`).join("")}
+                        ${Object.entries(service.labels || {})
+                         .map(([key, value]) => `<span class="label">${this.escapeHtml(key)}: ${this.escapeHtml(value)}</span>`)
+                         .join("")}
+                    </div>
+                `
+                  : ""
+                }
                 ${
                  service.metadata.developer
                   ? `
@@ -190,7 +266,11 @@ class ServiceDiscoveryDashboard {
  }
 
  connectWebSocket() {
-  const wsUrl = this.baseUrl.replace(/^http/, "ws") + "/ws";
+  // Critical instances and those in maintenance stay on the dashboard until they are removed
+  let wsUrl = this.baseUrl.replace(/^http/, "ws") + "/ws?includeUnhealthy=true&includeMaintenance=true";
+  if (this.revision) {
+   wsUrl += `&since=${this.revision}`;
+  }
   console.log("Connecting to WebSocket:", wsUrl);
 
   try {
@@ -205,9 +285,10 @@ class ServiceDiscoveryDashboard {
    this.websocket.onmessage = (event) => {
     try {
      const data = JSON.parse(event.data);
-     if (data.type === "services_update") {
-      console.log("Received services update via WebSocket");
-      this.loadServices(); // Reload services when update received
+     if (data.action === "snapshot") {
+      this.applySnapshot(data);
+     } else if (data.action && data.service) {
+      this.applyUpdate(data);
      }
     } catch (error) {
      console.error("Failed to parse WebSocket message:", error);
@@ -228,6 +309,31 @@ class ServiceDiscoveryDashboard {
   }
  }
 
+ applySnapshot(snapshot) {
+  this.revision = snapshot.revision;
+  this.services = snapshot.services;
+  this.updateStats();
+  this.filterServices();
+  this.hideLoading();
+ }
+
+ applyUpdate(update) {
+  const { action, service } = update;
+  this.revision = update.revision;
+  const index = this.services.findIndex((s) => s.serviceName === service.serviceName && s.id === service.id);
+
+  if (action === "deregister" || update.left) {
+   if (index !== -1) this.services.splice(index, 1);
+  } else if (index !== -1) {
+   this.services[index] = service;
+  } else {
+   this.services.push(service);
+  }
+
+  this.updateStats();
+  this.filterServices();
+ }
+
  attemptReconnect() {
   if (this.reconnectAttempts >= this.maxReconnectAttempts) {
    console.error("Max WebSocket reconnection attempts reached");